@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// endpoint is a connection of a client to the server.
+type endpoint interface {
+	send(m benchMsg) error
+	recv() (neti.Message, error)
+	close()
+}
+
+func openEndpoint(c config) (e endpoint, err error) {
+	defer func() {
+		// services panic when they can not listen
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprint(p))
+		}
+	}()
+	switch c.transport {
+	case "tcp":
+		n := neti.NewTcpNet(log.StandardLogger())
+		n.RegisterMessage(benchMsg{})
+		conn, err := n.Open(c.addr)
+		if err != nil {
+			return nil, err
+		}
+		return &netEndpoint{net: n, conn: conn}, nil
+	case "udp":
+		n := neti.NewUdpNet(c.buffsize)
+		n.RegisterMessage(benchMsg{})
+		replies, err := n.Listen(clientAddr(c.addr))
+		if err != nil {
+			return nil, err
+		}
+		conn, err := n.Open(c.addr)
+		if err != nil {
+			_ = n.CloseListener()
+			return nil, err
+		}
+		return &netEndpoint{net: n, conn: conn, replies: replies}, nil
+	case "tcp-service":
+		return openServiceEndpoint(c, neti.InitBaseTcpService(clientAddr(c.addr), log.StandardLogger()))
+	default:
+		return openServiceEndpoint(c, neti.InitBaseUdpService(clientAddr(c.addr), c.buffsize))
+	}
+}
+
+// netEndpoint is a connection of a Net, replies are read from replies for datagrams.
+type netEndpoint struct {
+	net     neti.Net
+	conn    neti.HostConn
+	replies <-chan neti.HostConn
+}
+
+func (e *netEndpoint) send(m benchMsg) error {
+	return e.net.SendTo(e.conn, m)
+}
+
+func (e *netEndpoint) recv() (neti.Message, error) {
+	if e.replies == nil {
+		return e.net.RecvFrom(e.conn)
+	}
+	for conn := range e.replies {
+		m, err := e.net.RecvFrom(conn)
+		if errors.Is(err, neti.ErrDropped) {
+			continue
+		}
+		return m, err
+	}
+	return nil, io.EOF
+}
+
+func (e *netEndpoint) close() {
+	if e.replies != nil {
+		_ = e.net.CloseListener()
+	} else {
+		_ = e.conn.Close()
+	}
+}
+
+func openServiceEndpoint(c config, service neti.NetService) (endpoint, error) {
+	client := service.RegisterListener(serviceId)
+	client.RegisterMessage(benchMsg{})
+	conn, err := client.OpenTo(c.addr, serviceId)
+	if err != nil {
+		_ = service.Close()
+		return nil, err
+	}
+	return &serviceEndpoint{service: service, client: client, conn: conn, closed: make(chan struct{})}, nil
+}
+
+// serviceEndpoint is a connection of a NetClient, replies are accepted as connections for datagrams.
+type serviceEndpoint struct {
+	service neti.NetService
+	client  neti.NetClient
+	conn    *neti.ServiceHostConn
+	closed  chan struct{} //Closed with the endpoint, as closing the service does not close Accept
+}
+
+func (e *serviceEndpoint) send(m benchMsg) error {
+	return e.client.SendTo(e.conn, m)
+}
+
+func (e *serviceEndpoint) recv() (neti.Message, error) {
+	if e.client.Type() != neti.UDP {
+		return e.client.RecvFrom(e.conn)
+	}
+	select {
+	case conn := <-e.client.Accept():
+		return e.client.RecvFrom(conn)
+	case <-e.closed:
+		return nil, io.EOF
+	}
+}
+
+func (e *serviceEndpoint) close() {
+	close(e.closed)
+	_ = e.conn.Close()
+	_ = e.service.Close()
+}
+
+// worker sends the messages of a connection and measures the round trip times of their replies.
+type worker struct {
+	config    config
+	endpoint  endpoint
+	lock      *sync.Mutex
+	inflight  map[uint64]time.Time //Send times of the messages awaiting a reply, by sequence number
+	acked     chan struct{}
+	closed    bool
+	latencies []time.Duration
+	sent      uint64
+	received  uint64
+	lost      uint64
+	errors    uint64
+	err       error
+}
+
+func (w *worker) run(start time.Time) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.receive()
+	}()
+	w.sendAll(start)
+	w.wait(w.config.timeout)
+	w.lock.Lock()
+	w.lost += uint64(len(w.inflight))
+	w.closed = true
+	w.lock.Unlock()
+	w.endpoint.close()
+	<-done
+}
+
+func (w *worker) sendAll(start time.Time) {
+	deadline := start.Add(w.config.duration)
+	payload := make([]byte, w.config.size)
+	ticker := time.NewTicker(w.config.timeout / 4)
+	defer ticker.Stop()
+	for seq := uint64(0); ; seq++ {
+		if w.config.rate > 0 {
+			next := start.Add(time.Duration(float64(seq) * float64(time.Second) / w.config.rate))
+			if next.After(deadline) {
+				return
+			}
+			time.Sleep(time.Until(next))
+		}
+		for w.config.inflight > 0 && w.pending() >= w.config.inflight && time.Now().Before(deadline) {
+			select {
+			case <-w.acked:
+			case <-ticker.C:
+				w.expire(w.config.timeout)
+			}
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			return
+		}
+		w.lock.Lock()
+		w.inflight[seq] = now
+		w.lock.Unlock()
+		if err := w.endpoint.send(benchMsg{seq: seq, sent: now.UnixNano(), payload: payload}); err != nil {
+			w.lock.Lock()
+			delete(w.inflight, seq)
+			w.errors++
+			w.err = err
+			w.lock.Unlock()
+			return
+		}
+		w.lock.Lock()
+		w.sent++
+		w.lock.Unlock()
+	}
+}
+
+// wait waits for the replies of the messages in flight, for at most timeout.
+func (w *worker) wait(timeout time.Duration) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.Now().Add(timeout)
+	for w.pending() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-w.acked:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *worker) receive() {
+	for {
+		m, err := w.endpoint.recv()
+		now := time.Now()
+		w.lock.Lock()
+		if w.closed {
+			w.lock.Unlock()
+			return
+		}
+		if err != nil {
+			w.errors++
+			w.err = err
+			w.lock.Unlock()
+			var decodeErr *neti.DecodeError
+			if errors.As(err, &decodeErr) {
+				continue
+			}
+			return
+		}
+		if reply, ok := m.(benchMsg); ok {
+			// replies of messages already counted as lost are ignored
+			if _, ok := w.inflight[reply.seq]; ok {
+				delete(w.inflight, reply.seq)
+				w.latencies = append(w.latencies, now.Sub(time.Unix(0, reply.sent)))
+				w.received++
+			}
+		}
+		w.lock.Unlock()
+		select {
+		case w.acked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *worker) pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.inflight)
+}
+
+// expire counts as lost the messages in flight for longer than timeout.
+func (w *worker) expire(timeout time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for seq, sent := range w.inflight {
+		if time.Since(sent) > timeout {
+			delete(w.inflight, seq)
+			w.lost++
+		}
+	}
+}
+
+// run runs the clients of the benchmark, returning their results.
+func run(c config) (*result, error) {
+	workers := make([]*worker, 0, c.conns)
+	for i := 0; i < c.conns; i++ {
+		e, err := openEndpoint(c)
+		if err != nil {
+			for _, w := range workers {
+				w.endpoint.close()
+			}
+			return nil, errors.New(fmt.Sprintf("Unable to connect to %v: %v", c.addr, err))
+		}
+		workers = append(workers, &worker{
+			config:   c,
+			endpoint: e,
+			lock:     &sync.Mutex{},
+			inflight: make(map[uint64]time.Time),
+			acked:    make(chan struct{}, 1),
+		})
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(start)
+		}(w)
+	}
+	wg.Wait()
+	runtime.ReadMemStats(&after)
+
+	r := newResult(c)
+	for _, w := range workers {
+		r.add(w)
+	}
+	r.summarize()
+	r.allocations(before, after)
+	return r, nil
+}
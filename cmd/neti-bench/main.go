@@ -0,0 +1,189 @@
+// neti-bench measures the throughput and latency of the transports of neti, and of the services on top of them.
+//
+// Clients send messages of a given size to an echo server on a number of connections, at a given rate or as fast
+// as the replies come back, and the round trip times of the replies are reported with the allocations of the process.
+//
+// Usage:
+//
+//	neti-bench -transport tcp -size 1024 -conns 8 -duration 10s
+//	neti-bench -mode server -transport udp-service -addr 0.0.0.0:17500
+//	neti-bench -mode client -transport udp-service -addr 10.0.0.1:17500 -rate 1000 -inflight 0 -format csv
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const serviceId = "bench"
+
+const (
+	// maxDatagram is the largest payload of a UDP datagram over IPv4.
+	maxDatagram = math.MaxUint16 - 8 - 20
+	// frameHeader is the code of the Net message and the header of benchMsg, preceding the payload.
+	frameHeader = 2 + 16
+	// serviceHeader is the service id and the code of the message wrapped by services.
+	serviceHeader = 2 + len(serviceId) + 2
+)
+
+// maxSize returns the largest payload of the messages of transport: services encode their frames with a 16 bits
+// length, and the services over UDP prefix them with the service id and that length.
+func maxSize(transport string) int {
+	switch transport {
+	case "udp":
+		return maxDatagram - frameHeader
+	case "tcp-service":
+		return math.MaxUint16 - serviceHeader - frameHeader
+	case "udp-service":
+		return maxDatagram - (2 + len(serviceId) + 2) - serviceHeader - frameHeader
+	}
+	return math.MaxInt
+}
+
+// benchMsg is echoed by the server, carrying the time it was sent by the client.
+type benchMsg struct {
+	seq     uint64
+	sent    int64
+	payload []byte
+}
+
+func (m benchMsg) String() string {
+	return fmt.Sprintf("%v{%v, %v bytes}", m.Name(), m.seq, len(m.payload))
+}
+
+func (m benchMsg) Name() string {
+	return "Bench"
+}
+
+func (m benchMsg) Code() uint16 {
+	return 1
+}
+
+func (m benchMsg) Serialize(buff *bytes.Buffer) error {
+	var header [16]byte
+	binary.BigEndian.PutUint64(header[:8], m.seq)
+	binary.BigEndian.PutUint64(header[8:], uint64(m.sent))
+	buff.Write(header[:])
+	buff.Write(m.payload)
+	return nil
+}
+
+func (m benchMsg) Deserialize(buff *bytes.Buffer) (neti.Message, error) {
+	header := buff.Next(16)
+	if len(header) != 16 {
+		return nil, errors.New("bench message too short")
+	}
+	return benchMsg{
+		seq:     binary.BigEndian.Uint64(header[:8]),
+		sent:    int64(binary.BigEndian.Uint64(header[8:])),
+		payload: buff.Bytes(),
+	}, nil
+}
+
+type config struct {
+	mode      string
+	transport string
+	addr      string
+	size      int
+	rate      float64
+	conns     int
+	inflight  int
+	duration  time.Duration
+	timeout   time.Duration
+	buffsize  int
+	format    string
+	noHeader  bool
+}
+
+func main() {
+	var c config
+	flag.StringVar(&c.mode, "mode", "both", "both (server and clients in this process), server or client")
+	flag.StringVar(&c.transport, "transport", "tcp", "tcp, udp, tcp-service or udp-service")
+	flag.StringVar(&c.addr, "addr", "127.0.0.1:17500", "address of the server")
+	flag.IntVar(&c.size, "size", 64, "payload size of the messages, in bytes")
+	flag.Float64Var(&c.rate, "rate", 0, "messages per second per connection, 0 for as fast as possible")
+	flag.IntVar(&c.conns, "conns", 1, "number of concurrent connections")
+	flag.IntVar(&c.inflight, "inflight", 1, "messages awaiting a reply per connection, 0 for no limit")
+	flag.DurationVar(&c.duration, "duration", 10*time.Second, "duration of the benchmark")
+	flag.DurationVar(&c.timeout, "timeout", time.Second, "time after which a message without a reply is lost")
+	flag.IntVar(&c.buffsize, "buffsize", 64*1024, "size of the datagram buffers of UDP")
+	flag.StringVar(&c.format, "format", "text", "output format: text, csv or json")
+	flag.BoolVar(&c.noHeader, "no-header", false, "do not print the CSV header, to append runs to a file")
+	flag.Parse()
+	log.SetLevel(log.WarnLevel)
+
+	if err := c.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var srv *server
+	if c.mode != "client" {
+		var err error
+		if srv, err = startServer(c); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to start the server:", err)
+			os.Exit(1)
+		}
+		defer srv.close()
+	}
+	if c.mode == "server" {
+		fmt.Fprintf(os.Stderr, "Echoing %v on %v\n", c.transport, c.addr)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		return
+	}
+
+	r, err := run(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := r.write(os.Stdout, c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (c config) validate() error {
+	switch {
+	case c.mode != "both" && c.mode != "server" && c.mode != "client":
+		return errors.New(fmt.Sprintf("Unknown mode %q", c.mode))
+	case c.transport != "tcp" && c.transport != "udp" && c.transport != "tcp-service" && c.transport != "udp-service":
+		return errors.New(fmt.Sprintf("Unknown transport %q", c.transport))
+	case c.format != "text" && c.format != "csv" && c.format != "json":
+		return errors.New(fmt.Sprintf("Unknown format %q", c.format))
+	case c.size < 0 || c.conns < 1 || c.inflight < 0 || c.rate < 0 || c.duration <= 0 || c.timeout <= 0:
+		return errors.New("-size, -rate and -inflight must not be negative, -conns, -duration and -timeout must be positive")
+	case strings.HasPrefix(c.transport, "udp") && c.size+64 > c.buffsize:
+		return errors.New(fmt.Sprintf("-size %v does not fit in a datagram of -buffsize %v", c.size, c.buffsize))
+	case c.size > maxSize(c.transport):
+		return errors.New(fmt.Sprintf("-size %v is over the maximum of %v, %v", c.size, c.transport, maxSize(c.transport)))
+	}
+	return nil
+}
+
+// clientAddr returns the address the clients listen on: the loopback for a server on the loopback, or else any address.
+func clientAddr(addr string) string {
+	if strings.HasPrefix(addr, "127.") || strings.HasPrefix(addr, "localhost:") || strings.HasPrefix(addr, "[::1]:") {
+		return "127.0.0.1:0"
+	}
+	return ":0"
+}
+
+func (c config) String() string {
+	return fmt.Sprintf("%v, %v connections, %v bytes, %v in flight, rate %v/s, %v", c.transport, c.conns, c.size,
+		c.inflight, c.rate, c.duration)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/nettest"
+	"testing"
+	"time"
+)
+
+// testConfig returns a short benchmark of transport against a server on a free address of the loopback.
+func testConfig(t *testing.T, transport string) config {
+	t.Helper()
+	return config{mode: "both", transport: transport, addr: nettest.LocalAddr(t), size: 128, conns: 2, inflight: 4,
+		duration: 200 * time.Millisecond, timeout: time.Second, buffsize: 64 * 1024, format: "text"}
+}
+
+func TestBenchMsg(t *testing.T) {
+	m := benchMsg{seq: 7, sent: 42, payload: []byte("payload")}
+	buff := new(bytes.Buffer)
+	if err := m.Serialize(buff); err != nil {
+		t.Fatal(err)
+	}
+	d, err := benchMsg{}.Deserialize(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := d.(benchMsg); d.seq != 7 || d.sent != 42 || string(d.payload) != "payload" {
+		t.Fatalf("deserialized %+v", d)
+	}
+	if _, err := (benchMsg{}).Deserialize(bytes.NewBuffer([]byte{1, 2})); err == nil {
+		t.Fatal("deserialized a truncated message")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := testConfig(t, "tcp")
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, change := range map[string]func(*config){
+		"Mode":      func(c *config) { c.mode = "peer" },
+		"Transport": func(c *config) { c.transport = "sctp" },
+		"Format":    func(c *config) { c.format = "xml" },
+		"Conns":     func(c *config) { c.conns = 0 },
+		"Rate":      func(c *config) { c.rate = -1 },
+		"Datagram":  func(c *config) { c.transport, c.size, c.buffsize = "udp", 1024, 1024 },
+		"Service":   func(c *config) { c.transport, c.size, c.buffsize = "udp-service", maxSize("udp-service")+1, 1<<20 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := valid
+			change(&c)
+			if err := c.validate(); err == nil {
+				t.Fatalf("validated %+v", c)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	r := newResult(config{transport: "tcp", size: 1000, duration: 2 * time.Second})
+	r.Received = 100
+	for i := 1; i <= 100; i++ {
+		r.latencies = append(r.latencies, time.Duration(i)*time.Microsecond)
+	}
+	r.summarize()
+	if r.MsgsPerSec != 50 || r.MBPerSec != 0.05 {
+		t.Fatalf("%v msg/s and %v MB/s, expected 50 and 0.05", r.MsgsPerSec, r.MBPerSec)
+	}
+	if r.MinMicros != 1 || r.MaxMicros != 100 || r.P50Micros != 50 || r.P90Micros != 90 || r.P99Micros != 99 || r.MeanMicros != 50.5 {
+		t.Fatalf("latencies %+v", r)
+	}
+	total := 0
+	for i, b := range r.Histogram {
+		total += b.Count
+		if i > 0 && b.UpperMicros != 2*r.Histogram[i-1].UpperMicros {
+			t.Fatalf("histogram %v, expected buckets doubling", r.Histogram)
+		}
+	}
+	if total != 100 || r.Histogram[0].UpperMicros != 8 || r.Histogram[0].Count != 8 {
+		t.Fatalf("histogram %v, expected the 100 latencies from 8us up", r.Histogram)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	c := testConfig(t, "tcp")
+	r := newResult(c)
+	r.Sent, r.Received = 10, 10
+
+	c.format = "csv"
+	buff := new(bytes.Buffer)
+	if err := r.write(buff, c); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(buff).ReadAll()
+	if err != nil || len(records) != 2 || len(records[0]) != len(records[1]) || records[0][0] != "transport" {
+		t.Fatalf("wrote %v, %v; expected the header and a record", records, err)
+	}
+	c.noHeader = true
+	buff.Reset()
+	_ = r.write(buff, c)
+	if records, _ := csv.NewReader(buff).ReadAll(); len(records) != 1 || records[0][0] != "tcp" {
+		t.Fatalf("wrote %v, expected a record without header", records)
+	}
+
+	c.format = "json"
+	buff.Reset()
+	_ = r.write(buff, c)
+	var decoded result
+	if err := json.Unmarshal(buff.Bytes(), &decoded); err != nil || decoded.Sent != 10 || decoded.Transport != "tcp" {
+		t.Fatalf("wrote %v, %v", buff.String(), err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, transport := range []string{"tcp", "udp", "tcp-service", "udp-service"} {
+		t.Run(transport, func(t *testing.T) {
+			c := testConfig(t, transport)
+			srv, err := startServer(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.close()
+			r, err := run(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Sent == 0 || r.Received == 0 || r.Errors != 0 || r.Received+r.Lost > r.Sent || len(r.latencies) != int(r.Received) {
+				t.Fatalf("sent %v, received %v, lost %v, %v errors (%v)", r.Sent, r.Received, r.Lost, r.Errors, r.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bucket counts the round trips that took at most UpperMicros, and more than the upper bound of the previous bucket.
+type bucket struct {
+	UpperMicros float64 `json:"le_us"`
+	Count       int     `json:"count"`
+}
+
+// result is the outcome of a benchmark, latencies are round trip times in microseconds.
+type result struct {
+	Transport    string   `json:"transport"`
+	Conns        int      `json:"conns"`
+	Size         int      `json:"size"`
+	Rate         float64  `json:"rate"`
+	Inflight     int      `json:"inflight"`
+	Seconds      float64  `json:"seconds"`
+	Sent         uint64   `json:"sent"`
+	Received     uint64   `json:"received"`
+	Lost         uint64   `json:"lost"`
+	Errors       uint64   `json:"errors"`
+	MsgsPerSec   float64  `json:"msgs_per_sec"`
+	MBPerSec     float64  `json:"mb_per_sec"` //Payload echoed per second, in one direction
+	MinMicros    float64  `json:"min_us"`
+	MeanMicros   float64  `json:"mean_us"`
+	P50Micros    float64  `json:"p50_us"`
+	P90Micros    float64  `json:"p90_us"`
+	P99Micros    float64  `json:"p99_us"`
+	P999Micros   float64  `json:"p999_us"`
+	MaxMicros    float64  `json:"max_us"`
+	AllocsPerMsg float64  `json:"allocs_per_msg"` //Allocations of the process per message sent, server included in mode both
+	BytesPerMsg  float64  `json:"bytes_per_msg"`
+	Histogram    []bucket `json:"histogram"`
+	Error        string   `json:"error,omitempty"` //Last error of a connection, if any
+
+	latencies []time.Duration
+}
+
+func newResult(c config) *result {
+	return &result{
+		Transport: c.transport,
+		Conns:     c.conns,
+		Size:      c.size,
+		Rate:      c.rate,
+		Inflight:  c.inflight,
+		Seconds:   c.duration.Seconds(),
+	}
+}
+
+func (r *result) add(w *worker) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	r.Sent += w.sent
+	r.Received += w.received
+	r.Lost += w.lost
+	r.Errors += w.errors
+	if w.err != nil {
+		r.Error = w.err.Error()
+	}
+	r.latencies = append(r.latencies, w.latencies...)
+}
+
+// summarize computes the throughput, percentiles and histogram of the latencies.
+func (r *result) summarize() {
+	r.MsgsPerSec = float64(r.Received) / r.Seconds
+	r.MBPerSec = r.MsgsPerSec * float64(r.Size) / 1e6
+	n := len(r.latencies)
+	if n == 0 {
+		return
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	micros := func(d time.Duration) float64 { return float64(d) / float64(time.Microsecond) }
+	percentile := func(p float64) float64 {
+		return micros(r.latencies[int(math.Ceil(p*float64(n)))-1])
+	}
+	var total time.Duration
+	for _, l := range r.latencies {
+		total += l
+	}
+	r.MinMicros, r.MaxMicros = micros(r.latencies[0]), micros(r.latencies[n-1])
+	r.MeanMicros = micros(total / time.Duration(n))
+	r.P50Micros, r.P90Micros, r.P99Micros, r.P999Micros = percentile(0.5), percentile(0.9), percentile(0.99), percentile(0.999)
+
+	// buckets doubling from 8us up to the maximum
+	r.Histogram = nil
+	upper, i := 8*time.Microsecond, 0
+	for i < n {
+		b := bucket{UpperMicros: micros(upper)}
+		for ; i < n && r.latencies[i] <= upper; i++ {
+			b.Count++
+		}
+		r.Histogram = append(r.Histogram, b)
+		upper *= 2
+	}
+}
+
+func (r *result) allocations(before runtime.MemStats, after runtime.MemStats) {
+	if r.Sent == 0 {
+		return
+	}
+	r.AllocsPerMsg = float64(after.Mallocs-before.Mallocs) / float64(r.Sent)
+	r.BytesPerMsg = float64(after.TotalAlloc-before.TotalAlloc) / float64(r.Sent)
+}
+
+var csvHeader = []string{"transport", "conns", "size", "rate", "inflight", "seconds", "sent", "received", "lost", "errors",
+	"msgs_per_sec", "mb_per_sec", "min_us", "mean_us", "p50_us", "p90_us", "p99_us", "p999_us", "max_us",
+	"allocs_per_msg", "bytes_per_msg"}
+
+func (r *result) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	return []string{r.Transport, strconv.Itoa(r.Conns), strconv.Itoa(r.Size), f(r.Rate), strconv.Itoa(r.Inflight),
+		f(r.Seconds), u(r.Sent), u(r.Received), u(r.Lost), u(r.Errors), f(round(r.MsgsPerSec)), f(round(r.MBPerSec)),
+		f(round(r.MinMicros)), f(round(r.MeanMicros)), f(round(r.P50Micros)), f(round(r.P90Micros)), f(round(r.P99Micros)),
+		f(round(r.P999Micros)), f(round(r.MaxMicros)), f(round(r.AllocsPerMsg)), f(round(r.BytesPerMsg))}
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func (r *result) write(w io.Writer, c config) error {
+	switch c.format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if !c.noHeader {
+			_ = writer.Write(csvHeader)
+		}
+		_ = writer.Write(r.csvRecord())
+		writer.Flush()
+		return writer.Error()
+	}
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%v\n\n", c)
+	_, _ = fmt.Fprintf(&b, "messages   %v sent, %v received, %v lost, %v errors\n", r.Sent, r.Received, r.Lost, r.Errors)
+	_, _ = fmt.Fprintf(&b, "throughput %.0f msg/s, %.2f MB/s\n", r.MsgsPerSec, r.MBPerSec)
+	_, _ = fmt.Fprintf(&b, "latency    min %.1fus, mean %.1fus, p50 %.1fus, p90 %.1fus, p99 %.1fus, p999 %.1fus, max %.1fus\n",
+		r.MinMicros, r.MeanMicros, r.P50Micros, r.P90Micros, r.P99Micros, r.P999Micros, r.MaxMicros)
+	_, _ = fmt.Fprintf(&b, "allocs     %.1f allocs/msg, %.0f B/msg\n", r.AllocsPerMsg, r.BytesPerMsg)
+	if r.Error != "" {
+		_, _ = fmt.Fprintf(&b, "error      %v\n", r.Error)
+	}
+	if len(r.Histogram) > 0 {
+		_, _ = fmt.Fprintf(&b, "\n%12v  %10v\n", "<= us", "count")
+		max := 0
+		for _, bk := range r.Histogram {
+			if bk.Count > max {
+				max = bk.Count
+			}
+		}
+		for _, bk := range r.Histogram {
+			_, _ = fmt.Fprintf(&b, "%12v  %10v  %v\n", bk.UpperMicros, bk.Count, strings.Repeat("#", bk.Count*50/max))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
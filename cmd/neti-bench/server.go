@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+)
+
+// server echoes the messages of the clients.
+type server struct {
+	close func()
+}
+
+func startServer(c config) (*server, error) {
+	switch c.transport {
+	case "tcp":
+		return echoNet(neti.NewTcpNet(log.StandardLogger()), c.addr, true)
+	case "udp":
+		return echoNet(neti.NewUdpNet(c.buffsize), c.addr, false)
+	case "tcp-service":
+		return echoService(c, func() neti.NetService { return neti.InitBaseTcpService(c.addr, log.StandardLogger()) })
+	default:
+		return echoService(c, func() neti.NetService { return neti.InitBaseUdpService(c.addr, c.buffsize) })
+	}
+}
+
+// echoNet echoes the messages received by n, reading each connection in its own goroutine for streams.
+func echoNet(n neti.Net, addr string, stream bool) (*server, error) {
+	n.RegisterMessage(benchMsg{})
+	conns, err := n.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	echo := func(conn neti.HostConn) bool {
+		m, err := n.RecvFrom(conn)
+		if errors.Is(err, neti.ErrDropped) {
+			return true
+		} else if err != nil {
+			return false
+		}
+		return n.SendTo(conn, m) == nil
+	}
+	go func() {
+		for conn := range conns {
+			if !stream {
+				echo(conn)
+				continue
+			}
+			go func(conn neti.HostConn) {
+				for echo(conn) {
+				}
+				_ = conn.Close()
+			}(conn)
+		}
+	}()
+	return &server{close: func() { _ = n.CloseListener() }}, nil
+}
+
+// echoService echoes the messages received by the NetClient of the benchmark of a service.
+func echoService(c config, start func() neti.NetService) (s *server, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprint(p))
+		}
+	}()
+	service := start()
+	client := service.RegisterListener(serviceId)
+	client.RegisterMessage(benchMsg{})
+	go func() {
+		for conn := range client.Accept() {
+			if client.Type() == neti.UDP {
+				if m, err := client.RecvFrom(conn); err == nil {
+					_ = client.SendTo(conn, m)
+				}
+				continue
+			}
+			go func(conn *neti.ServiceHostConn) {
+				for {
+					m, err := client.RecvFrom(conn)
+					if err != nil {
+						_ = conn.Close()
+						return
+					}
+					if err = client.SendTo(conn, m); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return &server{close: func() { _ = service.Close() }}, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sync"
+)
+
+// cat sends the messages of stdin to its peers, and writes the messages it receives to stdout.
+// When connecting, the peer is the target; when listening, the peers are those that connected or sent datagrams.
+type cat struct {
+	config    config
+	out       writer
+	net       neti.Net
+	listening bool
+	service   neti.NetService
+	client    neti.NetClient
+	lock      *sync.Mutex
+	peers     map[string]neti.HostConn //by remote address
+	done      chan struct{}            //Closed once the connection to the target is closed
+	once      *sync.Once
+}
+
+func newCat(c config, out writer) (k *cat, err error) {
+	k = &cat{
+		config: c,
+		out:    out,
+		lock:   &sync.Mutex{},
+		peers:  make(map[string]neti.HostConn),
+		done:   make(chan struct{}),
+		once:   &sync.Once{},
+	}
+	switch c.transport {
+	case "tcp":
+		k.net = neti.NewTcpNet(log.StandardLogger())
+		registerAll(k.net.RegisterMessage, 0xFFFF)
+		if c.listen {
+			conns, err := k.net.Listen(c.local)
+			if err != nil {
+				return nil, err
+			}
+			k.listening = true
+			go func() {
+				for conn := range conns {
+					k.addPeer(conn)
+					go k.readAll(conn)
+				}
+			}()
+		}
+	case "udp":
+		k.net = neti.NewUdpNet(c.buffsize)
+		registerAll(k.net.RegisterMessage, reservedCode-1)
+		datagrams, err := k.net.Listen(c.local)
+		if err != nil {
+			return nil, err
+		}
+		k.listening = true
+		go func() {
+			for conn := range datagrams {
+				if m, err := k.net.RecvFrom(conn); err == nil {
+					k.received(conn, m)
+				}
+			}
+		}()
+	default:
+		defer func() {
+			// services panic when they can not listen
+			if r := recover(); r != nil {
+				err = errors.New(fmt.Sprint(r))
+			}
+		}()
+		if c.transport == "tcp-service" {
+			k.service = neti.InitBaseTcpService(c.local, log.StandardLogger())
+		} else {
+			k.service = neti.InitBaseUdpService(c.local, c.buffsize)
+		}
+		k.client = k.service.RegisterListener(c.service)
+		registerAll(k.client.RegisterMessage, 0xFFFF)
+		go func() {
+			for conn := range k.client.Accept() {
+				if k.client.Type() != neti.UDP {
+					k.addPeer(conn)
+					go k.readAll(conn)
+				} else if m, err := k.client.RecvFrom(conn); err == nil {
+					k.received(conn, m)
+				}
+			}
+		}()
+	}
+	return k, nil
+}
+
+// connect opens the connection to the target addr.
+func (k *cat) connect(addr string) error {
+	var conn neti.HostConn
+	var err error
+	if k.client != nil {
+		conn, err = k.client.OpenTo(addr, k.config.service)
+	} else {
+		conn, err = k.net.Open(addr)
+	}
+	if err != nil {
+		return err
+	}
+	k.addPeer(conn)
+	if k.config.transport == "tcp" || k.config.transport == "tcp-service" {
+		go k.readAll(conn)
+	}
+	return nil
+}
+
+// readAll writes the messages received on the connection conn until it is closed.
+func (k *cat) readAll(conn neti.HostConn) {
+	for {
+		var m neti.Message
+		var err error
+		if k.client != nil {
+			m, err = k.client.RecvFrom(conn.(*neti.ServiceHostConn))
+		} else {
+			m, err = k.net.RecvFrom(conn)
+		}
+		var decodeErr *neti.DecodeError
+		if errors.As(err, &decodeErr) {
+			continue
+		} else if err != nil {
+			k.removePeer(conn)
+			if !k.config.listen {
+				k.once.Do(func() { close(k.done) })
+			}
+			return
+		}
+		k.received(conn, m)
+	}
+}
+
+// received writes m, received on conn, remembering the peers of datagrams when listening.
+func (k *cat) received(conn neti.HostConn, m neti.Message) {
+	if k.config.listen {
+		k.addPeer(conn)
+	}
+	service := ""
+	if s, ok := conn.(*neti.ServiceHostConn); ok {
+		service = s.ServiceId
+	}
+	if err := k.out.write(conn.Addr().String(), service, m.(rawMsg)); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to write:", err)
+	}
+}
+
+func (k *cat) addPeer(conn neti.HostConn) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.peers[conn.Addr().String()] = conn
+}
+
+func (k *cat) removePeer(conn neti.HostConn) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	delete(k.peers, conn.Addr().String())
+}
+
+// send sends m to the peer remote, or to every peer if remote is empty.
+// Failing to send to a peer only fails when connecting, when listening the peer is forgotten.
+// Messages with the reserved code always fail on udp, whose receivers would take them for compressed datagrams.
+func (k *cat) send(m rawMsg, remote string) error {
+	if k.config.transport == "udp" && m.code == reservedCode {
+		return errors.New(fmt.Sprintf("the code %v is reserved on udp to flag compressed datagrams", m.code))
+	}
+	k.lock.Lock()
+	var targets []neti.HostConn
+	for addr, conn := range k.peers {
+		if remote == "" || remote == addr {
+			targets = append(targets, conn)
+		}
+	}
+	k.lock.Unlock()
+	if len(targets) == 0 {
+		if remote != "" {
+			fmt.Fprintln(os.Stderr, "No peer", remote, "to send", m, "to")
+		} else {
+			fmt.Fprintln(os.Stderr, "No peer to send", m, "to")
+		}
+		return nil
+	}
+	for _, conn := range targets {
+		var err error
+		if k.client != nil {
+			err = k.client.SendTo(conn.(*neti.ServiceHostConn), m)
+		} else {
+			err = k.net.SendTo(conn, m)
+		}
+		if err != nil {
+			if !k.config.listen {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "Unable to send", m, "to", conn.Addr().String()+":", err)
+			k.removePeer(conn)
+		}
+	}
+	return nil
+}
+
+func (k *cat) close() {
+	k.lock.Lock()
+	for _, conn := range k.peers {
+		_ = conn.Close()
+	}
+	k.lock.Unlock()
+	if k.service != nil {
+		_ = k.service.Close()
+	} else if k.listening {
+		_ = k.net.CloseListener()
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// rawMsg is a message of any code, its payload is sent and received as is.
+type rawMsg struct {
+	code    uint16
+	payload []byte
+}
+
+func (m rawMsg) String() string {
+	return fmt.Sprintf("%v{code=%v, %v bytes}", m.Name(), m.code, len(m.payload))
+}
+
+func (m rawMsg) Name() string {
+	return "Raw"
+}
+
+func (m rawMsg) Code() uint16 {
+	return m.code
+}
+
+func (m rawMsg) Serialize(buff *bytes.Buffer) error {
+	buff.Write(m.payload)
+	return nil
+}
+
+func (m rawMsg) Deserialize(buff *bytes.Buffer) (neti.Message, error) {
+	return rawMsg{code: m.code, payload: append([]byte(nil), buff.Bytes()...)}, nil
+}
+
+// reservedCode is the message code udp reserves to flag compressed datagrams, it can not be sent nor received on udp.
+const reservedCode = 0xFFFF
+
+// registerAll registers a rawMsg for every code up to last, so that every message is received.
+func registerAll(register func(neti.Message), last uint16) {
+	for code := 0; code <= int(last); code++ {
+		register(rawMsg{code: uint16(code)})
+	}
+}
+
+// jsonMsg is a message in the json format. The payload is given as base64 (payload), hex or text, and remote selects
+// the peer a message is sent to when listening (every peer if empty).
+type jsonMsg struct {
+	Time    *time.Time `json:"time,omitempty"`
+	Remote  string     `json:"remote,omitempty"`
+	Service string     `json:"service,omitempty"`
+	Code    uint16     `json:"code"`
+	Payload []byte     `json:"payload,omitempty"`
+	Hex     string     `json:"hex,omitempty"`
+	Text    *string    `json:"text,omitempty"`
+}
+
+// reader reads the messages to send from stdin, returning the peer to send them to, if any.
+type reader interface {
+	next() (rawMsg, string, error)
+}
+
+// writer writes the messages received to stdout.
+type writer interface {
+	write(remote string, service string, m rawMsg) error
+}
+
+func newReader(format string, code uint16, r io.Reader) reader {
+	if format == "raw" {
+		return &rawReader{r: bufio.NewReader(r)}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	return &lineReader{format: format, code: code, scanner: scanner}
+}
+
+func newWriter(format string, w io.Writer) writer {
+	return &lineWriter{format: format, w: bufio.NewWriter(w), lock: &sync.Mutex{}}
+}
+
+// lineReader reads a message per line: a JSON object (json), the hex of a frame (hex) or a payload (text).
+type lineReader struct {
+	format  string
+	code    uint16
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *lineReader) next() (rawMsg, string, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Text()
+		if r.format == "text" {
+			return rawMsg{code: r.code, payload: []byte(line)}, "", nil
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m, remote, err := r.parse(line)
+		if err != nil {
+			return m, "", errors.New(fmt.Sprintf("line %v: %v", r.line, err))
+		}
+		return m, remote, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return rawMsg{}, "", err
+	}
+	return rawMsg{}, "", io.EOF
+}
+
+func (r *lineReader) parse(line string) (rawMsg, string, error) {
+	if r.format == "hex" {
+		frame, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			return rawMsg{}, "", err
+		}
+		m, err := parseFrame(frame)
+		return m, "", err
+	}
+	var j jsonMsg
+	if err := json.Unmarshal([]byte(line), &j); err != nil {
+		return rawMsg{}, "", err
+	}
+	m := rawMsg{code: j.Code, payload: j.Payload}
+	switch {
+	case j.Text != nil:
+		m.payload = []byte(*j.Text)
+	case j.Hex != "":
+		payload, err := hex.DecodeString(j.Hex)
+		if err != nil {
+			return rawMsg{}, "", err
+		}
+		m.payload = payload
+	}
+	return m, j.Remote, nil
+}
+
+// rawReader reads frames prefixed by their length (u32), as on TCP connections.
+type rawReader struct {
+	r *bufio.Reader
+}
+
+func (r *rawReader) next() (rawMsg, string, error) {
+	var size uint32
+	if err := binary.Read(r.r, binary.BigEndian, &size); err != nil {
+		return rawMsg{}, "", err
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return rawMsg{}, "", io.ErrUnexpectedEOF
+	}
+	m, err := parseFrame(frame)
+	return m, "", err
+}
+
+func parseFrame(frame []byte) (rawMsg, error) {
+	if len(frame) < 2 {
+		return rawMsg{}, errors.New("frame too short")
+	}
+	return rawMsg{code: binary.BigEndian.Uint16(frame), payload: frame[2:]}, nil
+}
+
+// lineWriter writes the messages in the format, flushing each one.
+type lineWriter struct {
+	format string
+	w      *bufio.Writer
+	lock   *sync.Mutex
+}
+
+func (w *lineWriter) write(remote string, service string, m rawMsg) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	frame := make([]byte, 2, 2+len(m.payload))
+	binary.BigEndian.PutUint16(frame, m.code)
+	frame = append(frame, m.payload...)
+	switch w.format {
+	case "text":
+		_, _ = w.w.Write(m.payload)
+		_ = w.w.WriteByte('\n')
+	case "hex":
+		_, _ = w.w.WriteString(hex.EncodeToString(frame))
+		_ = w.w.WriteByte('\n')
+	case "raw":
+		_ = binary.Write(w.w, binary.BigEndian, uint32(len(frame)))
+		_, _ = w.w.Write(frame)
+	default:
+		now := time.Now()
+		j := jsonMsg{Time: &now, Remote: remote, Service: service, Code: m.code, Payload: m.payload}
+		if len(m.payload) > 0 && utf8.Valid(m.payload) {
+			text := string(m.payload)
+			j.Text = &text
+		}
+		b, err := json.Marshal(j)
+		if err != nil {
+			return err
+		}
+		_, _ = w.w.Write(b)
+		_ = w.w.WriteByte('\n')
+	}
+	return w.w.Flush()
+}
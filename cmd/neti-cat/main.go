@@ -0,0 +1,146 @@
+// neti-cat sends and receives framed messages, like netcat but speaking the wire format of neti.
+//
+// Usage:
+//
+//	neti-cat [flags] addr        connect to addr
+//	neti-cat -l [flags] addr     listen on addr
+//
+// Messages are read from stdin and written to stdout in the -format:
+//
+//	json  one object per line, {"code":7,"text":"hello"}, with the payload as "payload" (base64), "hex" or "text";
+//	      messages received also have "time", "remote" and "service", and "remote" selects the peer to send to when listening
+//	text  one payload per line, sent with the code -code
+//	hex   the hex of a frame (the code, u16, followed by the payload) per line
+//	raw   frames prefixed by their length (u32), as on TCP connections
+//
+// With -transport tcp-service or udp-service, messages are sent to and received from the NetClient -service of the peer.
+// With -transport udp, the code 65535 is reserved to flag compressed datagrams, and messages with it fail to be sent.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	listen    bool
+	transport string
+	service   string
+	local     string
+	format    string
+	code      uint
+	wait      time.Duration
+	buffsize  int
+}
+
+func main() {
+	var c config
+	flag.BoolVar(&c.listen, "l", false, "listen on addr instead of connecting to it")
+	flag.StringVar(&c.transport, "transport", "tcp", "tcp, udp, tcp-service or udp-service")
+	flag.StringVar(&c.service, "service", "cat", "id of the NetClient of services")
+	flag.StringVar(&c.local, "local", "", "address to listen on when connecting over udp or services (an ephemeral port if not set)")
+	flag.StringVar(&c.format, "format", "json", "json, text, hex or raw")
+	flag.UintVar(&c.code, "code", 1, "code of the messages sent in the text format")
+	flag.DurationVar(&c.wait, "wait", time.Second, "time to wait for messages once stdin is closed, when connecting")
+	flag.IntVar(&c.buffsize, "buffsize", 64*1024, "size of the datagram buffers of UDP")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-l] [flags] addr\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetLevel(log.WarnLevel)
+
+	if flag.NArg() != 1 || c.code > 0xFFFF || !oneOf(c.format, "json", "text", "hex", "raw") ||
+		!oneOf(c.transport, "tcp", "udp", "tcp-service", "udp-service") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	addr := flag.Arg(0)
+	if c.local == "" {
+		c.local = localAddr(addr)
+	}
+	if c.listen {
+		c.local = addr
+	}
+
+	k, err := newCat(c, newWriter(c.format, os.Stdout))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to listen on", c.local+":", err)
+		os.Exit(1)
+	}
+	defer k.close()
+	if !c.listen {
+		if err := k.connect(addr); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to connect to", addr+":", err)
+			os.Exit(1)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	eof := make(chan error, 1)
+	go func() {
+		eof <- k.sendAll(newReader(c.format, uint16(c.code), os.Stdin))
+	}()
+
+	select {
+	case err := <-eof:
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if c.listen {
+			// keep receiving until interrupted, like a listening netcat without input
+			select {
+			case <-signals:
+			case <-k.done:
+			}
+			return
+		}
+		select {
+		case <-time.After(c.wait):
+		case <-signals:
+		case <-k.done:
+		}
+	case <-signals:
+	case <-k.done:
+	}
+}
+
+// sendAll sends the messages read from stdin until its end.
+func (k *cat) sendAll(r reader) error {
+	for {
+		m, remote, err := r.next()
+		if err != nil {
+			return err
+		}
+		if err := k.send(m, remote); err != nil {
+			return errors.New(fmt.Sprintf("Unable to send %v: %v", m, err))
+		}
+	}
+}
+
+func oneOf(value string, values ...string) bool {
+	for _, v := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// localAddr returns the address to listen on to connect to addr: the loopback for addr on the loopback, or else any address.
+func localAddr(addr string) string {
+	if strings.HasPrefix(addr, "127.") || strings.HasPrefix(addr, "localhost:") || strings.HasPrefix(addr, "[::1]:") {
+		return "127.0.0.1:0"
+	}
+	return ":0"
+}
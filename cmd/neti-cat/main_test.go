@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/nettest"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// received is a message written by a cat.
+type received struct {
+	remote  string
+	service string
+	m       rawMsg
+}
+
+// chanWriter writes the messages received to a channel.
+type chanWriter chan received
+
+func (w chanWriter) write(remote string, service string, m rawMsg) error {
+	w <- received{remote: remote, service: service, m: m}
+	return nil
+}
+
+// next returns the next message written to out, failing if none is within a second.
+func next(t *testing.T, out chanWriter) received {
+	t.Helper()
+	select {
+	case r := <-out:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("received no message")
+		return received{}
+	}
+}
+
+func TestReader(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		input  string
+		code   uint16
+		remote string
+	}{
+		{"json", `{"code":7,"text":"hello"}`, 7, ""},
+		{"json", `{"code":7,"hex":"68656c6c6f","remote":"127.0.0.1:10000"}`, 7, "127.0.0.1:10000"},
+		{"json", `{"code":7,"payload":"aGVsbG8="}`, 7, ""},
+		{"text", "hello", 3, ""},
+		{"hex", "0007 6865 6c6c 6f", 7, ""},
+		{"raw", "\x00\x00\x00\x07\x00\x07hello", 7, ""},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			r := newReader(test.format, 3, strings.NewReader(test.input))
+			m, remote, err := r.next()
+			if err != nil || m.code != test.code || string(m.payload) != "hello" || remote != test.remote {
+				t.Fatalf("read %v (%q) for %v, %v", m, m.payload, remote, err)
+			}
+			if _, _, err := r.next(); err != io.EOF {
+				t.Fatalf("reading past the end failed with %v, expected io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	// blank lines are skipped, and errors report the line
+	r := newReader("json", 1, strings.NewReader("{\"code\":1}\n\n{\"code\":"))
+	if _, _, err := r.next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.next(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("reading the truncated message failed with %v, expected an error at line 3", err)
+	}
+	if _, _, err := newReader("hex", 1, strings.NewReader("07")).next(); err == nil {
+		t.Fatal("read a frame without code")
+	}
+	if _, _, err := newReader("raw", 1, strings.NewReader("\x00\x00\x00\x07\x00\x07")).next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("reading a truncated frame failed with %v, expected io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	m := rawMsg{code: 7, payload: []byte("hello")}
+	for format, expected := range map[string]string{
+		"text": "hello\n",
+		"hex":  "000768656c6c6f\n",
+		"raw":  "\x00\x00\x00\x07\x00\x07hello",
+	} {
+		buff := new(bytes.Buffer)
+		if err := newWriter(format, buff).write("127.0.0.1:10000", "", m); err != nil || buff.String() != expected {
+			t.Fatalf("wrote %q in %v, %v; expected %q", buff.String(), format, err, expected)
+		}
+	}
+
+	buff := new(bytes.Buffer)
+	w := newWriter("json", buff)
+	_ = w.write("127.0.0.1:10000", "cat", m)
+	_ = w.write("127.0.0.1:10000", "cat", rawMsg{code: 8, payload: []byte{0xFF}})
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	var j jsonMsg
+	if err := json.Unmarshal([]byte(lines[0]), &j); err != nil || j.Time == nil || j.Remote != "127.0.0.1:10000" ||
+		j.Service != "cat" || j.Code != 7 || j.Text == nil || *j.Text != "hello" || string(j.Payload) != "hello" {
+		t.Fatalf("wrote %v, %v", lines[0], err)
+	}
+	// payloads that are not text are only given as base64
+	j = jsonMsg{}
+	if err := json.Unmarshal([]byte(lines[1]), &j); err != nil || j.Text != nil || !bytes.Equal(j.Payload, []byte{0xFF}) {
+		t.Fatalf("wrote %v, %v", lines[1], err)
+	}
+}
+
+func TestCat(t *testing.T) {
+	for _, transport := range []string{"tcp", "udp", "tcp-service", "udp-service"} {
+		t.Run(transport, func(t *testing.T) {
+			addr := nettest.LocalAddr(t)
+			serverOut := make(chanWriter, 10)
+			server, err := newCat(config{listen: true, transport: transport, service: "cat", local: addr, buffsize: 1024}, serverOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.close()
+			clientOut := make(chanWriter, 10)
+			client, err := newCat(config{transport: transport, service: "cat", local: localAddr(addr), buffsize: 1024}, clientOut)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.close()
+			if err := client.connect(addr); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := client.sendAll(newReader("text", 7, strings.NewReader("hello\n"))); err != io.EOF {
+				t.Fatalf("sending ended with %v, expected io.EOF", err)
+			}
+			r := next(t, serverOut)
+			if r.m.code != 7 || string(r.m.payload) != "hello" {
+				t.Fatalf("received %v (%q)", r.m, r.m.payload)
+			}
+			if strings.HasSuffix(transport, "service") && r.service != "cat" {
+				t.Fatalf("received %v from service %q, expected cat", r.m, r.service)
+			}
+
+			// the server replies to the peer it received from
+			reply := `{"code":8,"text":"world","remote":"` + r.remote + `"}`
+			if err := server.sendAll(newReader("json", 1, strings.NewReader(reply))); err != io.EOF {
+				t.Fatalf("replying ended with %v, expected io.EOF", err)
+			}
+			if r := next(t, clientOut); r.m.code != 8 || string(r.m.payload) != "world" {
+				t.Fatalf("received %v (%q)", r.m, r.m.payload)
+			}
+		})
+	}
+}
+
+func TestCatReservedCode(t *testing.T) {
+	addr := nettest.LocalAddr(t)
+	server, err := newCat(config{listen: true, transport: "udp", local: addr, buffsize: 1024}, make(chanWriter, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.close()
+	client, err := newCat(config{transport: "udp", local: localAddr(addr), buffsize: 1024}, make(chanWriter, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+	if err := client.connect(addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.send(rawMsg{code: reservedCode}, ""); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("sending the reserved code failed with %v, expected it to be reserved", err)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"127.0.0.1:17600": "127.0.0.1:0",
+		"localhost:17600": "127.0.0.1:0",
+		"[::1]:17600":     "127.0.0.1:0",
+		"10.0.0.1:17600":  ":0",
+	} {
+		if local := localAddr(addr); local != expected {
+			t.Fatalf("listens on %v to connect to %v, expected %v", local, addr, expected)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// neti-dump reads capture files (see neti.WithCapture) and prints their frames, decoding the messages of services
+// with the types registered by a plugin.
+//
+// Usage:
+//
+//	neti-dump [flags] capture.jsonl.2 capture.jsonl.1 capture.jsonl
+//
+// The plugin is built with go build -buildmode=plugin from a main package exporting
+//
+//	func Register(registry *neti.MessageRegistry)
+//
+// which registers the messages of the services, e.g. registry.Register("client1", Ping{}, Pong{}).
+// The frames of Nets used without a service are decoded with the messages registered for every service ("").
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"io"
+	"os"
+	"plugin"
+	"sort"
+	"strings"
+	"time"
+)
+
+type filters struct {
+	peer    string
+	service string
+	code    int
+	dir     string
+	since   time.Time
+	until   time.Time
+}
+
+func (f filters) match(frame neti.CapturedFrame) bool {
+	if f.peer != "" && frame.Remote != f.peer && frame.Local != f.peer {
+		return false
+	}
+	if f.service != "" && frame.ServiceId != f.service {
+		return false
+	}
+	if f.code >= 0 && int(frame.Code) != f.code {
+		return false
+	}
+	if f.dir != "" && string(frame.Direction) != f.dir {
+		return false
+	}
+	if !f.since.IsZero() && frame.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && frame.Time.After(f.until) {
+		return false
+	}
+	return true
+}
+
+type stats struct {
+	frames       int
+	bytes        int
+	first, last  time.Time
+	decodeErrors int
+	byDirection  map[string]int
+	byMessage    map[string]int
+	byPeer       map[string]int
+}
+
+func (s *stats) add(frame neti.CapturedFrame, name string, decoded bool) {
+	if s.frames == 0 || frame.Time.Before(s.first) {
+		s.first = frame.Time
+	}
+	if frame.Time.After(s.last) {
+		s.last = frame.Time
+	}
+	s.frames++
+	s.bytes += len(frame.Frame)
+	if !decoded {
+		s.decodeErrors++
+	}
+	s.byDirection[string(frame.Direction)]++
+	s.byMessage[fmt.Sprintf("%v %v (%v)", frame.ServiceId, frame.Code, name)]++
+	s.byPeer[frame.Remote]++
+}
+
+func (s *stats) print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "\n%v frames, %v bytes, %v undecoded, from %v to %v (%v)\n",
+		s.frames, s.bytes, s.decodeErrors, s.first.Format(time.RFC3339Nano), s.last.Format(time.RFC3339Nano), s.last.Sub(s.first))
+	for _, section := range []struct {
+		title  string
+		counts map[string]int
+	}{{"direction", s.byDirection}, {"service code (message)", s.byMessage}, {"peer", s.byPeer}} {
+		_, _ = fmt.Fprintf(w, "\nby %v:\n", section.title)
+		keys := make([]string, 0, len(section.counts))
+		for k := range section.counts {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if section.counts[keys[i]] != section.counts[keys[j]] {
+				return section.counts[keys[i]] > section.counts[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(w, "  %8v  %v\n", section.counts[k], k)
+		}
+	}
+}
+
+func loadPlugin(path string, registry *neti.MessageRegistry) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return err
+	}
+	register, ok := symbol.(func(*neti.MessageRegistry))
+	if !ok {
+		return errors.New(fmt.Sprintf("%v: Register is a %T, expected a func(*neti.MessageRegistry)", path, symbol))
+	}
+	register(registry)
+	return nil
+}
+
+func parseTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -%v: %v\n", name, err)
+		os.Exit(2)
+	}
+	return t
+}
+
+// describe returns the name of the message in frame and its string representation, decoding it with the registry.
+// Frames without a service id are tried as frames of Nets first, decoded with the messages registered for every service.
+func describe(frame neti.CapturedFrame, registry *neti.MessageRegistry) (string, string, bool) {
+	var netErr error
+	if frame.ServiceId == "" && len(frame.Frame) >= 2 {
+		msg, err := registry.Decode("", binary.BigEndian.Uint16(frame.Frame), frame.Frame[2:])
+		if err == nil {
+			return msg.Name(), msg.String(), true
+		}
+		netErr = err
+	}
+	f, err := neti.ParseServiceFrame(frame.Frame)
+	if err != nil && netErr != nil {
+		return "?", fmt.Sprintf("<%v bytes, %v>", len(frame.Frame)-2, netErr), false
+	} else if err != nil {
+		return "?", fmt.Sprintf("<%v>", err), false
+	}
+	trace := ""
+	if f.Trace.IsValid() {
+		trace = " trace=" + f.Trace.TraceParent()
+	}
+	msg, err := registry.Decode(f.ServiceId, f.MessageCode, f.Payload)
+	if err != nil {
+		return "?", fmt.Sprintf("<%v bytes, %v>%v", len(f.Payload), err, trace), false
+	}
+	return msg.Name(), msg.String() + trace, true
+}
+
+func main() {
+	var (
+		pluginPath = flag.String("plugin", "", "plugin registering the message types (see the package documentation)")
+		peer       = flag.String("peer", "", "only frames to or from this address")
+		service    = flag.String("service", "", "only frames of this service id")
+		code       = flag.Int("code", -1, "only frames of messages with this code")
+		dir        = flag.String("dir", "", "only frames sent (out) or received (in)")
+		since      = flag.String("since", "", "only frames at or after this time (RFC 3339)")
+		until      = flag.String("until", "", "only frames at or before this time (RFC 3339)")
+		showHex    = flag.Bool("hex", false, "print the raw frames")
+		quiet      = flag.Bool("quiet", false, "do not print the frames")
+		summary    = flag.Bool("stats", false, "print summary statistics")
+	)
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] capture-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	registry := neti.NewMessageRegistry()
+	if *pluginPath != "" {
+		if err := loadPlugin(*pluginPath, registry); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to load plugin:", err)
+			os.Exit(1)
+		}
+	}
+	f := filters{
+		peer:    *peer,
+		service: *service,
+		code:    *code,
+		dir:     *dir,
+		since:   parseTime("since", *since),
+		until:   parseTime("until", *until),
+	}
+	s := &stats{byDirection: map[string]int{}, byMessage: map[string]int{}, byPeer: map[string]int{}}
+
+	failed := false
+	for _, path := range flag.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		reader := neti.NewCaptureReader(file)
+		for {
+			frame, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+				failed = true
+				break
+			}
+			if !f.match(frame) {
+				continue
+			}
+			name, text, decoded := describe(frame, registry)
+			s.add(frame, name, decoded)
+			if *quiet {
+				continue
+			}
+			arrow := "->"
+			if frame.Direction == neti.Received {
+				arrow = "<-"
+			}
+			fmt.Printf("%v %-3v %v %v %v %v %v code=%v %vB %v\n", frame.Time.Format(time.RFC3339Nano), frame.Direction,
+				frame.Network, frame.Local, arrow, frame.Remote, frame.ServiceId, frame.Code, len(frame.Frame), text)
+			if *showHex {
+				fmt.Print(indent(hex.Dump(frame.Frame)))
+			}
+		}
+		_ = file.Close()
+	}
+	if *summary {
+		s.print(os.Stdout)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func indent(s string) string {
+	return "    " + strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n    ") + "\n"
+}
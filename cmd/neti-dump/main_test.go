@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"github.com/pedroAkos/go-simple-networking/cmd/pingpong"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frameOf returns the frame of message, with the code of the Net message code.
+func frameOf(t *testing.T, code uint16, message neti.Message) []byte {
+	t.Helper()
+	buff := new(bytes.Buffer)
+	_ = neti.EncodeNumberToBuffer(code, buff)
+	if err := message.Serialize(buff); err != nil {
+		t.Fatal(err)
+	}
+	return buff.Bytes()
+}
+
+func TestDescribe(t *testing.T) {
+	registry := neti.NewMessageRegistry()
+	registry.Register("client1", pingpong.Ping{})
+	registry.Register("", pingpong.Pong{})
+	ping := pingpong.Ping{Seq: 7}
+	pong := pingpong.NewPong(ping)
+
+	for _, test := range []struct {
+		name    string
+		frame   neti.CapturedFrame
+		message string
+		decoded bool
+	}{
+		{"Service", neti.CapturedFrame{ServiceId: "client1", Frame: frameOf(t, 0, neti.MessageWrap{Id: "client1", Msg: ping})}, "Ping", true},
+		{"OtherService", neti.CapturedFrame{ServiceId: "client2", Frame: frameOf(t, 0, neti.MessageWrap{Id: "client2", Msg: ping})}, "?", false},
+		// frames of Nets used directly are decoded with the messages of every service
+		{"Net", neti.CapturedFrame{Code: pong.Code(), Frame: frameOf(t, pong.Code(), pong)}, "Pong", true},
+		{"NetUnknownCode", neti.CapturedFrame{Code: ping.Code(), Frame: frameOf(t, ping.Code(), ping)}, "?", false},
+		{"TooShort", neti.CapturedFrame{Frame: []byte{0}}, "?", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			name, text, decoded := describe(test.frame, registry)
+			if name != test.message || decoded != test.decoded {
+				t.Fatalf("described %v: %v (%v), expected %v", name, text, decoded, test.message)
+			}
+			if decoded && !strings.Contains(text, "seq=7") {
+				t.Fatalf("described %v, expected the message with seq 7", text)
+			}
+		})
+	}
+}
+
+func TestFilters(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	frame := neti.CapturedFrame{Time: at, Direction: neti.Received, Remote: "10.0.0.2:10000", ServiceId: "client1", Code: 7}
+	all := filters{code: -1}
+	for _, test := range []struct {
+		name    string
+		filters filters
+		match   bool
+	}{
+		{"None", all, true},
+		{"Peer", filters{code: -1, peer: "10.0.0.2:10000"}, true},
+		{"OtherPeer", filters{code: -1, peer: "10.0.0.3:10000"}, false},
+		{"Service", filters{code: -1, service: "client2"}, false},
+		{"Code", filters{code: 7}, true},
+		{"OtherCode", filters{code: 8}, false},
+		{"Dir", filters{code: -1, dir: "out"}, false},
+		{"Since", filters{code: -1, since: at.Add(time.Second)}, false},
+		{"Until", filters{code: -1, until: at}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.filters.match(frame) != test.match {
+				t.Fatalf("%+v matched %v, expected %v", test.filters, !test.match, test.match)
+			}
+		})
+	}
+}
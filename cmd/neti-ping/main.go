@@ -0,0 +1,218 @@
+// neti-ping measures the round trip time to a peer over TCP, UDP or a service, and answers the pings of others.
+//
+// Usage:
+//
+//	neti-ping [flags] target
+//	neti-ping -server -listen 0.0.0.0:17600 [-transport udp]
+//
+// The peer must answer pings on the same transport, e.g. another neti-ping in server mode, or a node of the
+// service with the NetClient of -service registering pingpong.Ping and replying with pingpong.Pong.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/cmd/pingpong"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	transport string
+	listen    string
+	service   string
+	count     int
+	interval  time.Duration
+	timeout   time.Duration
+	buffsize  int
+	server    bool
+}
+
+// peer is the local end of the pings, sending Pings and receiving Pongs (or the reverse in server mode).
+type peer interface {
+	open(addr string) error
+	send(m neti.Message) error
+	recv() (neti.Message, error)
+	serve(reply func(neti.Message) neti.Message)
+	close()
+}
+
+func main() {
+	var c config
+	flag.StringVar(&c.transport, "transport", "tcp", "tcp, udp, tcp-service or udp-service")
+	flag.StringVar(&c.listen, "listen", "", "address to listen on, required with -server (an ephemeral port if not set)")
+	flag.StringVar(&c.service, "service", "ping", "id of the NetClient of the services answering pings")
+	flag.IntVar(&c.count, "c", 0, "stop after sending count pings, 0 to ping until interrupted")
+	flag.DurationVar(&c.interval, "i", time.Second, "interval between pings")
+	flag.DurationVar(&c.timeout, "W", time.Second, "time to wait for a pong")
+	flag.IntVar(&c.buffsize, "buffsize", 1024, "size of the datagram buffers of UDP")
+	flag.BoolVar(&c.server, "server", false, "only answer pings, on the -listen address")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] target\n       %v -server -listen addr [flags]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetLevel(log.WarnLevel)
+
+	if (c.server && (c.listen == "" || flag.NArg() != 0)) || (!c.server && flag.NArg() != 1) || c.interval <= 0 || c.timeout <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if c.listen == "" {
+		c.listen = listenAddr(flag.Arg(0))
+	}
+
+	p, err := newPeer(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to listen on", c.listen+":", err)
+		os.Exit(2)
+	}
+	defer p.close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	if c.server {
+		p.serve(pong)
+		fmt.Printf("Answering pings on %v (%v)\n", c.listen, c.transport)
+		<-signals
+		return
+	}
+
+	target := flag.Arg(0)
+	if err := p.open(target); err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to connect to", target+":", err)
+		os.Exit(2)
+	}
+	s := ping(c, p, target, signals)
+	s.print(os.Stdout, target)
+	if s.received == 0 {
+		os.Exit(1)
+	}
+}
+
+// pong returns the Pong answering m, nil if m is not a Ping.
+func pong(m neti.Message) neti.Message {
+	if ping, ok := m.(pingpong.Ping); ok {
+		return pingpong.NewPong(ping)
+	}
+	return nil
+}
+
+// ping sends the pings to target until count is reached or a signal is received, printing their pongs.
+func ping(c config, p peer, target string, signals <-chan os.Signal) *stats {
+	pongs := make(chan pingpong.Pong, 16)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			m, err := p.recv()
+			var decodeErr *neti.DecodeError
+			if errors.As(err, &decodeErr) || errors.Is(err, neti.ErrDropped) {
+				continue
+			} else if err != nil {
+				failed <- err
+				return
+			}
+			if pong, ok := m.(pingpong.Pong); ok {
+				pongs <- pong
+			}
+		}
+	}()
+
+	fmt.Printf("PING %v (%v)\n", target, c.transport)
+	s := &stats{start: time.Now()}
+	for seq := 0; c.count == 0 || seq < c.count; seq++ {
+		next := s.start.Add(time.Duration(seq) * c.interval)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-signals:
+			return s
+		}
+		if err := p.send(pingpong.NewPing(uint32(seq))); err != nil {
+			fmt.Println("Unable to send ping:", err)
+			return s
+		}
+		s.sent++
+		timeout := time.After(c.timeout)
+	wait:
+		for {
+			select {
+			case pong := <-pongs:
+				rtt := pong.RTT(time.Now())
+				if int(pong.Seq) != seq {
+					fmt.Printf("Late pong from %v: seq=%v time=%.3f ms\n", target, pong.Seq, millis(rtt))
+					continue
+				}
+				s.add(rtt)
+				fmt.Printf("Pong from %v: seq=%v time=%.3f ms\n", target, pong.Seq, millis(rtt))
+				break wait
+			case <-timeout:
+				fmt.Printf("Request timeout for seq %v\n", seq)
+				break wait
+			case err := <-failed:
+				fmt.Println("Connection lost:", err)
+				return s
+			case <-signals:
+				return s
+			}
+		}
+	}
+	return s
+}
+
+// stats are the round trip times of the pongs received.
+type stats struct {
+	start    time.Time
+	sent     int
+	received int
+	min, max time.Duration
+	sum      float64 //Sum of the round trip times, in milliseconds
+	sumSq    float64
+}
+
+func (s *stats) add(rtt time.Duration) {
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.received++
+	s.sum += millis(rtt)
+	s.sumSq += millis(rtt) * millis(rtt)
+}
+
+func (s *stats) print(w io.Writer, target string) {
+	loss := 0.0
+	if s.sent > 0 {
+		loss = float64(s.sent-s.received) * 100 / float64(s.sent)
+	}
+	_, _ = fmt.Fprintf(w, "\n--- %v ping statistics ---\n", target)
+	_, _ = fmt.Fprintf(w, "%v pings sent, %v pongs received, %.1f%% loss, time %v\n", s.sent, s.received, loss,
+		time.Since(s.start).Round(time.Millisecond))
+	if s.received > 0 {
+		n := float64(s.received)
+		avg := s.sum / n
+		stddev := math.Sqrt(math.Max(s.sumSq/n-avg*avg, 0))
+		_, _ = fmt.Fprintf(w, "rtt min/avg/max/stddev = %.3f/%.3f/%.3f/%.3f ms\n", millis(s.min), avg, millis(s.max), stddev)
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// listenAddr returns the address to listen on to ping target: the loopback for a target on the loopback, or else any address.
+func listenAddr(target string) string {
+	if strings.HasPrefix(target, "127.") || strings.HasPrefix(target, "localhost:") || strings.HasPrefix(target, "[::1]:") {
+		return "127.0.0.1:0"
+	}
+	return ":0"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/nettest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pingServer starts a server answering the pings of transport with reply, returning its address.
+func pingServer(t *testing.T, transport string, reply func(neti.Message) neti.Message) string {
+	t.Helper()
+	c := config{transport: transport, listen: nettest.LocalAddr(t), service: "ping", buffsize: 1024, server: true}
+	p, err := newPeer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.close)
+	p.serve(reply)
+	return c.listen
+}
+
+// pingTarget pings target count times, returning the statistics of the pings.
+func pingTarget(t *testing.T, transport string, target string, count int) *stats {
+	t.Helper()
+	c := config{transport: transport, listen: listenAddr(target), service: "ping", count: count,
+		interval: 10 * time.Millisecond, timeout: 200 * time.Millisecond, buffsize: 1024}
+	p, err := newPeer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+	if err := p.open(target); err != nil {
+		t.Fatal(err)
+	}
+	return ping(c, p, target, make(chan os.Signal))
+}
+
+func TestPing(t *testing.T) {
+	for _, transport := range []string{"tcp", "udp", "tcp-service", "udp-service"} {
+		t.Run(transport, func(t *testing.T) {
+			s := pingTarget(t, transport, pingServer(t, transport, pong), 3)
+			if s.sent != 3 || s.received != 3 || s.min <= 0 || s.max < s.min {
+				t.Fatalf("sent %v pings and received %v pongs, in %v to %v", s.sent, s.received, s.min, s.max)
+			}
+		})
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	silent := func(neti.Message) neti.Message { return nil }
+	s := pingTarget(t, "udp", pingServer(t, "udp", silent), 2)
+	if s.sent != 2 || s.received != 0 {
+		t.Fatalf("sent %v pings and received %v pongs, expected 2 lost pings", s.sent, s.received)
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := &stats{start: time.Now(), sent: 3}
+	s.add(3 * time.Millisecond)
+	s.add(time.Millisecond)
+	buff := new(bytes.Buffer)
+	s.print(buff, "10.0.0.1:17600")
+	for _, expected := range []string{
+		"--- 10.0.0.1:17600 ping statistics ---",
+		"3 pings sent, 2 pongs received, 33.3% loss",
+		"rtt min/avg/max/stddev = 1.000/2.000/3.000/1.000 ms",
+	} {
+		if !strings.Contains(buff.String(), expected) {
+			t.Fatalf("printed %q, expected %q", buff.String(), expected)
+		}
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	for target, expected := range map[string]string{
+		"127.0.0.1:17600": "127.0.0.1:0",
+		"localhost:17600": "127.0.0.1:0",
+		"[::1]:17600":     "127.0.0.1:0",
+		"10.0.0.1:17600":  ":0",
+	} {
+		if addr := listenAddr(target); addr != expected {
+			t.Fatalf("listens on %v to ping %v, expected %v", addr, target, expected)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/cmd/pingpong"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	log "github.com/sirupsen/logrus"
+	"io"
+)
+
+func newPeer(c config) (p peer, err error) {
+	switch c.transport {
+	case "tcp", "udp":
+		return newNetPeer(c)
+	case "tcp-service", "udp-service":
+		defer func() {
+			// services panic when they can not listen
+			if r := recover(); r != nil {
+				err = errors.New(fmt.Sprint(r))
+			}
+		}()
+		var service neti.NetService
+		if c.transport == "tcp-service" {
+			service = neti.InitBaseTcpService(c.listen, log.StandardLogger())
+		} else {
+			service = neti.InitBaseUdpService(c.listen, c.buffsize)
+		}
+		client := service.RegisterListener(c.service)
+		client.RegisterMessage(pingpong.Ping{})
+		client.RegisterMessage(pingpong.Pong{})
+		return &servicePeer{service: service, client: client, id: c.service}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown transport %q", c.transport))
+	}
+}
+
+// netPeer pings over a Net, listening for datagrams, or for connections in server mode.
+type netPeer struct {
+	net   neti.Net
+	conns <-chan neti.HostConn //Connections accepted, or datagrams received
+	conn  neti.HostConn        //Connection to the target
+	udp   bool
+}
+
+func newNetPeer(c config) (*netPeer, error) {
+	p := &netPeer{udp: c.transport == "udp"}
+	if p.udp {
+		p.net = neti.NewUdpNet(c.buffsize)
+	} else {
+		p.net = neti.NewTcpNet(log.StandardLogger())
+	}
+	p.net.RegisterMessage(pingpong.Ping{})
+	p.net.RegisterMessage(pingpong.Pong{})
+	if p.udp || c.server {
+		conns, err := p.net.Listen(c.listen)
+		if err != nil {
+			return nil, err
+		}
+		p.conns = conns
+	}
+	return p, nil
+}
+
+func (p *netPeer) open(addr string) (err error) {
+	p.conn, err = p.net.Open(addr)
+	return err
+}
+
+func (p *netPeer) send(m neti.Message) error {
+	return p.net.SendTo(p.conn, m)
+}
+
+func (p *netPeer) recv() (neti.Message, error) {
+	if !p.udp {
+		return p.net.RecvFrom(p.conn)
+	}
+	conn, ok := <-p.conns
+	if !ok {
+		return nil, io.EOF
+	}
+	return p.net.RecvFrom(conn)
+}
+
+func (p *netPeer) serve(reply func(neti.Message) neti.Message) {
+	answer := func(conn neti.HostConn) error {
+		m, err := p.net.RecvFrom(conn)
+		if err != nil {
+			return err
+		}
+		if r := reply(m); r != nil {
+			return p.net.SendTo(conn, r)
+		}
+		return nil
+	}
+	go func() {
+		for conn := range p.conns {
+			if p.udp {
+				_ = answer(conn)
+				continue
+			}
+			go func(conn neti.HostConn) {
+				for answer(conn) == nil {
+				}
+				_ = conn.Close()
+			}(conn)
+		}
+	}()
+}
+
+func (p *netPeer) close() {
+	if p.conn != nil && !p.udp {
+		_ = p.conn.Close()
+	}
+	if p.conns != nil {
+		_ = p.net.CloseListener()
+	}
+}
+
+// servicePeer pings the NetClient id of a service.
+type servicePeer struct {
+	service neti.NetService
+	client  neti.NetClient
+	id      string
+	conn    *neti.ServiceHostConn
+}
+
+func (p *servicePeer) open(addr string) (err error) {
+	p.conn, err = p.client.OpenTo(addr, p.id)
+	return err
+}
+
+func (p *servicePeer) send(m neti.Message) error {
+	return p.client.SendTo(p.conn, m)
+}
+
+func (p *servicePeer) recv() (neti.Message, error) {
+	if p.client.Type() != neti.UDP {
+		return p.client.RecvFrom(p.conn)
+	}
+	return p.client.RecvFrom(<-p.client.Accept())
+}
+
+func (p *servicePeer) serve(reply func(neti.Message) neti.Message) {
+	answer := func(conn *neti.ServiceHostConn) error {
+		m, err := p.client.RecvFrom(conn)
+		if err != nil {
+			return err
+		}
+		if r := reply(m); r != nil {
+			return p.client.SendTo(conn, r)
+		}
+		return nil
+	}
+	go func() {
+		for conn := range p.client.Accept() {
+			if p.client.Type() == neti.UDP {
+				_ = answer(conn)
+				continue
+			}
+			go func(conn *neti.ServiceHostConn) {
+				for answer(conn) == nil {
+				}
+				_ = conn.Close()
+			}(conn)
+		}
+	}()
+}
+
+func (p *servicePeer) close() {
+	_ = p.service.Close()
+}
@@ -1,19 +1,31 @@
 package pingpong
 
 import (
+	"bytes"
 	"testing"
 )
 
 func TestSerialization(t *testing.T) {
-	ping := Ping{}
-	pong := Pong{}
+	ping := NewPing(42)
+	pong := NewPong(ping)
 
-	p, _ := ping.Deserialize(nil)
-	if p.String() != ping.String() {
-		t.Errorf("p.String() = %v; want %v", p, ping)
+	buff := &bytes.Buffer{}
+	_ = ping.Serialize(buff)
+	p, err := Ping{}.Deserialize(buff)
+	if err != nil || p != ping {
+		t.Errorf("p = %v, %v; want %v", p, err, ping)
 	}
-	p, _ = pong.Deserialize(nil)
-	if p.String() != pong.String() {
-		t.Errorf("p.String() = %v; want %v", p, pong)
+	buff.Reset()
+	_ = pong.Serialize(buff)
+	p, err = Pong{}.Deserialize(buff)
+	if err != nil || p != pong {
+		t.Errorf("p = %v, %v; want %v", p, err, pong)
+	}
+
+	if _, err = ping.Deserialize(nil); err == nil {
+		t.Errorf("ping.Deserialize(nil) succeeded; want an error")
+	}
+	if _, err = pong.Deserialize(bytes.NewBuffer([]byte{0, 0, 0, 1})); err == nil || err.Error() != "Pong too short" {
+		t.Errorf("pong.Deserialize(short) = %v; want Pong too short", err)
 	}
 }
@@ -2,14 +2,25 @@ package pingpong
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"time"
 )
 
+// Ping asks the peer for a Pong with the same sequence number and timestamp.
 type Ping struct {
+	Seq       uint32 //Sequence number of the ping
+	Timestamp int64  //Time the ping was sent, in nanoseconds since the epoch of the sender
+}
+
+// NewPing creates the Ping with sequence number seq, sent now.
+func NewPing(seq uint32) Ping {
+	return Ping{Seq: seq, Timestamp: time.Now().UnixNano()}
 }
 
 func (p Ping) String() string {
-	return p.Name()
+	return fmt.Sprintf("%v{seq=%v, t=%v}", p.Name(), p.Seq, time.Unix(0, p.Timestamp).Format(time.RFC3339Nano))
 }
 
 func (p Ping) Name() string {
@@ -21,17 +32,43 @@ func (p Ping) Code() uint16 {
 }
 
 func (p Ping) Serialize(buff *bytes.Buffer) error {
-	return nil
+	if err := neti.EncodeNumberToBuffer(p.Seq, buff); err != nil {
+		return err
+	}
+	return neti.EncodeNumberToBuffer(p.Timestamp, buff)
 }
 
 func (p Ping) Deserialize(buff *bytes.Buffer) (neti.Message, error) {
-	return Ping{}, nil
+	return p.deserialize(p.Name(), buff)
+}
+
+// deserialize deserializes the fields of a Ping, or of the message name embedding it.
+func (p Ping) deserialize(name string, buff *bytes.Buffer) (Ping, error) {
+	var ping Ping
+	if buff == nil || buff.Len() < 12 {
+		return ping, errors.New(fmt.Sprintf("%v too short", name))
+	}
+	if err := neti.DecodeNumberFromBuffer(&ping.Seq, buff); err != nil {
+		return ping, err
+	}
+	err := neti.DecodeNumberFromBuffer(&ping.Timestamp, buff)
+	return ping, err
 }
 
+// Pong is the reply to a Ping, echoing its sequence number and timestamp.
 type Pong struct {
 	Ping
 }
 
+// NewPong creates the reply to ping.
+func NewPong(ping Ping) Pong {
+	return Pong{Ping: ping}
+}
+
+func (p Pong) String() string {
+	return fmt.Sprintf("%v{seq=%v, t=%v}", p.Name(), p.Seq, time.Unix(0, p.Timestamp).Format(time.RFC3339Nano))
+}
+
 func (p Pong) Code() uint16 {
 	return 2
 }
@@ -39,3 +76,13 @@ func (p Pong) Code() uint16 {
 func (p Pong) Name() string {
 	return "Pong"
 }
+
+func (p Pong) Deserialize(buff *bytes.Buffer) (neti.Message, error) {
+	ping, err := p.deserialize(p.Name(), buff)
+	return Pong{Ping: ping}, err
+}
+
+// RTT returns the round trip time of the Ping the pong replies to, received at now.
+func (p Pong) RTT(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, p.Timestamp))
+}
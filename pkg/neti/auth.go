@@ -0,0 +1,415 @@
+package neti
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	authNonceSize = 32
+	authMacSize   = sha256.Size
+	authTimeout   = 10 * time.Second
+)
+
+var authMagic = []byte("NETA")
+
+// WithClusterKey enables authentication with a key shared by all the nodes of a cluster.
+// Peers of stream Nets (tcp, unix) prove knowledge of the key when connecting, and every frame of stream
+// and datagram Nets (udp, unixgram) carries an HMAC-SHA256 and a sequence number so that forged and replayed
+// frames are dropped, and counted in Stats.
+func WithClusterKey(key []byte) Option {
+	return func(o *options) {
+		o.clusterKey = key
+	}
+}
+
+// clusterAuth authenticates peers and frames with the cluster key.
+type clusterAuth struct {
+	key   []byte
+	stats *Stats
+}
+
+func newClusterAuth(o *options) *clusterAuth {
+	if o.clusterKey == nil {
+		return nil
+	}
+	return &clusterAuth{key: o.clusterKey, stats: o.stats}
+}
+
+// authenticatedConn is implemented by the connections that authenticate their peer with the cluster key.
+type authenticatedConn interface {
+	authenticated() bool
+}
+
+// isAuthenticated returns whether the peer of conn is authenticated with the cluster key.
+func isAuthenticated(conn HostConn) bool {
+	a, ok := conn.(authenticatedConn)
+	return ok && a.authenticated()
+}
+
+func mac(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func nonce(size int) []byte {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// clientHandshake proves knowledge of the key to the server on the other end of conn, and checks the server does too:
+//
+//	client -> server: "NETA" | client nonce
+//	server -> client: server nonce | HMAC(key, "server" | client nonce | server nonce)
+//	client -> server: HMAC(key, "client" | client nonce | server nonce)
+//	server -> client: 1
+func (a *clusterAuth) clientHandshake(conn tcpHostConn) (*streamAuth, error) {
+	_ = conn.conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.conn.SetDeadline(time.Time{})
+
+	cNonce := nonce(authNonceSize)
+	if err := conn.Send(append(append([]byte{}, authMagic...), cNonce...)); err != nil {
+		return nil, err
+	}
+	b, err := conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != authNonceSize+authMacSize {
+		return nil, errors.New("authentication failed: invalid server proof")
+	}
+	sNonce := b[:authNonceSize]
+	if !hmac.Equal(b[authNonceSize:], mac(a.key, []byte("server"), cNonce, sNonce)) {
+		a.stats.FailedAuthentications.Add(1)
+		return nil, errors.New("authentication failed: server does not know the cluster key")
+	}
+	if err = conn.Send(mac(a.key, []byte("client"), cNonce, sNonce)); err != nil {
+		return nil, err
+	}
+	if b, err = conn.Receive(); err != nil {
+		return nil, errors.New("authentication failed: cluster key rejected by server")
+	} else if len(b) != 1 || b[0] != 1 {
+		return nil, errors.New("authentication failed: invalid server acknowledgement")
+	}
+	return a.session(cNonce, sNonce, 'c', 's'), nil
+}
+
+// serverHandshake is the server side of clientHandshake.
+func (a *clusterAuth) serverHandshake(conn tcpHostConn) (*streamAuth, error) {
+	_ = conn.conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.conn.SetDeadline(time.Time{})
+
+	b, err := conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != len(authMagic)+authNonceSize || !bytes.HasPrefix(b, authMagic) {
+		a.stats.FailedAuthentications.Add(1)
+		return nil, errors.New("authentication failed: peer did not start an authentication handshake")
+	}
+	cNonce := b[len(authMagic):]
+	sNonce := nonce(authNonceSize)
+	if err = conn.Send(append(append([]byte{}, sNonce...), mac(a.key, []byte("server"), cNonce, sNonce)...)); err != nil {
+		return nil, err
+	}
+	if b, err = conn.Receive(); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(b, mac(a.key, []byte("client"), cNonce, sNonce)) {
+		a.stats.FailedAuthentications.Add(1)
+		return nil, errors.New("authentication failed: peer does not know the cluster key")
+	}
+	if err = conn.Send([]byte{1}); err != nil {
+		return nil, err
+	}
+	return a.session(cNonce, sNonce, 's', 'c'), nil
+}
+
+func (a *clusterAuth) session(cNonce []byte, sNonce []byte, sendLabel byte, recvLabel byte) *streamAuth {
+	return &streamAuth{
+		key:       mac(a.key, []byte("session"), cNonce, sNonce),
+		sendLabel: sendLabel,
+		recvLabel: recvLabel,
+		stats:     a.stats,
+	}
+}
+
+// streamAuth authenticates the frames of a connection with a key derived for the connection in the handshake.
+// Frames are followed by their sequence number and HMAC(key, direction | sequence number | frame).
+type streamAuth struct {
+	key       []byte
+	sendLabel byte
+	recvLabel byte
+	sendSeq   uint64 //guarded by the send lock of the connection
+	recvSeq   uint64
+	stats     *Stats
+}
+
+func (s *streamAuth) seal(b []byte) []byte {
+	s.sendSeq++
+	return sealFrame(s.key, s.sendLabel, s.sendSeq, b)
+}
+
+// open returns the frame without its trailer, if it is authentic and was not received before.
+func (s *streamAuth) open(b []byte) ([]byte, bool) {
+	frame, seq, ok := openFrame(s.key, s.recvLabel, b)
+	if !ok {
+		s.stats.UnauthenticatedFrames.Add(1)
+		return nil, false
+	}
+	if seq <= s.recvSeq {
+		s.stats.ReplayedFrames.Add(1)
+		return nil, false
+	}
+	s.recvSeq = seq
+	return frame, true
+}
+
+func sealFrame(key []byte, label byte, seq uint64, b []byte) []byte {
+	sealed := make([]byte, 0, len(b)+8+authMacSize)
+	sealed = append(sealed, b...)
+	sealed = binary.BigEndian.AppendUint64(sealed, seq)
+	return append(sealed, mac(key, []byte{label}, sealed)...)
+}
+
+func openFrame(key []byte, label byte, b []byte) ([]byte, uint64, bool) {
+	if len(b) < 8+authMacSize {
+		return nil, 0, false
+	}
+	signed := b[:len(b)-authMacSize]
+	if !hmac.Equal(b[len(signed):], mac(key, []byte{label}, signed)) {
+		return nil, 0, false
+	}
+	frame := signed[:len(signed)-8]
+	return frame, binary.BigEndian.Uint64(signed[len(frame):]), true
+}
+
+// datagramAuth authenticates datagrams.
+// Datagrams are followed by the address they are sent to, the random id of the sender, the time they were sent,
+// a sequence number and the HMAC of all of it.
+// Receivers drop datagrams sent to another address, so that they cannot be replayed to other nodes,
+// datagrams older than datagramMaxAge, so that they cannot be replayed once the windows are lost on restart,
+// and keep a window of the sequence numbers received from each sender to drop the datagrams replayed until then.
+type datagramAuth struct {
+	key     []byte
+	sender  []byte
+	seq     *atomic.Uint64
+	windows *replayWindows
+	locals  *localAddrs
+	stats   *Stats
+}
+
+const (
+	// datagramMaxAge is how old a datagram can be, the clocks of nodes must be synchronized within it.
+	datagramMaxAge = 30 * time.Second
+	// maxReplayWindows bounds the senders tracked, the least recently heard from are evicted first.
+	maxReplayWindows = 4096
+)
+
+func newDatagramAuth(o *options) *datagramAuth {
+	if o.clusterKey == nil {
+		return nil
+	}
+	return &datagramAuth{
+		key:     mac(o.clusterKey, []byte("datagram")),
+		sender:  nonce(8),
+		seq:     &atomic.Uint64{},
+		windows: newReplayWindows(datagramMaxAge),
+		locals:  &localAddrs{lock: &sync.Mutex{}},
+		stats:   o.stats,
+	}
+}
+
+// seal returns the datagram b sent to dest, followed by its trailer.
+func (d *datagramAuth) seal(b []byte, dest net.Addr) []byte {
+	return d.sealAt(b, dest, time.Now())
+}
+
+func (d *datagramAuth) sealAt(b []byte, dest net.Addr, sent time.Time) []byte {
+	to := dest.String()
+	sealed := make([]byte, 0, len(b)+len(to)+2+len(d.sender)+8)
+	sealed = append(append(sealed, b...), to...)
+	sealed = binary.BigEndian.AppendUint16(sealed, uint16(len(to)))
+	sealed = append(sealed, d.sender...)
+	sealed = binary.BigEndian.AppendUint64(sealed, uint64(sent.UnixNano()))
+	return sealFrame(d.key, 'd', d.seq.Add(1), sealed)
+}
+
+// open returns the datagram received on local without its trailer,
+// if it is authentic, sent to local, recent and was not received before.
+func (d *datagramAuth) open(b []byte, local net.Addr) ([]byte, bool) {
+	frame, seq, ok := openFrame(d.key, 'd', b)
+	if !ok || len(frame) < len(d.sender)+8+2 {
+		d.stats.UnauthenticatedFrames.Add(1)
+		return nil, false
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(frame[len(frame)-8:])))
+	frame = frame[:len(frame)-8]
+	sender := string(frame[len(frame)-len(d.sender):])
+	frame = frame[:len(frame)-len(d.sender)]
+	toLen := int(binary.BigEndian.Uint16(frame[len(frame)-2:]))
+	frame = frame[:len(frame)-2]
+	if len(frame) < toLen {
+		d.stats.UnauthenticatedFrames.Add(1)
+		return nil, false
+	}
+	to := string(frame[len(frame)-toLen:])
+	frame = frame[:len(frame)-toLen]
+	now := time.Now()
+	if !d.locals.match(to, local) || now.Sub(sent) > datagramMaxAge || sent.Sub(now) > datagramMaxAge {
+		d.stats.ReplayedFrames.Add(1)
+		return nil, false
+	}
+	if !d.windows.accept(sender, seq) {
+		d.stats.ReplayedFrames.Add(1)
+		return nil, false
+	}
+	return frame, true
+}
+
+// localAddrs matches the destinations of datagrams with the address of the socket that received them.
+// Sockets listening on an unspecified address match the addresses of the interfaces of the host.
+type localAddrs struct {
+	lock      *sync.Mutex
+	ips       map[string]bool
+	refreshed time.Time
+}
+
+func (l *localAddrs) match(to string, local net.Addr) bool {
+	udpAddr, ok := local.(*net.UDPAddr)
+	if !ok {
+		return to == local.String()
+	}
+	host, port, err := net.SplitHostPort(to)
+	if err != nil || port != strconv.Itoa(udpAddr.Port) {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if !udpAddr.IP.IsUnspecified() {
+		return ip.Equal(udpAddr.IP)
+	}
+	return l.isLocal(ip)
+}
+
+// isLocal returns whether ip is an address of the interfaces of the host, which are listed again at most once a second.
+func (l *localAddrs) isLocal(ip net.IP) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.ips[ip.String()] {
+		return true
+	}
+	if time.Since(l.refreshed) < time.Second {
+		return false
+	}
+	l.refreshed = time.Now()
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	l.ips = make(map[string]bool)
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			l.ips[ipNet.IP.String()] = true
+		}
+	}
+	return l.ips[ip.String()]
+}
+
+// replayWindows are the replay windows of the senders of datagrams.
+// Windows are created for the senders of authentic datagrams only, and at most maxReplayWindows are kept.
+// If maxAge is set, the windows of senders silent for maxAge are evicted, their datagrams must be too old to be accepted.
+type replayWindows struct {
+	lock      *sync.Mutex
+	windows   map[string]*replayWindow
+	maxAge    time.Duration
+	lastSweep time.Time
+}
+
+func newReplayWindows(maxAge time.Duration) *replayWindows {
+	return &replayWindows{lock: &sync.Mutex{}, windows: make(map[string]*replayWindow), maxAge: maxAge}
+}
+
+// accept returns whether seq was not received from sender before.
+func (r *replayWindows) accept(sender string, seq uint64) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	w, ok := r.windows[sender]
+	if !ok {
+		r.evict(now)
+		w = &replayWindow{}
+		r.windows[sender] = w
+	}
+	if !w.accept(seq) {
+		return false
+	}
+	w.last = now
+	return true
+}
+
+// evict removes the windows of senders silent for maxAge, and the least recently used ones
+// while there are maxReplayWindows. It is called with the lock held.
+func (r *replayWindows) evict(now time.Time) {
+	if r.maxAge > 0 && now.Sub(r.lastSweep) > r.maxAge {
+		r.lastSweep = now
+		for sender, w := range r.windows {
+			if now.Sub(w.last) > r.maxAge {
+				delete(r.windows, sender)
+			}
+		}
+	}
+	for len(r.windows) >= maxReplayWindows {
+		var oldest string
+		for sender, w := range r.windows {
+			if oldest == "" || w.last.Before(r.windows[oldest].last) {
+				oldest = sender
+			}
+		}
+		delete(r.windows, oldest)
+	}
+}
+
+// replayWindow tracks the last 64 sequence numbers received, older ones are rejected.
+type replayWindow struct {
+	max  uint64
+	seen uint64    //bit i is set if max-i was received
+	last time.Time //when a datagram was last accepted
+}
+
+func (w *replayWindow) accept(seq uint64) bool {
+	if seq > w.max {
+		shift := seq - w.max
+		if shift >= 64 {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.max = seq
+		return true
+	}
+	offset := w.max - seq
+	if offset >= 64 || w.seen&(1<<offset) != 0 {
+		return false
+	}
+	w.seen |= 1 << offset
+	return true
+}
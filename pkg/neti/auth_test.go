@@ -0,0 +1,211 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"testing"
+	"time"
+)
+
+var testClusterKey = []byte("cluster key")
+
+func TestStreamAuthHandshake(t *testing.T) {
+	stats := &Stats{}
+	server := newStreamNet("tcp", log.StandardLogger(), newOptions([]Option{WithClusterKey(testClusterKey), WithStats(stats)}))
+	server.RegisterMessage(registryMsg{code: 1})
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	addr := server.(*tcp).listener.Addr().String()
+
+	client := NewTcpNet(log.StandardLogger(), WithClusterKey(testClusterKey))
+	conn, err := client.Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "an authenticated connection", func() { accepted = <-conns })
+	if !isAuthenticated(conn) || !isAuthenticated(accepted) {
+		t.Fatal("the ends of the connection are not authenticated")
+	}
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 1})
+	if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+
+	// the client checks the proof of the server first
+	otherStats := &Stats{}
+	if conn, err := NewTcpNet(log.StandardLogger(), WithClusterKey([]byte("other key")), WithStats(otherStats)).Open(addr); err == nil {
+		_ = conn.Close()
+		t.Fatal("opened a connection with another cluster key")
+	}
+	if n := otherStats.FailedAuthentications.Load(); n != 1 {
+		t.Fatalf("%v failed authentications with another key, expected 1", n)
+	}
+	// a peer without the key does not start the handshake
+	plain, err := NewTcpNet(log.StandardLogger()).Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	_ = plain.Send([]byte{0, 1, 0, 0, 0, 1})
+	testutil.Within(t, testTimeout, "the connection without the key to be closed", func() {
+		if _, err := plain.Receive(); err == nil {
+			t.Error("the connection without the key is open")
+		}
+	})
+	if n := stats.FailedAuthentications.Load(); n != 1 {
+		t.Fatalf("%v failed authentications without the key, expected 1", n)
+	}
+}
+
+func TestStreamAuthTamperAndReplay(t *testing.T) {
+	stats := &Stats{}
+	a := &clusterAuth{key: testClusterKey, stats: stats}
+	cNonce, sNonce := nonce(authNonceSize), nonce(authNonceSize)
+	client, server := a.session(cNonce, sNonce, 'c', 's'), a.session(cNonce, sNonce, 's', 'c')
+
+	sealed := client.seal([]byte("frame"))
+	if b, ok := server.open(append([]byte(nil), sealed...)); !ok || string(b) != "frame" {
+		t.Fatalf("opened %q, %v", b, ok)
+	}
+	if _, ok := server.open(sealed); ok {
+		t.Fatal("opened a replayed frame")
+	}
+	tampered := client.seal([]byte("frame"))
+	tampered[0] ^= 1
+	if _, ok := server.open(tampered); ok {
+		t.Fatal("opened a tampered frame")
+	}
+	// frames are bound to their direction
+	if _, ok := client.open(server.seal([]byte("reply"))); !ok {
+		t.Fatal("the reply of the server does not open")
+	}
+	if _, ok := server.open(server.seal([]byte("reflected"))); ok {
+		t.Fatal("opened a frame reflected back to its sender")
+	}
+	if stats.ReplayedFrames.Load() != 1 || stats.UnauthenticatedFrames.Load() != 2 {
+		t.Fatalf("%v replayed and %v unauthenticated frames, expected 1 and 2", stats.ReplayedFrames.Load(), stats.UnauthenticatedFrames.Load())
+	}
+}
+
+func TestDatagramAuth(t *testing.T) {
+	opts := newOptions([]Option{WithClusterKey(testClusterKey)})
+	sender, receiver := newDatagramAuth(opts), newDatagramAuth(opts)
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}
+
+	sealed := sender.seal([]byte("datagram"), local)
+	if b, ok := receiver.open(append([]byte(nil), sealed...), local); !ok || string(b) != "datagram" {
+		t.Fatalf("opened %q, %v", b, ok)
+	}
+	if _, ok := receiver.open(append([]byte(nil), sealed...), local); ok {
+		t.Fatal("opened a replayed datagram")
+	}
+	tampered := sender.seal([]byte("datagram"), local)
+	tampered[0] ^= 1
+	if _, ok := receiver.open(tampered, local); ok {
+		t.Fatal("opened a tampered datagram")
+	}
+	if _, ok := receiver.open(sender.seal([]byte("datagram"), other), local); ok {
+		t.Fatal("opened a datagram sent to another node")
+	}
+	if _, ok := receiver.open(sender.sealAt([]byte("datagram"), local, time.Now().Add(-2*datagramMaxAge)), local); ok {
+		t.Fatal("opened a datagram older than datagramMaxAge")
+	}
+	// sockets listening on all interfaces accept datagrams sent to any address of the host
+	if _, ok := receiver.open(sender.seal([]byte("datagram"), local), &net.UDPAddr{IP: net.IPv4zero, Port: 9000}); !ok {
+		t.Fatal("the socket listening on all interfaces dropped a datagram sent to the loopback")
+	}
+	// datagrams are not sent in order
+	late := sender.seal([]byte("late"), local)
+	if _, ok := receiver.open(sender.seal([]byte("early"), local), local); !ok {
+		t.Fatal("dropped a datagram")
+	}
+	if _, ok := receiver.open(late, local); !ok {
+		t.Fatal("dropped a datagram received out of order")
+	}
+}
+
+func TestDatagramAuthEvictsWindows(t *testing.T) {
+	opts := newOptions([]Option{WithClusterKey(testClusterKey)})
+	sender, receiver := newDatagramAuth(opts), newDatagramAuth(opts)
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+	for i := 0; i < maxReplayWindows+10; i++ {
+		sender.sender = nonce(8)
+		if _, ok := receiver.open(sender.seal([]byte("datagram"), local), local); !ok {
+			t.Fatalf("dropped the datagram of sender %v", i)
+		}
+	}
+	if n := len(receiver.windows.windows); n > maxReplayWindows {
+		t.Fatalf("%v windows kept, expected at most %v", n, maxReplayWindows)
+	}
+	for _, w := range receiver.windows.windows {
+		w.last = time.Now().Add(-2 * datagramMaxAge)
+	}
+	receiver.windows.lastSweep = time.Time{}
+	sender.sender = nonce(8)
+	if _, ok := receiver.open(sender.seal([]byte("datagram"), local), local); !ok {
+		t.Fatal("dropped the datagram of a new sender")
+	}
+	if n := len(receiver.windows.windows); n != 1 {
+		t.Fatalf("%v windows kept once the senders are silent, expected 1", n)
+	}
+}
+
+func TestDatagramAuthReplayToAnotherNode(t *testing.T) {
+	stats := &Stats{}
+	receiver := NewUdpNet(1024, WithClusterKey(testClusterKey), WithStats(stats))
+	receiver.RegisterMessage(registryMsg{code: 1})
+	datagrams, err := receiver.Listen(freeAddr(t, "udp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.CloseListener()
+	receiverAddr := receiver.(*udp).conn.LocalAddr()
+	// the node the datagram is captured from
+	captured, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer captured.Close()
+
+	sender := NewUdpNet(1024, WithClusterKey(testClusterKey))
+	if _, err := sender.Listen(freeAddr(t, "udp")); err != nil {
+		t.Fatal(err)
+	}
+	defer sender.CloseListener()
+	conn, err := sender.Open(captured.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 1})
+	p := make([]byte, 1024)
+	_ = captured.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := captured.ReadFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := captured.WriteTo(p[:n], receiverAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the datagram sent to the receiver afterwards is the first it delivers
+	conn, err = sender.Open(receiverAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 2})
+	var d HostConn
+	testutil.Within(t, testTimeout, "a datagram", func() { d = <-datagrams })
+	if m, err := receiver.RecvFrom(d); err != nil || m.(registryMsg).seq != 2 {
+		t.Fatalf("received %v, %v; expected message 2", m, err)
+	}
+	if stats.ReplayedFrames.Load() != 1 {
+		t.Fatalf("%v replayed datagrams, expected the captured one", stats.ReplayedFrames.Load())
+	}
+}
@@ -0,0 +1,130 @@
+package neti
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// AuthorizationRequest describes a connection (or datagram) a service is about to accept.
+// Peer is the identity the peer claims in its hello: it is only authenticated with a cluster key (see WithClusterKey),
+// without one any peer can claim any node id, so policies must not rely on it.
+type AuthorizationRequest struct {
+	Addr      net.Addr  //Address of the peer
+	Peer      *NodeInfo //Identity claimed by the peer, nil for datagrams and peers speaking version 1
+	ServiceId string    //Id of the listener the connection is for
+	SenderId  string    //Id of the listener on the peer
+}
+
+// Authorizer decides whether a service accepts a connection or datagram.
+// Authorize returns nil to accept it, or an error explaining the rejection.
+// Rejected connections are reported to the opener in the handshake, rejected datagrams are dropped.
+type Authorizer interface {
+	Authorize(req AuthorizationRequest) error
+}
+
+// AuthorizerFunc is an Authorizer implemented by a function.
+type AuthorizerFunc func(req AuthorizationRequest) error
+
+// Authorize calls f.
+func (f AuthorizerFunc) Authorize(req AuthorizationRequest) error {
+	return f(req)
+}
+
+// WithAuthorizer sets the Authorizer of a service.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = authorizer
+	}
+}
+
+// AllOf returns an Authorizer that accepts what all the authorizers accept.
+func AllOf(authorizers ...Authorizer) Authorizer {
+	return AuthorizerFunc(func(req AuthorizationRequest) error {
+		for _, a := range authorizers {
+			if err := a.Authorize(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CIDRList is an Authorizer accepting or rejecting peers by their ip address.
+type CIDRList struct {
+	allow    bool
+	nets     []*net.IPNet
+	services map[string]bool
+}
+
+// NewAllowList creates a CIDRList that only accepts peers with addresses in cidrs (e.g. 10.0.0.0/8, 192.168.1.7/32).
+// Peers on unix sockets, which have no ip, are accepted: access to them is controlled by the permissions of the socket.
+func NewAllowList(cidrs ...string) (*CIDRList, error) {
+	return newCIDRList(true, cidrs)
+}
+
+// NewDenyList creates a CIDRList that rejects peers with addresses in cidrs, peers on unix sockets are accepted.
+func NewDenyList(cidrs ...string) (*CIDRList, error) {
+	return newCIDRList(false, cidrs)
+}
+
+func newCIDRList(allow bool, cidrs []string) (*CIDRList, error) {
+	l := &CIDRList{allow: allow, services: make(map[string]bool)}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		l.nets = append(l.nets, ipNet)
+	}
+	return l, nil
+}
+
+// ForServices returns a copy of the list that only applies to connections for the given listener ids,
+// connections for other ids are accepted.
+func (l *CIDRList) ForServices(ids ...string) *CIDRList {
+	restricted := &CIDRList{allow: l.allow, nets: l.nets, services: make(map[string]bool)}
+	for _, id := range ids {
+		restricted.services[id] = true
+	}
+	return restricted
+}
+
+// Authorize accepts or rejects the peer by its ip address, peers without one (on unix sockets) are accepted.
+func (l *CIDRList) Authorize(req AuthorizationRequest) error {
+	if len(l.services) > 0 && !l.services[req.ServiceId] {
+		return nil
+	}
+	ip := addrIP(req.Addr)
+	if ip == nil {
+		return nil
+	}
+	contained := false
+	for _, ipNet := range l.nets {
+		if ipNet.Contains(ip) {
+			contained = true
+			break
+		}
+	}
+	if contained != l.allow {
+		return errors.New(fmt.Sprintf("address %v is not allowed for %v", req.Addr, req.ServiceId))
+	}
+	return nil
+}
+
+// addrIP returns the ip of addr, nil if it does not have one (e.g. unix sockets).
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,138 @@
+package neti
+
+import (
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"testing"
+)
+
+func TestCIDRList(t *testing.T) {
+	allow, err := NewAllowList("10.0.0.0/8", "192.168.1.7/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := NewDenyList("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAllowList("10.0.0.0"); err == nil {
+		t.Fatal("created a list with an invalid cidr")
+	}
+	inside := &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 9000}
+	outside := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 8), Port: 9000}
+	unix := &net.UnixAddr{Name: "/tmp/node.sock", Net: "unix"}
+	for _, test := range []struct {
+		name       string
+		authorizer Authorizer
+		addr       net.Addr
+		service    string
+		accepted   bool
+	}{
+		{"AllowInside", allow, inside, "echo", true},
+		{"AllowOutside", allow, outside, "echo", false},
+		{"AllowHost", allow, &net.TCPAddr{IP: net.IPv4(192, 168, 1, 7)}, "echo", true},
+		{"AllowUnix", allow, unix, "echo", true},
+		{"DenyInside", deny, inside, "echo", false},
+		{"DenyOutside", deny, outside, "echo", true},
+		{"DenyUnix", deny, unix, "echo", true},
+		{"ForService", deny.ForServices("admin"), inside, "admin", false},
+		{"ForOtherService", deny.ForServices("admin"), inside, "echo", true},
+		{"AllOf", AllOf(allow, deny), inside, "echo", false},
+		{"AllOfNone", AllOf(), outside, "echo", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.authorizer.Authorize(AuthorizationRequest{Addr: test.addr, ServiceId: test.service})
+			if (err == nil) != test.accepted {
+				t.Fatalf("Authorize(%v, %v) = %v", test.addr, test.service, err)
+			}
+		})
+	}
+}
+
+func TestTcpServiceAuthorizer(t *testing.T) {
+	stats := &Stats{}
+	var requests []AuthorizationRequest
+	authorizer := AuthorizerFunc(func(req AuthorizationRequest) error {
+		requests = append(requests, req)
+		if req.SenderId == "intruder" {
+			return errors.New("not welcome")
+		}
+		return nil
+	})
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger(), WithAuthorizer(authorizer), WithStats(stats))
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), WithNodeId("node-2"))
+	defer senderService.Close()
+
+	_, err := senderService.RegisterListener("intruder").OpenTo(addr, "receiver")
+	var rejected *HandshakeRejectedError
+	if !errors.As(err, &rejected) || rejected.Reason != RejectUnauthorized || rejected.Message != "not welcome" {
+		t.Fatalf("OpenTo failed with %v, expected the rejection of the authorizer", err)
+	}
+	if stats.Unauthorized.Load() != 1 {
+		t.Fatalf("%v unauthorized connections, expected 1", stats.Unauthorized.Load())
+	}
+
+	conn, err := senderService.RegisterListener("sender").OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testutil.Within(t, testTimeout, "the authorized connection", func() { <-receiver.Accept() })
+	if len(requests) != 2 {
+		t.Fatalf("%v authorization requests, expected 2", len(requests))
+	}
+	if req := requests[1]; req.ServiceId != "receiver" || req.Peer == nil || req.Peer.Id != "node-2" || addrIP(req.Addr) == nil {
+		t.Fatalf("authorized %+v, expected the connection of node-2 to receiver", req)
+	}
+}
+
+func TestUdpServiceAuthorizer(t *testing.T) {
+	deny, err := NewDenyList("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	addr := freeAddr(t, "udp")
+	service := InitBaseUdpService(addr, 1024, WithAuthorizer(deny.ForServices("admin")), WithEvents(bus))
+	defer service.Close()
+	service.RegisterListener("admin")
+	echo := service.RegisterListener("echo")
+	echo.RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseUdpService(freeAddr(t, "udp"), 1024)
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+
+	admin, err := sender.OpenTo(addr, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.SendTo(admin, registryMsg{code: 1})
+	testutil.Within(t, testTimeout, "the datagram to be dropped", func() {
+		for e := range events {
+			if e.Type == MessageDropped {
+				if !errors.Is(e.Err, ErrUnauthorized) || e.ServiceId != "admin" {
+					t.Errorf("dropped the datagram to %v with %v, expected ErrUnauthorized", e.ServiceId, e.Err)
+				}
+				return
+			}
+		}
+	})
+	// the list only applies to admin
+	conn, err := sender.OpenTo(addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 1})
+	testutil.Within(t, testTimeout, "the datagram to echo", func() {
+		if m, err := echo.RecvFrom(<-echo.Accept()); err != nil || m.(registryMsg).seq != 1 {
+			t.Errorf("received %v, %v; expected message 1", m, err)
+		}
+	})
+}
@@ -2,21 +2,25 @@ package neti
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"strconv"
-	"strings"
+	"sync"
 )
 
 type basicTcpClient struct {
-	self *string
-	id   string
-	net  Net
-	rcv  chan ReceivedMessage
-	acpt chan *ServiceHostConn
+	service   *basicTcpService
+	self      *string
+	id        string
+	net       Net
+	transport TransportType
+	rcv       chan ReceivedMessage
+	acpt      chan *ServiceHostConn
 
-	msgs map[uint16]MessageDeserializer
+	msgs *deserializers
+	*peerEvents
+	*interceptors
 }
 
 func (b *basicTcpClient) Id() string {
@@ -24,7 +28,7 @@ func (b *basicTcpClient) Id() string {
 }
 
 func (b *basicTcpClient) Type() TransportType {
-	return TCP
+	return b.transport
 }
 
 func (b *basicTcpClient) Accept() <-chan *ServiceHostConn {
@@ -32,118 +36,375 @@ func (b *basicTcpClient) Accept() <-chan *ServiceHostConn {
 }
 
 func (b *basicTcpClient) RegisterMessage(message Message) {
-	if _, ok := b.msgs[message.Code()]; ok {
+	if !b.msgs.register(message) {
 		panic("Message already registered")
-	} else {
-		b.msgs[message.Code()] = message.Deserialize
 	}
 }
 
+// UnregisterMessage stops deserializing the messages with code, which then fail to be received with a DecodeError.
+func (b *basicTcpClient) UnregisterMessage(code uint16) {
+	b.msgs.unregister(code)
+}
+
+// RecvFrom receives the next message of conn, skipping the messages over the rate limit of the listener.
 func (b *basicTcpClient) RecvFrom(conn *ServiceHostConn) (Message, error) {
+	_, msg, err := b.RecvFromContext(conn)
+	return msg, err
+}
+
+// RecvFromContext receives the next message, returning the context of its trace.
+func (b *basicTcpClient) RecvFromContext(conn *ServiceHostConn) (context.Context, Message, error) {
+	for {
+		m, err := b.recv(conn)
+		if err != nil {
+			return context.Background(), nil, err
+		}
+		wrap := m.(MessageWrap)
+		msg, err := b.recvChain(decodeWith(b.msgs, wrap.code, b.id))(conn, wrap.buff.Bytes())
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			return context.Background(), nil, b.decodeFailed(conn, decodeErr)
+		}
+		if err != nil {
+			return context.Background(), nil, err
+		}
+		if msg != nil {
+			b.service.opts.metrics.delivered(conn, b.id, wrap)
+			return recvSpan(b.service.opts.tracer, wrap.trace, conn, b.id, msg), msg, nil
+		}
+	}
+}
+
+// recv receives the next message for the client that is within the rate limit of the service.
+func (b *basicTcpClient) recv(conn *ServiceHostConn) (Message, error) {
 	m, err := b.net.RecvFrom(conn)
-	if err == nil {
-		msg := m.(MessageWrap)
-		if d, ok := b.msgs[msg.code]; ok {
-			return d(msg.buff)
-		} else {
-			log.Warn("Unable to deserialize message with code ", msg.code, " for protocol ", b.id, ": Unknown serializer")
+	for err == nil && b.service.limiter != nil {
+		if ok, lErr := b.service.limiter.admitService(b.id); lErr != nil {
+			_ = conn.Close()
+			return nil, lErr
+		} else if ok {
+			break
 		}
-		return nil, errors.New(fmt.Sprintf("Unable to deserialize message with code %v: Unknown serializer", msg.Code()))
-	} else {
-		return nil, err
+		b.service.opts.events.publish(connEvent(MessageDropped, conn.Conn, b.id, ErrRateLimited))
+		m, err = b.net.RecvFrom(conn)
 	}
+	return m, err
+}
+
+func (b *basicTcpClient) decodeFailed(conn *ServiceHostConn, err *DecodeError) error {
+	log.Warn("Unable to deserialize message with code ", err.Code, " for protocol ", b.id, ": ", err.Err)
+	e := connEvent(DecodeFailed, conn.Conn, b.id, err)
+	e.Code = err.Code
+	b.service.opts.events.publish(e)
+	return err
 }
 
 func (b *basicTcpClient) SendTo(conn *ServiceHostConn, message Message) error {
-	return b.net.SendTo(conn, MessageWrap{Id: b.id, Msg: message})
+	return b.SendToContext(context.Background(), conn, message)
+}
+
+// SendToContext sends the message as part of the trace of ctx, the span context is only sent to peers supporting tracing.
+func (b *basicTcpClient) SendToContext(ctx context.Context, conn *ServiceHostConn, message Message) error {
+	payload, err := serializePayload(message)
+	if err != nil {
+		return err
+	}
+	if !conn.Features.Has(FeatureTracing) {
+		return b.sendChain(wrapSend(b.net, b.id, SpanContext{}))(conn, message, payload)
+	}
+	return sendTraced(ctx, b.service.opts.tracer, conn, b.id, message, func(trace SpanContext) error {
+		return b.sendChain(wrapSend(b.net, b.id, trace))(conn, message, payload)
+	})
+}
+
+// OpenManaged opens a ManagedConn to the peer with addr, dialing it in the background.
+func (b *basicTcpClient) OpenManaged(addr string, id string, config Reconnect) *ManagedConn {
+	return newManagedConn(b, addr, id, config)
 }
 
 func (b *basicTcpClient) OpenTo(addr string, id string) (*ServiceHostConn, error) {
+	addr, nodeId, err := b.service.opts.addressBook.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
 	if conn, err := b.net.Open(addr); err == nil {
-
-		buff := new(bytes.Buffer)
-		if err = EncodeStringToBuffer(id, buff); err != nil {
+		reply, err := b.handshake(conn, id)
+		if err != nil {
+			b.service.opts.events.publish(connEvent(HandshakeFailed, conn, id, err))
+			_ = conn.Close()
 			return nil, err
 		}
-		if err = EncodeStringToBuffer(b.id, buff); err != nil {
-			return nil, err
+		if nodeId != "" && (reply.node == nil || reply.node.Id != nodeId) {
+			_ = conn.Close()
+			return nil, errors.New(fmt.Sprintf("Expected node %v at %v, but found %v", nodeId, addr, reply.node))
 		}
-		if err = conn.Send(buff.Bytes()); err != nil {
-			return nil, err
+		if reply.node != nil {
+			b.service.opts.addressBook.learn(reply.node.Id, addr, isAuthenticated(conn))
 		}
-		if _, err = conn.Receive(); err != nil {
-			return nil, err
+		sConn := &ServiceHostConn{
+			ServiceId:   id,
+			Conn:        conn,
+			Peer:        reply.node,
+			Version:     reply.version,
+			Features:    reply.features,
+			Compression: reply.compression,
 		}
-		return &ServiceHostConn{ServiceId: id, Conn: conn}, err
+		b.service.monitor(sConn, b)
+		return sConn, err
 	} else {
 		return nil, err
 	}
 }
 
+// handshake asks the service on the other end of conn for the listener id, negotiating the protocol version and features.
+func (b *basicTcpClient) handshake(conn HostConn, id string) (*helloReply, error) {
+	opts := b.service.opts
+	h := &hello{
+		minVersion: opts.minVersion,
+		maxVersion: opts.maxVersion,
+		features:   b.service.features(conn),
+		targetId:   id,
+		senderId:   b.id,
+		node:       b.service.nodeInfo(),
+		legacy:     opts.maxVersion < 2, //peers speaking version 1 do not understand the versioned hello
+	}
+	c, compressed := conn.(compressedConn)
+	if compressed {
+		// frames are sent uncompressed until the peer agrees on an algorithm
+		c.setCompression(NoCompression)
+		h.compressions = c.compressions()
+	}
+	buff := new(bytes.Buffer)
+	if err := h.serialize(buff); err != nil {
+		return nil, err
+	}
+	if err := conn.Send(buff.Bytes()); err != nil {
+		return nil, err
+	}
+	b2, err := conn.Receive()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := decodeHelloReply(b2, opts.minVersion < 2)
+	if err != nil {
+		return nil, err
+	}
+	if reply.rejection != nil {
+		return nil, reply.rejection
+	}
+	if reply.version < opts.minVersion || reply.version > opts.maxVersion {
+		return nil, &HandshakeRejectedError{
+			Reason:     RejectIncompatibleVersion,
+			Message:    fmt.Sprintf("peer chose version %v, outside of %v-%v", reply.version, opts.minVersion, opts.maxVersion),
+			MinVersion: reply.version,
+			MaxVersion: reply.version,
+		}
+	}
+	if compressed && reply.features.Has(FeatureCompression) {
+		c.setCompression(reply.compression)
+	}
+	return reply, nil
+}
+
 func (b *basicTcpClient) Self() string {
 	return *b.self
 }
 
-func createTcpClient(self *string, id string, net Net) *basicTcpClient {
+func createTcpClient(service *basicTcpService, self *string, id string, net Net, transport TransportType) *basicTcpClient {
 	return &basicTcpClient{
-		self: self,
-		id:   id,
-		net:  net,
-		rcv:  make(chan ReceivedMessage),
-		acpt: make(chan *ServiceHostConn),
-		msgs: make(map[uint16]MessageDeserializer),
+		service:      service,
+		self:         self,
+		id:           id,
+		net:          net,
+		transport:    transport,
+		rcv:          make(chan ReceivedMessage),
+		acpt:         make(chan *ServiceHostConn),
+		msgs:         newDeserializers(),
+		peerEvents:   newPeerEvents(),
+		interceptors: newInterceptors(),
 	}
 }
 
 type basicTcpService struct {
 	self      string
 	net       Net
+	transport TransportType
 	listeners map[string]*basicTcpClient
+	lock      *sync.RWMutex //Guards listeners, registered while connections are accepted
+	opts      *options
+	limiter   *limiter
 
 	logger *log.Logger
 }
 
-func (b *basicTcpService) GetConfiguration() Configuration {
-	parts := strings.Split(b.self, ":")
-	port, _ := strconv.Atoi(parts[1])
-	return Configuration{
-		ip:   parts[0],
-		port: port,
+func (b *basicTcpService) nodeInfo() *NodeInfo {
+	return &NodeInfo{
+		Id:         b.opts.nodeId,
+		Version:    b.opts.maxVersion,
+		Codecs:     b.opts.codecs,
+		ListenAddr: b.self,
+	}
+}
+
+// features returns the features of the service on conn, compression depends on the Net of the connection.
+func (b *basicTcpService) features(conn HostConn) Feature {
+	features := FeatureMultiplexing
+	if c, ok := conn.(compressedConn); ok && len(c.compressions()) > 0 {
+		features |= FeatureCompression
+	}
+	if b.opts.heartbeats != nil {
+		features |= FeatureHeartbeats
+	}
+	return features | FeatureTracing
+}
+
+// monitor starts the heartbeats on conn, if both ends enabled them.
+func (b *basicTcpService) monitor(conn *ServiceHostConn, client *basicTcpClient) {
+	if conn.Features.Has(FeatureHeartbeats) {
+		startPeerMonitor(conn, *b.opts.heartbeats, client.peerEvents)
 	}
 }
 
+func (b *basicTcpService) GetConfiguration() Configuration {
+	return configurationFor(b.self)
+}
+
+func (b *basicTcpService) Close() error {
+	return b.net.CloseListener()
+}
+
 func (b *basicTcpService) RegisterListener(id string) NetClient {
-	client := createTcpClient(&b.self, id, b.net)
+	client := createTcpClient(b, &b.self, id, b.net, b.transport)
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	b.listeners[id] = client
 	return client
 }
 
+// UnregisterListener stops accepting connections to the NetClient id, the connections it has are kept open.
+func (b *basicTcpService) UnregisterListener(id string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.listeners, id)
+}
+
+func (b *basicTcpService) listener(id string) (*basicTcpClient, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	c, ok := b.listeners[id]
+	return c, ok
+}
+
 func (b *basicTcpService) accept(bid []byte, conn HostConn) {
 	b.logger.Debug("Accepting: ", conn)
-	buff := bytes.NewBuffer(bid)
-	if id, err := DecodeStringFromBuffer(buff); err != nil {
+	cc, compressed := conn.(compressedConn)
+	if compressed {
+		// the reply is sent uncompressed, the peer may not support compression
+		cc.setCompression(NoCompression)
+	}
+	h, err := decodeHello(bid)
+	if err != nil {
 		b.logger.Error(err)
-	} else if c, ok := b.listeners[id]; ok {
-		if id, err = DecodeStringFromBuffer(buff); err != nil {
-			b.logger.Error(err)
+		b.opts.events.publish(connEvent(HandshakeFailed, conn, "", err))
+		_ = conn.Close()
+		return
+	}
+	reply := &helloReply{node: b.nodeInfo()}
+	version, ok := negotiateVersion(b.opts.minVersion, b.opts.maxVersion, h.minVersion, h.maxVersion)
+	c, registered := b.listener(h.targetId)
+	if !ok {
+		reply.rejection = &HandshakeRejectedError{
+			Reason:  RejectIncompatibleVersion,
+			Message: fmt.Sprintf("no common protocol version, peer speaks %v-%v", h.minVersion, h.maxVersion),
+		}
+	} else if !registered {
+		reply.rejection = &HandshakeRejectedError{
+			Reason:  RejectUnknownService,
+			Message: fmt.Sprintf("no listener registered for %v", h.targetId),
+		}
+	} else if err := b.authorize(conn, h); err != nil {
+		b.opts.stats.Unauthorized.Add(1)
+		reply.rejection = &HandshakeRejectedError{
+			Reason:  RejectUnauthorized,
+			Message: err.Error(),
+		}
+	}
+	if reply.rejection != nil {
+		b.logger.Error("Rejecting connection from ", conn, ": ", reply.rejection.Reason, ": ", reply.rejection.Message)
+		reply.rejection.MinVersion, reply.rejection.MaxVersion = b.opts.minVersion, b.opts.maxVersion
+		if reply.rejection.Reason == RejectUnknownService {
+			b.opts.events.publish(connEvent(UnknownService, conn, h.targetId, reply.rejection))
 		} else {
-			if err := conn.Send([]byte{}); err != nil {
-				b.logger.Error(err)
-				_ = conn.Close()
-			} else {
-				c.acpt <- &ServiceHostConn{conn, id, nil}
-			}
+			b.opts.events.publish(connEvent(HandshakeFailed, conn, h.targetId, reply.rejection))
 		}
-	} else {
+		b.reply(conn, reply, h.legacy)
 		_ = conn.Close()
-		b.logger.Error("Received connection for ", id, ", but don't have listener registered")
+		return
+	}
+
+	reply.version = version
+	reply.features = h.features & b.features(conn)
+	if reply.features.Has(FeatureCompression) {
+		reply.compression = b.opts.compression.choose(h.compressions)
+	}
+	if err := b.reply(conn, reply, h.legacy); err != nil {
+		b.logger.Error(err)
+		_ = conn.Close()
+		return
+	}
+	if compressed {
+		cc.setCompression(reply.compression)
 	}
+	if h.node != nil && h.node.ListenAddr != "" {
+		b.opts.addressBook.learn(h.node.Id, reachableAddr(h.node.ListenAddr, conn.Addr()), isAuthenticated(conn))
+	}
+	sConn := &ServiceHostConn{
+		Conn:        conn,
+		ServiceId:   h.senderId,
+		Peer:        h.node,
+		Version:     reply.version,
+		Features:    reply.features,
+		Compression: reply.compression,
+	}
+	b.monitor(sConn, c)
+	c.acpt <- sConn
+}
+
+func (b *basicTcpService) authorize(conn HostConn, h *hello) error {
+	if b.opts.authorizer == nil {
+		return nil
+	}
+	return b.opts.authorizer.Authorize(AuthorizationRequest{
+		Addr:      conn.Addr(),
+		Peer:      h.node,
+		ServiceId: h.targetId,
+		SenderId:  h.senderId,
+	})
+}
+
+// reply sends the reply to a hello, rejections are not sent to peers speaking version 1.
+func (b *basicTcpService) reply(conn HostConn, reply *helloReply, legacy bool) error {
+	if legacy && reply.rejection != nil {
+		return nil
+	}
+	buff := new(bytes.Buffer)
+	if err := reply.serialize(buff, legacy); err != nil {
+		return err
+	}
+	return conn.Send(buff.Bytes())
 }
 
 // InitBaseTcpService creates a new basic tcp service
-func InitBaseTcpService(listenAddr string, logger *log.Logger) NetService {
-	net := NewTcpNet(logger)
+// listenAddr is either an ip:port pair or a unix domain socket address (unix:///path)
+func InitBaseTcpService(listenAddr string, logger *log.Logger, opts ...Option) NetService {
+	o := newOptions(opts)
+	return initStreamService(listenAddr, newStreamNet("tcp", logger, o), TCP, logger, o)
+}
+
+// initStreamService creates a service multiplexing the connections of a stream oriented Net (tcp, unix, ws, quic)
+func initStreamService(listenAddr string, net Net, transport TransportType, logger *log.Logger, opts *options) *basicTcpService {
 	net.RegisterMessage(MessageWrap{})
+	net.RegisterMessage(tracedMessageWrap{})
 	listen, err := net.Listen(listenAddr)
 	if err != nil {
 		panic(err)
@@ -152,18 +413,28 @@ func InitBaseTcpService(listenAddr string, logger *log.Logger) NetService {
 	service := &basicTcpService{
 		self:      listenAddr,
 		net:       net,
+		transport: transport,
 		listeners: make(map[string]*basicTcpClient),
+		lock:      &sync.RWMutex{},
+		opts:      opts,
+		limiter:   newLimiter(opts),
 		logger:    logger,
 	}
 	go func() {
 		for {
 			select {
-			case conn := <-listen:
-				if bid, err := conn.Receive(); err != nil {
-					logger.Error(err)
-				} else {
-					go service.accept(bid, conn)
+			case conn, ok := <-listen:
+				if !ok {
+					return
 				}
+				go func(conn HostConn) {
+					if bid, err := conn.Receive(); err != nil {
+						logger.Error(err)
+						_ = conn.Close()
+					} else {
+						service.accept(bid, conn)
+					}
+				}(conn)
 			}
 		}
 	}()
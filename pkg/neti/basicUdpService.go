@@ -1,23 +1,26 @@
 package neti
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"strconv"
-	"strings"
+	"sync"
 )
 
 type basicUpdClient struct {
-	self *string
-	id   string
-	net  Net
+	service *basicUdpService
+	self    *string
+	id      string
+	net     Net
 	//rcv chan ReceivedMessage
 	listenCh chan *ServiceHostConn
 
 	buffered map[string][]ReceivedMessage
 
-	msgs map[uint16]MessageDeserializer
+	msgs        *deserializers
+	*peerEvents //never emitted, datagrams are not monitored
+	*interceptors
 }
 
 func (b *basicUpdClient) Accept() <-chan *ServiceHostConn {
@@ -32,32 +35,65 @@ func (b *basicUpdClient) Type() TransportType {
 	return UDP
 }
 
+// OpenManaged opens a ManagedConn to the peer with addr, dialing it in the background.
+func (b *basicUpdClient) OpenManaged(addr string, id string, config Reconnect) *ManagedConn {
+	return newManagedConn(b, addr, id, config)
+}
+
 func (b *basicUpdClient) OpenTo(addr string, serviceId string) (*ServiceHostConn, error) {
+	addr, _, err := b.service.opts.addressBook.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
 	if conn, err := b.net.Open(addr); err != nil {
 		return nil, err
 	} else {
-		return &ServiceHostConn{conn, serviceId, nil}, err
+		return &ServiceHostConn{Conn: conn, ServiceId: serviceId}, err
 	}
 }
 
 func (b *basicUpdClient) RegisterMessage(message Message) {
-	if _, ok := b.msgs[message.Code()]; ok {
+	if !b.msgs.register(message) {
 		panic("Message already registered")
-	} else {
-		b.msgs[message.Code()] = message.Deserialize
 	}
 }
 
+// UnregisterMessage stops deserializing the messages with code, which then fail to be received with a DecodeError.
+func (b *basicUpdClient) UnregisterMessage(code uint16) {
+	b.msgs.unregister(code)
+}
+
 func (b *basicUpdClient) RecvFrom(conn *ServiceHostConn) (Message, error) {
+	_, msg, err := b.RecvFromContext(conn)
+	return msg, err
+}
+
+// RecvFromContext returns the message delivered on conn, and the context of its trace.
+func (b *basicUpdClient) RecvFromContext(conn *ServiceHostConn) (context.Context, Message, error) {
 	if conn.Msg != nil {
-		defer func() { conn.Msg = nil }()
-		return conn.Msg, nil
+		defer func() { conn.Msg, conn.ctx = nil, nil }()
+		return conn.ctx, conn.Msg, nil
 	}
-	return nil, errors.New(fmt.Sprintf("Nothing to receive from connection"))
+	return context.Background(), nil, errors.New(fmt.Sprintf("Nothing to receive from connection"))
 }
 
 func (b *basicUpdClient) SendTo(conn *ServiceHostConn, message Message) error {
-	return b.net.SendTo(conn, MessageWrap{Id: b.id, Msg: message})
+	return b.SendToContext(context.Background(), conn, message)
+}
+
+// SendToContext sends the message as part of the trace of ctx.
+// There is no handshake to negotiate tracing, so the span context is only sent with WithDatagramTracing.
+func (b *basicUpdClient) SendToContext(ctx context.Context, conn *ServiceHostConn, message Message) error {
+	payload, err := serializePayload(message)
+	if err != nil {
+		return err
+	}
+	return sendTraced(ctx, b.service.opts.tracer, conn, b.id, message, func(trace SpanContext) error {
+		if !b.service.opts.datagramTracing {
+			trace = SpanContext{}
+		}
+		return b.sendChain(wrapSend(b.net, b.id, trace))(conn, message, payload)
+	})
 }
 
 func (b *basicUpdClient) Self() string {
@@ -66,22 +102,36 @@ func (b *basicUpdClient) Self() string {
 
 func (b *basicUpdClient) deliver(msg MessageWrap, conn *ServiceHostConn) {
 	conn.ServiceId = msg.Id
-	if d, ok := b.msgs[msg.code]; ok {
-		conn.Msg, _ = d(msg.buff)
+	m, err := b.recvChain(decodeWith(b.msgs, msg.code, b.id))(conn, msg.buff.Bytes())
+	if m != nil && err == nil {
+		b.service.opts.metrics.delivered(conn, b.id, msg)
+		conn.Msg = m
+		conn.ctx = recvSpan(b.service.opts.tracer, msg.trace, conn, b.id, m)
 		b.listenCh <- conn
-	} else {
-		log.Warn("Unable to deserialize message with code ", msg.code, " for protocol ", b.id, ": Unknown serializer")
+		return
+	}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		log.Warn("Unable to deserialize message with code ", msg.code, " for protocol ", b.id, ": ", decodeErr.Err)
+		e := connEvent(DecodeFailed, conn.Conn, b.id, decodeErr)
+		e.Code = msg.code
+		b.service.opts.events.publish(e)
+	} else if err != nil {
+		log.Warn("Dropping message with code ", msg.code, " for protocol ", b.id, ": ", err)
 	}
 }
 
-func createUpdClient(self *string, id string, net Net) *basicUpdClient {
+func createUpdClient(service *basicUdpService, self *string, id string, net Net) *basicUpdClient {
 	return &basicUpdClient{
-		self:     self,
-		id:       id,
-		net:      net,
-		listenCh: make(chan *ServiceHostConn),
-		buffered: make(map[string][]ReceivedMessage),
-		msgs:     make(map[uint16]MessageDeserializer),
+		service:      service,
+		self:         self,
+		id:           id,
+		net:          net,
+		listenCh:     make(chan *ServiceHostConn),
+		buffered:     make(map[string][]ReceivedMessage),
+		msgs:         newDeserializers(),
+		peerEvents:   newPeerEvents(),
+		interceptors: newInterceptors(),
 	}
 }
 
@@ -89,38 +139,80 @@ type basicUdpService struct {
 	self      string
 	net       Net
 	listeners map[string]*basicUpdClient
+	lock      *sync.RWMutex //Guards listeners, registered while datagrams are delivered
+	opts      *options
+	limiter   *limiter
 }
 
 func (b *basicUdpService) GetConfiguration() Configuration {
-	parts := strings.Split(b.self, ":")
-	port, _ := strconv.Atoi(parts[1])
-	return Configuration{
-		ip:   parts[0],
-		port: port,
-	}
+	return configurationFor(b.self)
+}
+
+func (b *basicUdpService) Close() error {
+	return b.net.CloseListener()
 }
 
 func (b *basicUdpService) RegisterListener(id string) NetClient {
-	client := createUpdClient(&b.self, id, b.net)
+	client := createUpdClient(b, &b.self, id, b.net)
+	b.lock.Lock()
+	defer b.lock.Unlock()
 	b.listeners[id] = client
 	return client
 }
 
+// UnregisterListener stops delivering datagrams to the NetClient id, they are dropped as sent to an unknown service.
+func (b *basicUdpService) UnregisterListener(id string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.listeners, id)
+}
+
+func (b *basicUdpService) listener(id string) (*basicUpdClient, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	c, ok := b.listeners[id]
+	return c, ok
+}
+
 func (b *basicUdpService) deliver(msg MessageWrap, conn *ServiceHostConn, err error) error {
 	if err != nil {
 		return err
 	}
-	if c, ok := b.listeners[conn.ServiceId]; ok {
+	if c, ok := b.listener(conn.ServiceId); ok {
+		if err := b.authorize(msg, conn); err != nil {
+			b.opts.stats.Unauthorized.Add(1)
+			b.opts.events.publish(connEvent(MessageDropped, conn.Conn, conn.ServiceId, ErrUnauthorized))
+			return errors.New(fmt.Sprintf("Dropping message from %v to %v: %v", conn.Conn, conn.ServiceId, err))
+		}
+		if b.limiter != nil && !b.limiter.allowService(conn.ServiceId) {
+			b.opts.events.publish(connEvent(MessageDropped, conn.Conn, conn.ServiceId, ErrRateLimited))
+			return errors.New(fmt.Sprintf("Dropping message from %v to %v: over the rate limit", conn.Conn, conn.ServiceId))
+		}
 		go c.deliver(msg, conn)
 		return nil
 	}
-	return errors.New(fmt.Sprintf("Listener with Id %v is not registered, entries: %v", conn.ServiceId, b.listeners))
+	b.opts.events.publish(connEvent(UnknownService, conn.Conn, conn.ServiceId, nil))
+	return errors.New(fmt.Sprintf("Listener with Id %v is not registered", conn.ServiceId))
+}
+
+func (b *basicUdpService) authorize(msg MessageWrap, conn *ServiceHostConn) error {
+	if b.opts.authorizer == nil {
+		return nil
+	}
+	return b.opts.authorizer.Authorize(AuthorizationRequest{
+		Addr:      conn.Addr(),
+		ServiceId: conn.ServiceId,
+		SenderId:  msg.Id,
+	})
 }
 
 // InitBaseUdpService creates a new basicUdpService
-func InitBaseUdpService(listenAddr string, buffsize int) NetService {
-	net := NewUdpNet(buffsize)
+// listenAddr is either an ip:port pair or a unix domain datagram socket address (unixgram:///path)
+func InitBaseUdpService(listenAddr string, buffsize int, opts ...Option) NetService {
+	o := newOptions(opts)
+	net := newPacketNet("udp", buffsize, o)
 	net.RegisterMessage(MessageWrap{})
+	net.RegisterMessage(tracedMessageWrap{})
 	listen, err := net.Listen(listenAddr)
 	if err != nil {
 		panic(err)
@@ -129,16 +221,24 @@ func InitBaseUdpService(listenAddr string, buffsize int) NetService {
 		self:      listenAddr,
 		net:       net,
 		listeners: make(map[string]*basicUpdClient),
+		lock:      &sync.RWMutex{},
+		opts:      o,
+		limiter:   newLimiter(o),
 	}
 	go func(listen <-chan HostConn, net Net, service *basicUdpService) {
 		for {
 			select {
-			case c := <-listen:
+			case c, ok := <-listen:
+				if !ok {
+					return
+				}
 				conn := &ServiceHostConn{Conn: c}
-				if msg, err := net.RecvFrom(conn); err != nil {
-					panic(err)
+				if msg, err := net.RecvFrom(conn); err == ErrDropped {
+					continue
+				} else if err != nil {
+					// a malformed datagram only concerns its sender
+					log.Warn("Dropping datagram from ", c.Addr(), ": ", err)
 				} else if err = service.deliver(msg.(MessageWrap), conn, err); err != nil {
-					//panic(err)
 					log.Warn(err)
 				}
 			}
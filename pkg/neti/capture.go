@@ -0,0 +1,239 @@
+package neti
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Direction is whether a captured frame was sent or received.
+type Direction string
+
+const (
+	Sent     Direction = "out"
+	Received Direction = "in"
+)
+
+// CapturedFrame is a frame sent or received by a Net, as written to capture files.
+// Capture files are in the JSON lines format, one CapturedFrame per line:
+//
+//	{"time":"2024-05-01T10:00:00.000000001Z","dir":"out","network":"tcp","local":"127.0.0.1:52000",
+//	 "remote":"127.0.0.1:10000","service":"client1","target":"client2","code":7,"frame":"AAAAB2NsaWVudDEAB..."}
+//
+// Frame is the base64 of the frame: the code of the Net message (u16) followed by its payload, which for services is
+// a MessageWrap (see the README for the layout). ServiceId and Code are those of the message wrapped by services
+// (or the code of the Net message), they are not set for the frames received that could not be deserialized.
+// Target is the id of the NetClient the frames of services are for, on the receiving end.
+type CapturedFrame struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Network   string    `json:"network"`
+	Local     string    `json:"local,omitempty"`
+	Remote    string    `json:"remote"`
+	ServiceId string    `json:"service,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Code      uint16    `json:"code"`
+	Frame     []byte    `json:"frame"`
+}
+
+// CaptureConfig configures the rolling of capture files.
+type CaptureConfig struct {
+	MaxSize  int64 //Size of a capture file before it is rolled, 64MB if not set
+	MaxFiles int   //Rolled files kept besides the current one (path.1 being the most recent), 3 if not set
+}
+
+// Capture writes the frames sent and received by the Nets and NetServices it is set on (see WithCapture)
+// to a rolling capture file. It can be enabled and disabled at any time, and is safe for concurrent use.
+// Frames are captured in plaintext, before they are encrypted (see WithEncryption), so capture files are
+// created readable by their owner only, and should be handled as the messages they hold.
+type Capture struct {
+	path    string
+	config  CaptureConfig
+	enabled *atomic.Bool
+	lock    *sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+// NewCapture creates an enabled Capture appending to the file at path.
+func NewCapture(path string, config CaptureConfig) (*Capture, error) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 64 << 20
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = 3
+	}
+	c := &Capture{path: path, config: config, enabled: &atomic.Bool{}, lock: &sync.Mutex{}}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	c.enabled.Store(true)
+	return c, nil
+}
+
+// WithCapture sets the Capture a Net or NetService writes its frames to, for every Net (tcp, unix, udp, unixgram, ws and quic).
+func WithCapture(capture *Capture) Option {
+	return func(o *options) {
+		o.capture = capture
+	}
+}
+
+// Enable resumes writing frames.
+func (c *Capture) Enable() {
+	c.enabled.Store(true)
+}
+
+// Disable stops writing frames, until enabled again.
+func (c *Capture) Disable() {
+	c.enabled.Store(false)
+}
+
+// Enabled returns whether frames are being written.
+func (c *Capture) Enabled() bool {
+	return c != nil && c.enabled.Load()
+}
+
+// Close disables the capture and closes the file.
+func (c *Capture) Close() error {
+	c.Disable()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+func (c *Capture) open() error {
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	c.file, c.size = file, info.Size()
+	return nil
+}
+
+// roll renames the capture files, path becoming path.1, path.1 becoming path.2, and so on, and opens a new one.
+func (c *Capture) roll() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(fmt.Sprintf("%v.%v", c.path, c.config.MaxFiles))
+	for i := c.config.MaxFiles - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%v.%v", c.path, i), fmt.Sprintf("%v.%v", c.path, i+1))
+	}
+	if err := os.Rename(c.path, c.path+".1"); err != nil {
+		return err
+	}
+	return c.open()
+}
+
+// record writes the frame sent or received on conn, message is the Net message deserialized from it, if any.
+func (c *Capture) record(dir Direction, conn HostConn, message Message, frame []byte) {
+	if !c.Enabled() {
+		return
+	}
+	f := CapturedFrame{
+		Time:      time.Now(),
+		Direction: dir,
+		Network:   conn.Addr().Network(),
+		Remote:    conn.Addr().String(),
+		Frame:     frame,
+	}
+	if local := localAddr(conn); local != nil {
+		f.Local = local.String()
+	}
+	if s, ok := conn.(*ServiceHostConn); ok {
+		if f.Target = s.ServiceId; dir == Received {
+			f.Target = s.target
+		}
+	}
+	if wrap, ok := message.(MessageWrap); ok {
+		f.ServiceId, f.Code = wrap.Id, wrap.MessageCode()
+	} else if message != nil {
+		f.Code = message.Code()
+	} else if len(frame) >= 2 {
+		f.Code = binary.BigEndian.Uint16(frame)
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		log.Warn("Unable to capture frame: ", err)
+		return
+	}
+	b = append(b, '\n')
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.file == nil {
+		return
+	}
+	if c.size > 0 && c.size+int64(len(b)) > c.config.MaxSize {
+		if err := c.roll(); err != nil {
+			log.Warn("Unable to roll capture file ", c.path, ": ", err)
+			return
+		}
+	}
+	n, err := c.file.Write(b)
+	c.size += int64(n)
+	if err != nil {
+		log.Warn("Unable to capture frame: ", err)
+	}
+}
+
+// localAddr returns the local address of conn, if known.
+func localAddr(conn HostConn) net.Addr {
+	if s, ok := conn.(*ServiceHostConn); ok {
+		conn = s.Conn
+	}
+	if l, ok := conn.(interface{ LocalAddr() net.Addr }); ok {
+		return l.LocalAddr()
+	}
+	return nil
+}
+
+// CaptureReader reads the frames of a capture file.
+type CaptureReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewCaptureReader creates a CaptureReader reading the capture file r.
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxDecompressedSize*2)
+	return &CaptureReader{scanner: scanner}
+}
+
+// Next returns the next frame, or io.EOF at the end of the file.
+func (r *CaptureReader) Next() (CapturedFrame, error) {
+	var f CapturedFrame
+	for r.scanner.Scan() {
+		r.line++
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(r.scanner.Bytes(), &f); err != nil {
+			return f, errors.New(fmt.Sprintf("Invalid capture at line %v: %v", r.line, err))
+		}
+		return f, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return f, err
+	}
+	return f, io.EOF
+}
@@ -0,0 +1,162 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readCapture returns the frames of the capture file at path.
+func readCapture(t *testing.T, path string) []CapturedFrame {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var frames []CapturedFrame
+	reader := NewCaptureReader(file)
+	for {
+		f, err := reader.Next()
+		if err == io.EOF {
+			return frames
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func TestCaptureTcp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture, err := NewCapture(path, CaptureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("created the capture file with %v, %v; expected it to be readable by its owner only", info.Mode(), err)
+	}
+	_, client, conn, accepted := tcpPair(t, nil, []Option{WithCapture(capture)})
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 1})
+	capture.Disable()
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 2})
+	capture.Enable()
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 3})
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := readCapture(t, path)
+	if len(frames) != 2 {
+		t.Fatalf("captured %v frames, expected the 2 sent while enabled", len(frames))
+	}
+	f := frames[0]
+	if f.Direction != Sent || f.Network != "tcp" || f.Code != 1 || f.Remote != accepted.(tcpHostConn).LocalAddr().String() ||
+		f.Local != conn.(tcpHostConn).LocalAddr().String() || string(f.Frame) != "\x00\x01\x00\x00\x00\x01" {
+		t.Fatalf("captured %+v, expected message 1 sent to the server", f)
+	}
+	if frames[1].Frame[5] != 3 {
+		t.Fatalf("captured %v, expected message 3", frames[1].Frame)
+	}
+}
+
+func TestCaptureServiceTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture, err := NewCapture(path, CaptureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	addr := freeAddr(t, "tcp")
+	echoNode(t, addr, func(seq uint32) uint32 { return seq }, WithCapture(capture))
+	peerService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer peerService.Close()
+	peer := peerService.RegisterListener("peer")
+	peer.RegisterMessage(registryMsg{code: 1})
+	conn, err := peer.OpenTo(addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = peer.SendTo(conn, registryMsg{code: 1, seq: 1})
+	testutil.Within(t, testTimeout, "the reply", func() { _, _ = peer.RecvFrom(conn) })
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := readCapture(t, path)
+	if len(frames) != 2 {
+		t.Fatalf("captured %v frames, expected the message and its reply", len(frames))
+	}
+	// the frames of services carry the id of their sender, the target is the NetClient they are for
+	if f := frames[0]; f.Direction != Received || f.ServiceId != "peer" || f.Target != "echo" {
+		t.Fatalf("captured %+v, expected the message of peer to echo", f)
+	}
+	if f := frames[1]; f.Direction != Sent || f.ServiceId != "echo" || f.Target != "peer" {
+		t.Fatalf("captured %+v, expected the reply of echo to peer", f)
+	}
+}
+
+func TestCaptureRolls(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture, err := NewCapture(path, CaptureConfig{MaxSize: 300, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+	conn := newChanConn()
+	for i := 0; i < 10; i++ {
+		capture.record(Received, conn, registryMsg{code: 1, seq: uint32(i)}, []byte{0, 1, 0, 0, 0, byte(i)})
+	}
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("kept a third rolled file (%v), expected 2", err)
+	}
+	// the most recent frames are in the current file, the oldest in the last rolled one, and the oldest ones are gone
+	var seqs []byte
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		frames := readCapture(t, p)
+		if len(frames) == 0 {
+			t.Fatalf("%v is empty", p)
+		}
+		for _, f := range frames {
+			seqs = append(seqs, f.Frame[5])
+		}
+		if info, _ := os.Stat(p); info.Size() > 300 {
+			t.Fatalf("%v has %v bytes, over the MaxSize", p, info.Size())
+		}
+	}
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Fatalf("captured %v, expected consecutive messages", seqs)
+		}
+	}
+	if seqs[len(seqs)-1] != 9 || seqs[0] == 0 {
+		t.Fatalf("captured %v, expected the oldest messages to be dropped", seqs)
+	}
+}
+
+func TestCaptureReader(t *testing.T) {
+	capture := `{"time":"2024-05-01T10:00:00Z","dir":"in","network":"udp","remote":"127.0.0.1:10000","service":"client1","code":7,"frame":"AAc="}
+
+{"time":"2024-05-01T10:00:01Z","dir":"out"
+`
+	reader := NewCaptureReader(strings.NewReader(capture))
+	f, err := reader.Next()
+	if err != nil || f.Direction != Received || f.ServiceId != "client1" || f.Code != 7 || string(f.Frame) != "\x00\x07" {
+		t.Fatalf("read %+v, %v", f, err)
+	}
+	// blank lines are skipped, and errors report the line
+	if _, err := reader.Next(); err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("reading the truncated frame failed with %v, expected an error at line 3", err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("reading past the end failed with %v, expected io.EOF", err)
+	}
+}
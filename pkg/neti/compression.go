@@ -0,0 +1,213 @@
+package neti
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Compression is an algorithm used to compress frames.
+type Compression uint8
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+	Snappy
+)
+
+const (
+	// compressedFrameFlag is set in the length header of compressed stream frames.
+	compressedFrameFlag uint32 = 1 << 31
+	// compressedCode is the message code reserved to mark compressed datagrams, datagram Nets do not register messages with it.
+	compressedCode uint16 = 0xFFFF
+	// maxDecompressedSize bounds the size of decompressed frames, so that small frames cannot exhaust memory.
+	maxDecompressedSize = 64 << 20
+)
+
+// errCompressionDisabled drops the compressed frames received without compression enabled.
+var errCompressionDisabled = errors.New("compressed frame received, but compression is not enabled")
+
+// String returns the name of the algorithm.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("compression %d", uint8(c))
+	}
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		e, _ := zstd.NewWriter(nil)
+		return e
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+		return d
+	})
+)
+
+func (c Compression) compress(b []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		buff := new(bytes.Buffer)
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(buff)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	case Zstd:
+		return zstdEncoder().EncodeAll(b, nil), nil
+	case Snappy:
+		return snappy.Encode(nil, b), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unable to compress with %v", c))
+	}
+}
+
+func (c Compression) decompress(b []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		plain, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(plain) > maxDecompressedSize {
+			return nil, errors.New("decompressed frame too large")
+		}
+		return plain, nil
+	case Zstd:
+		return zstdDecoder().DecodeAll(b, nil)
+	case Snappy:
+		if n, err := snappy.DecodedLen(b); err != nil {
+			return nil, err
+		} else if n > maxDecompressedSize {
+			return nil, errors.New("decompressed frame too large")
+		}
+		return snappy.Decode(nil, b)
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown compression %v", c))
+	}
+}
+
+// WithCompression compresses the frames of tcp, unix, udp and unixgram Nets that are at least threshold bytes long,
+// frames that do not shrink are sent uncompressed.
+// Algorithms are in order of preference: services pick, in the connection handshake, the first one also supported
+// by the peer, while Nets used directly and datagram services compress with the first one, so all nodes must support it.
+// Compressed frames are flagged, and only decoded by receivers with compression enabled, once authenticated
+// (see WithClusterKey); the message code 0xFFFF is reserved to flag datagrams, on datagram Nets.
+func WithCompression(threshold int, algorithms ...Compression) Option {
+	return func(o *options) {
+		o.compression = &compressionConfig{threshold: threshold, algorithms: algorithms}
+	}
+}
+
+type compressionConfig struct {
+	threshold  int
+	algorithms []Compression
+}
+
+// preferred returns the first of the algorithms.
+func (c *compressionConfig) preferred() Compression {
+	if c == nil || len(c.algorithms) == 0 {
+		return NoCompression
+	}
+	return c.algorithms[0]
+}
+
+// choose returns the first of the algorithms that is also in peer.
+func (c *compressionConfig) choose(peer []Compression) Compression {
+	for _, a := range c.algorithms {
+		for _, p := range peer {
+			if a == p {
+				return a
+			}
+		}
+	}
+	return NoCompression
+}
+
+// frameCompressor compresses the frames sent on a connection.
+type frameCompressor struct {
+	config    *compressionConfig
+	algorithm *atomic.Uint32
+}
+
+func newFrameCompressor(config *compressionConfig) *frameCompressor {
+	if config == nil {
+		return nil
+	}
+	f := &frameCompressor{config: config, algorithm: &atomic.Uint32{}}
+	f.algorithm.Store(uint32(config.preferred()))
+	return f
+}
+
+// compress returns the algorithm and the compressed frame, or NoCompression if the frame should be sent as is.
+func (f *frameCompressor) compress(b []byte) (Compression, []byte) {
+	if f == nil || len(b) < f.config.threshold {
+		return NoCompression, nil
+	}
+	c := Compression(f.algorithm.Load())
+	if c == NoCompression {
+		return NoCompression, nil
+	}
+	compressed, err := c.compress(b)
+	if err != nil || len(compressed)+1 >= len(b) {
+		return NoCompression, nil
+	}
+	return c, compressed
+}
+
+// compressedConn is a HostConn able to compress its frames, with the algorithm negotiated by services.
+type compressedConn interface {
+	compressions() []Compression //The algorithms supported, nil if compression is disabled
+	setCompression(c Compression)
+}
+
+// encodeCompressions and decodeCompressions (de)serialize a list of algorithms.
+func encodeCompressions(algorithms []Compression, buff *bytes.Buffer) {
+	buff.WriteByte(uint8(len(algorithms)))
+	for _, a := range algorithms {
+		buff.WriteByte(uint8(a))
+	}
+}
+
+func decodeCompressions(buff *bytes.Buffer) ([]Compression, error) {
+	n, err := buff.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	algorithms := make([]Compression, 0, n)
+	for i := 0; i < int(n); i++ {
+		a, err := buff.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, Compression(a))
+	}
+	return algorithms, nil
+}
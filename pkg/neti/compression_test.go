@@ -0,0 +1,197 @@
+package neti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+)
+
+// textMsg is a message with a compressible payload.
+type textMsg struct {
+	text string
+}
+
+func (m textMsg) String() string {
+	return fmt.Sprintf("%v{%v bytes}", m.Name(), len(m.text))
+}
+
+func (m textMsg) Name() string {
+	return "textMsg"
+}
+
+func (m textMsg) Code() uint16 {
+	return 2
+}
+
+func (m textMsg) Serialize(buff *bytes.Buffer) error {
+	_, err := buff.WriteString(m.text)
+	return err
+}
+
+func (m textMsg) Deserialize(buff *bytes.Buffer) (Message, error) {
+	return textMsg{text: buff.String()}, nil
+}
+
+var compressible = textMsg{text: strings.Repeat("compressible ", 100)}
+
+func TestCompressions(t *testing.T) {
+	for _, c := range []Compression{Gzip, Zstd, Snappy} {
+		t.Run(c.String(), func(t *testing.T) {
+			compressed, err := c.compress([]byte(compressible.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) >= len(compressible.text) {
+				t.Fatalf("compressed %v bytes into %v", len(compressible.text), len(compressed))
+			}
+			if b, err := c.decompress(compressed); err != nil || string(b) != compressible.text {
+				t.Fatalf("decompressed %q, %v", b, err)
+			}
+		})
+	}
+}
+
+func TestCompressedCodeIsReserved(t *testing.T) {
+	udpNet := NewUdpNet(1024).(*udp)
+	udpNet.RegisterMessage(registryMsg{code: compressedCode})
+	if _, ok := udpNet.msgDeserializers.lookup(compressedCode); ok {
+		t.Fatal("udp registered a message with the reserved code")
+	}
+	// stream Nets flag compressed frames in their length header, the code is theirs to use
+	tcpNet := NewTcpNet(log.StandardLogger()).(*tcp)
+	tcpNet.RegisterMessage(registryMsg{code: compressedCode})
+	if _, ok := tcpNet.msgDeserializers.lookup(compressedCode); !ok {
+		t.Fatal("tcp did not register a message with the code 0xFFFF")
+	}
+}
+
+// tcpPair returns a server and a client tcp Net, the connection of the client and the one accepted by the server.
+func tcpPair(t *testing.T, serverOpts []Option, clientOpts []Option) (Net, Net, HostConn, HostConn) {
+	t.Helper()
+	server := NewTcpNet(log.StandardLogger(), serverOpts...)
+	server.RegisterMessage(registryMsg{code: 1})
+	server.RegisterMessage(textMsg{})
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.CloseListener() })
+	client := NewTcpNet(log.StandardLogger(), clientOpts...)
+	conn, err := client.Open(server.(*tcp).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+	return server, client, conn, accepted
+}
+
+func TestTcpCompressionWithClusterKey(t *testing.T) {
+	key := WithClusterKey(testClusterKey)
+	server, client, conn, accepted := tcpPair(t, []Option{key, WithCompression(0, Zstd)}, []Option{key, WithCompression(0, Zstd)})
+	if err := client.SendTo(conn, compressible); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Within(t, testTimeout, "the compressed message", func() {
+		if m, err := server.RecvFrom(accepted); err != nil || m != Message(compressible) {
+			t.Errorf("received %v, %v; expected the compressible message", m, err)
+		}
+	})
+}
+
+func TestTcpCompressedFramesAreAuthenticatedFirst(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	key := WithClusterKey(testClusterKey)
+	server, client, conn, accepted := tcpPair(t, []Option{key, WithCompression(0, Gzip), WithEvents(bus)}, []Option{key})
+	// a forged frame flagged as compressed, without a valid mac
+	forged := binary.BigEndian.AppendUint32(nil, uint32(4)|compressedFrameFlag)
+	forged = append(forged, uint8(Gzip), 1, 2, 3)
+	if _, err := conn.(tcpHostConn).conn.Write(forged); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 1})
+	testutil.Within(t, testTimeout, "the authentic message", func() {
+		if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+			t.Errorf("received %v, %v; expected message 1", m, err)
+		}
+	})
+	testutil.Within(t, testTimeout, "the forged frame to be dropped", func() {
+		for e := range events {
+			if e.Type == MessageDropped {
+				if !errors.Is(e.Err, ErrUnauthenticated) {
+					t.Errorf("dropped the forged frame with %v, expected ErrUnauthenticated", e.Err)
+				}
+				return
+			}
+		}
+	})
+}
+
+func TestTcpCompressedFramesWithoutCompression(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	server, client, conn, accepted := tcpPair(t, []Option{WithEvents(bus)}, []Option{WithCompression(0, Snappy)})
+	_ = client.SendTo(conn, compressible)
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 1})
+	testutil.Within(t, testTimeout, "the uncompressed message", func() {
+		if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+			t.Errorf("received %v, %v; expected message 1", m, err)
+		}
+	})
+	testutil.Within(t, testTimeout, "the compressed frame to be dropped", func() {
+		for e := range events {
+			if e.Type == MessageDropped {
+				if !errors.Is(e.Err, errCompressionDisabled) {
+					t.Errorf("dropped the compressed frame with %v, expected errCompressionDisabled", e.Err)
+				}
+				return
+			}
+		}
+	})
+}
+
+func TestUdpCompression(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		opts       []Option
+		compressed bool
+	}{
+		{"Enabled", []Option{WithCompression(0, Gzip)}, true},
+		{"Disabled", nil, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			receiver, datagrams := udpLimited(t, test.opts...)
+			receiver.RegisterMessage(textMsg{})
+			sender := NewUdpNet(2048, WithCompression(0, Gzip))
+			if _, err := sender.Listen("127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			defer sender.CloseListener()
+			conn, err := sender.Open(receiver.(*udp).conn.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sender.SendTo(conn, compressible); err != nil {
+				t.Fatal(err)
+			}
+			testutil.Within(t, testTimeout, "the compressed datagram", func() {
+				m, err := receiver.RecvFrom(<-datagrams)
+				if test.compressed && (err != nil || m != Message(compressible)) {
+					t.Errorf("received %v, %v; expected the compressible message", m, err)
+				} else if !test.compressed && err == nil {
+					// the reserved code is not registered, so the datagram is not decompressed
+					t.Errorf("received %v from a compressed datagram, without compression", m)
+				}
+			})
+		})
+	}
+}
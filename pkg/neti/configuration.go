@@ -5,6 +5,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"net"
+	"net/url"
+	"strconv"
 )
 
 // Configuration is the configuration for the network
@@ -12,6 +15,7 @@ type Configuration struct {
 	iface string
 	ip    string
 	port  int
+	addr  string //full address when it is not an ip:port pair (unix sockets, urls)
 
 	buffSize int
 
@@ -23,6 +27,29 @@ func (c Configuration) String() string {
 	return fmt.Sprintf("{iface: %v, ip: %v, port: %v, buffsize: %v}", c.iface, c.ip, c.port, c.buffSize)
 }
 
+// configurationFor returns the configuration of a service listening on addr,
+// either an ip:port pair, a unix socket address (unix:///path, unixgram:///path) or a url (ws://host:port/path)
+func configurationFor(addr string) Configuration {
+	switch network, hostport := splitNetworkAddr(addr, ""); network {
+	case "":
+		host, p, _ := net.SplitHostPort(hostport)
+		port, _ := strconv.Atoi(p)
+		return Configuration{
+			ip:   host,
+			port: port,
+		}
+	case "unix", "unixgram":
+		return Configuration{addr: addr}
+	default:
+		c := Configuration{addr: addr}
+		if u, err := url.Parse(addr); err == nil {
+			c.ip = u.Hostname()
+			c.port, _ = strconv.Atoi(u.Port())
+		}
+		return c
+	}
+}
+
 // SetPFlags sets the pflags for the configuration
 func SetPFlags() {
 	pflag.String("net.ip", "", "IP address to bind")
@@ -60,6 +87,9 @@ func LoadConfiguration(viper *viper.Viper) Configuration {
 
 // Address returns the addresses for the configuration
 func (c Configuration) Address() string {
+	if c.addr != "" {
+		return c.addr
+	}
 	return fmt.Sprintf("%s:%d", c.ip, c.port)
 }
 
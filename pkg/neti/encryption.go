@@ -0,0 +1,321 @@
+package neti
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cipher is an AEAD algorithm used to encrypt datagrams.
+type Cipher uint8
+
+const (
+	ChaCha20Poly1305 Cipher = iota + 1
+	AESGCM
+)
+
+// String returns the name of the cipher.
+func (c Cipher) String() string {
+	switch c {
+	case ChaCha20Poly1305:
+		return "chacha20-poly1305"
+	case AESGCM:
+		return "aes-256-gcm"
+	default:
+		return fmt.Sprintf("cipher %d", uint8(c))
+	}
+}
+
+// derive derives the 32 bytes key of info from secret with HKDF-SHA256.
+func derive(secret []byte, salt []byte, info []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (c Cipher) aead(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown cipher %v", c))
+	}
+}
+
+type ringKey struct {
+	key     []byte    //key of the ring the keys of senders are derived from
+	expires time.Time //zero if the key does not expire
+}
+
+// Keyring holds the keys used to encrypt datagrams, identified by a byte sent along each datagram.
+// Datagrams are encrypted with the primary key and decrypted with the key they name, so keys can be rotated
+// without dropping traffic: Rotate to a new key on every node, and the previous one keeps being accepted
+// for the rollover window.
+// Keys are derived from secrets with HKDF-SHA256, and every sender encrypts with its own key derived from them.
+// A Keyring is safe for concurrent use.
+type Keyring struct {
+	cipher  Cipher
+	lock    *sync.RWMutex
+	keys    map[uint8]*ringKey
+	primary uint8
+}
+
+// NewKeyring creates a Keyring with a single key, derived from secret, as primary key.
+func NewKeyring(c Cipher, id uint8, secret []byte) (*Keyring, error) {
+	k := &Keyring{
+		cipher: c,
+		lock:   &sync.RWMutex{},
+		keys:   make(map[uint8]*ringKey),
+	}
+	if err := k.Add(id, secret); err != nil {
+		return nil, err
+	}
+	k.primary = id
+	return k, nil
+}
+
+// Add adds a key derived from secret, accepted for decryption but not used for encryption until it is the primary key.
+func (k *Keyring) Add(id uint8, secret []byte) error {
+	key, err := derive(secret, nil, []byte{'n', 'e', 't', 'i', id})
+	if err != nil {
+		return err
+	}
+	// the cipher is checked once, instead of on every datagram
+	if _, err := k.cipher.aead(key); err != nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys[id] = &ringKey{key: key}
+	return nil
+}
+
+// SetPrimary sets the key used for encryption.
+func (k *Keyring) SetPrimary(id uint8) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return errors.New(fmt.Sprintf("unknown key %v", id))
+	}
+	k.primary = id
+	return nil
+}
+
+// Rotate adds a key derived from secret and makes it the primary key,
+// the previous primary key is still accepted for decryption during window.
+func (k *Keyring) Rotate(id uint8, secret []byte, window time.Duration) error {
+	if err := k.Add(id, secret); err != nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if id != k.primary {
+		k.keys[k.primary].expires = time.Now().Add(window)
+	}
+	k.primary = id
+	return nil
+}
+
+// Remove removes a key, the primary key cannot be removed.
+func (k *Keyring) Remove(id uint8) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if id == k.primary {
+		return errors.New("unable to remove the primary key")
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *Keyring) primaryKey() (uint8, []byte) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.primary, k.keys[k.primary].key
+}
+
+func (k *Keyring) key(id uint8) ([]byte, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	key, ok := k.keys[id]
+	if !ok || (!key.expires.IsZero() && time.Now().After(key.expires)) {
+		return nil, false
+	}
+	return key.key, true
+}
+
+// senderAead returns the AEAD of the key of sender derived from the key of the ring.
+func (k *Keyring) senderAead(key []byte, sender []byte) (cipher.AEAD, error) {
+	senderKey, err := derive(key, sender, []byte("neti datagram"))
+	if err != nil {
+		return nil, err
+	}
+	return k.cipher.aead(senderKey)
+}
+
+// WithEncryption encrypts the datagrams of datagram Nets (udp, unixgram) and the UDP service with the keys of keyring.
+// All nodes must share the same secrets.
+func WithEncryption(keyring *Keyring) Option {
+	return func(o *options) {
+		o.keyring = keyring
+	}
+}
+
+// datagramCipher encrypts datagrams as: key id | sender id | counter | time sent | ciphertext.
+// Senders have a random id, and encrypt with a key derived from the key of the ring and their id, so that the nonces,
+// the counter of the sender, are never reused with the same key even though all nodes share the keyring.
+// Receivers drop datagrams older than datagramMaxAge, so that they cannot be replayed once the windows are lost
+// on restart, and keep a window of the counters received from each sender to drop the datagrams replayed until then.
+type datagramCipher struct {
+	keyring  *Keyring
+	sender   []byte
+	counter  *atomic.Uint64
+	lock     *sync.Mutex
+	sendKey  uint8                  //id of the ring key sendAead was derived from, guarded by lock
+	sendRing []byte                 //ring key sendAead was derived from, guarded by lock
+	sendAead cipher.AEAD            //AEAD of the key of this sender, guarded by lock
+	aeads    map[string]*senderAead //AEADs of the senders received from, by key id and sender id, guarded by lock
+	windows  *replayWindows
+	stats    *Stats
+}
+
+// senderAead is the AEAD of a sender derived from a key of the ring.
+type senderAead struct {
+	key  []byte //key of the ring it was derived from, the id of a key may be reused for another one
+	aead cipher.AEAD
+}
+
+const (
+	cipherSenderSize = 16
+	cipherNonceSize  = 12
+	// cipherHeaderSize is the size of the key id, sender id, counter and time sent preceding the ciphertext.
+	cipherHeaderSize = 1 + cipherSenderSize + 8 + 8
+)
+
+func newDatagramCipher(o *options) *datagramCipher {
+	if o.keyring == nil {
+		return nil
+	}
+	return &datagramCipher{
+		keyring: o.keyring,
+		sender:  nonce(cipherSenderSize),
+		counter: &atomic.Uint64{},
+		lock:    &sync.Mutex{},
+		aeads:   make(map[string]*senderAead),
+		windows: newReplayWindows(datagramMaxAge),
+		stats:   o.stats,
+	}
+}
+
+// aead returns the key id and the AEAD this sender encrypts with, derived again when the primary key changes.
+func (d *datagramCipher) aead() (uint8, cipher.AEAD) {
+	id, key := d.keyring.primaryKey()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.sendAead == nil || d.sendKey != id || !bytes.Equal(d.sendRing, key) {
+		aead, err := d.keyring.senderAead(key, d.sender)
+		if err != nil {
+			// the cipher of the keyring was checked when its keys were added
+			panic(err)
+		}
+		d.sendKey, d.sendRing, d.sendAead = id, key, aead
+	}
+	return d.sendKey, d.sendAead
+}
+
+// cached returns the AEAD of sender derived from the key id of the ring, if it was derived before.
+func (d *datagramCipher) cached(id uint8, key []byte, sender []byte) (cipher.AEAD, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if cached, ok := d.aeads[string(append([]byte{id}, sender...))]; ok && bytes.Equal(cached.key, key) {
+		return cached.aead, true
+	}
+	return nil, false
+}
+
+// cache keeps the AEAD of sender derived from the key id of the ring, once a datagram of sender was authenticated
+// so that forged senders do not evict the others. It is bounded as the replay windows are.
+func (d *datagramCipher) cache(id uint8, key []byte, sender []byte, aead cipher.AEAD) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.aeads) >= maxReplayWindows {
+		clear(d.aeads)
+	}
+	d.aeads[string(append([]byte{id}, sender...))] = &senderAead{key: key, aead: aead}
+}
+
+func (d *datagramCipher) seal(b []byte) []byte {
+	return d.sealAt(b, time.Now())
+}
+
+func (d *datagramCipher) sealAt(b []byte, sent time.Time) []byte {
+	id, aead := d.aead()
+	counter := d.counter.Add(1)
+	sealed := make([]byte, 0, cipherHeaderSize+len(b)+aead.Overhead())
+	sealed = append(sealed, id)
+	sealed = append(sealed, d.sender...)
+	sealed = binary.BigEndian.AppendUint64(sealed, counter)
+	sealed = binary.BigEndian.AppendUint64(sealed, uint64(sent.UnixNano()))
+	return aead.Seal(sealed, counterNonce(counter), b, sealed)
+}
+
+// open returns the decrypted datagram, if it is authentic, recent and was not received before.
+func (d *datagramCipher) open(b []byte) ([]byte, bool) {
+	if len(b) < cipherHeaderSize {
+		d.stats.UndecryptableFrames.Add(1)
+		return nil, false
+	}
+	key, ok := d.keyring.key(b[0])
+	if !ok {
+		d.stats.UndecryptableFrames.Add(1)
+		return nil, false
+	}
+	header := b[:cipherHeaderSize]
+	sender := header[1 : 1+cipherSenderSize]
+	counter := binary.BigEndian.Uint64(header[1+cipherSenderSize:])
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(header[1+cipherSenderSize+8:])))
+	aead, cached := d.cached(b[0], key, sender)
+	if !cached {
+		var err error
+		if aead, err = d.keyring.senderAead(key, sender); err != nil {
+			d.stats.UndecryptableFrames.Add(1)
+			return nil, false
+		}
+	}
+	plain, err := aead.Open(nil, counterNonce(counter), b[cipherHeaderSize:], header)
+	if err != nil {
+		d.stats.UndecryptableFrames.Add(1)
+		return nil, false
+	}
+	if !cached {
+		d.cache(b[0], key, sender, aead)
+	}
+	now := time.Now()
+	if now.Sub(sent) > datagramMaxAge || sent.Sub(now) > datagramMaxAge || !d.windows.accept(string(sender), counter) {
+		d.stats.ReplayedFrames.Add(1)
+		return nil, false
+	}
+	return plain, true
+}
+
+// counterNonce returns the nonce of a counter, which is unique for the key of a sender.
+func counterNonce(counter uint64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, cipherNonceSize-8, cipherNonceSize), counter)
+}
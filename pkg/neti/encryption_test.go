@@ -0,0 +1,143 @@
+package neti
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, c Cipher) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(c, 1, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestDatagramCipher(t *testing.T) {
+	for _, c := range []Cipher{ChaCha20Poly1305, AESGCM} {
+		t.Run(c.String(), func(t *testing.T) {
+			stats := &Stats{}
+			keyring := testKeyring(t, c)
+			sender := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+			receiver := newDatagramCipher(newOptions([]Option{WithEncryption(keyring), WithStats(stats)}))
+
+			sealed := sender.seal([]byte("datagram"))
+			if bytes.Contains(sealed, []byte("datagram")) {
+				t.Fatal("the datagram is sent in the clear")
+			}
+			if b, ok := receiver.open(sealed); !ok || string(b) != "datagram" {
+				t.Fatalf("opened %q, %v", b, ok)
+			}
+			if _, ok := receiver.open(sealed); ok {
+				t.Fatal("opened a replayed datagram")
+			}
+			// tampering with the key id, the sender id, the counter, the time sent or the ciphertext
+			for _, i := range []int{0, 1, 1 + cipherSenderSize, 1 + cipherSenderSize + 8, cipherHeaderSize} {
+				tampered := sender.seal([]byte("datagram"))
+				tampered[i] ^= 1
+				if _, ok := receiver.open(tampered); ok {
+					t.Fatalf("opened a datagram tampered at byte %v", i)
+				}
+			}
+			if _, ok := receiver.open(sealed[:cipherHeaderSize-1]); ok {
+				t.Fatal("opened a truncated datagram")
+			}
+			if stats.ReplayedFrames.Load() != 1 || stats.UndecryptableFrames.Load() != 6 {
+				t.Fatalf("%v replayed and %v undecryptable datagrams, expected 1 and 6",
+					stats.ReplayedFrames.Load(), stats.UndecryptableFrames.Load())
+			}
+		})
+	}
+}
+
+func TestDatagramCipherSenders(t *testing.T) {
+	keyring := testKeyring(t, AESGCM)
+	a := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	b := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	receiver := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+
+	// both senders use counter 1, under keys of their own
+	fromA, fromB := a.seal([]byte("datagram")), b.seal([]byte("datagram"))
+	if bytes.Equal(fromA[cipherHeaderSize:], fromB[cipherHeaderSize:]) {
+		t.Fatal("two senders encrypted the same datagram with the same key and nonce")
+	}
+	if _, ok := receiver.open(fromA); !ok {
+		t.Fatal("dropped the datagram of the first sender")
+	}
+	if _, ok := receiver.open(fromB); !ok {
+		t.Fatal("dropped the datagram of the second sender with the same counter")
+	}
+}
+
+func TestDatagramCipherRotation(t *testing.T) {
+	senderRing, receiverRing := testKeyring(t, ChaCha20Poly1305), testKeyring(t, ChaCha20Poly1305)
+	sender := newDatagramCipher(newOptions([]Option{WithEncryption(senderRing)}))
+	receiver := newDatagramCipher(newOptions([]Option{WithEncryption(receiverRing)}))
+
+	old := sender.seal([]byte("old"))
+	if err := senderRing.Rotate(2, []byte("new secret"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	rotated := sender.seal([]byte("new"))
+	if rotated[0] != 2 {
+		t.Fatalf("encrypted with key %v after rotating to key 2", rotated[0])
+	}
+	if _, ok := receiver.open(rotated); ok {
+		t.Fatal("opened a datagram encrypted with a key the receiver does not have")
+	}
+	if err := receiverRing.Rotate(2, []byte("new secret"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := receiver.open(rotated); !ok {
+		t.Fatal("dropped a datagram encrypted with the new key")
+	}
+	if _, ok := receiver.open(old); ok {
+		t.Fatal("opened a datagram encrypted with a key past its rollover window")
+	}
+}
+
+func TestDatagramCipherMaxAge(t *testing.T) {
+	keyring := testKeyring(t, ChaCha20Poly1305)
+	sender := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	receiver := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	captured := sender.seal([]byte("datagram"))
+	if _, ok := receiver.open(append([]byte(nil), captured...)); !ok {
+		t.Fatal("dropped a recent datagram")
+	}
+	// a receiver that restarted has lost its windows, but not the datagrams older than datagramMaxAge
+	restarted := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	if _, ok := restarted.open(sender.sealAt([]byte("datagram"), time.Now().Add(-2*datagramMaxAge))); ok {
+		t.Fatal("opened a datagram older than datagramMaxAge")
+	}
+	if _, ok := restarted.open(sender.sealAt([]byte("datagram"), time.Now().Add(2*datagramMaxAge))); ok {
+		t.Fatal("opened a datagram sent in the future")
+	}
+}
+
+func TestDatagramCipherCachesSenders(t *testing.T) {
+	keyring := testKeyring(t, AESGCM)
+	sender := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	receiver := newDatagramCipher(newOptions([]Option{WithEncryption(keyring)}))
+	forged := sender.seal([]byte("datagram"))
+	forged[len(forged)-1] ^= 1
+	if _, ok := receiver.open(forged); ok || len(receiver.aeads) != 0 {
+		t.Fatalf("cached %v senders of a forged datagram", len(receiver.aeads))
+	}
+	for i := 0; i < 3; i++ {
+		if _, ok := receiver.open(sender.seal([]byte("datagram"))); !ok {
+			t.Fatalf("dropped datagram %v", i)
+		}
+	}
+	if len(receiver.aeads) != 1 {
+		t.Fatalf("cached %v senders, expected the AEAD of the sender to be derived once", len(receiver.aeads))
+	}
+	// a key id reused for another key is not opened with the cached AEAD
+	if err := keyring.Rotate(1, []byte("another secret"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := receiver.open(sender.seal([]byte("datagram"))); !ok {
+		t.Fatal("dropped a datagram encrypted with the new key 1")
+	}
+}
@@ -0,0 +1,162 @@
+package neti
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType uint8
+
+const (
+	ListenerStarted    EventType = iota + 1 //A Net started listening on Local
+	ListenerStopped                         //A Net stopped listening on Local
+	ConnectionAccepted                      //A connection from Remote was accepted
+	ConnectionOpened                        //A connection to Remote was opened
+	ConnectionClosed                        //A connection with Remote was closed, Err is why (nil when closed locally)
+	HandshakeFailed                         //The authentication or service handshake with Remote failed
+	UnknownService                          //A connection or datagram from Remote was for ServiceId, which is not registered
+	MessageDropped                          //A frame or message from Remote was dropped, Err is why
+	DecodeFailed                            //A message from Remote could not be deserialized
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case ListenerStarted:
+		return "listener started"
+	case ListenerStopped:
+		return "listener stopped"
+	case ConnectionAccepted:
+		return "connection accepted"
+	case ConnectionOpened:
+		return "connection opened"
+	case ConnectionClosed:
+		return "connection closed"
+	case HandshakeFailed:
+		return "handshake failed"
+	case UnknownService:
+		return "unknown service"
+	case MessageDropped:
+		return "message dropped"
+	case DecodeFailed:
+		return "decode failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Errors of the ConnectionClosed and MessageDropped events.
+var (
+	ErrConnectionLimit = errors.New("connection limit reached")
+	ErrRateLimited     = errors.New("message rate limit exceeded")
+	ErrUnauthenticated = errors.New("frame failed authentication")
+	ErrUndecryptable   = errors.New("datagram could not be decrypted")
+	ErrUnauthorized    = errors.New("rejected by the authorizer")
+)
+
+// Event is something that happened in a Net or NetService.
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	Network   string   //Network of the Net (tcp, udp, unix, ...)
+	Local     net.Addr //Local address, for listener events
+	Remote    net.Addr //Address of the peer, for connection and message events
+	ServiceId string   //Id of the listener, for service events
+	Code      uint16   //Code of the message, for DecodeFailed
+	Err       error
+}
+
+// EventBus publishes the Events of the Nets and NetServices it is set on (see WithEvents) to its subscribers.
+// It is safe for concurrent use, and publishing to a bus without subscribers is cheap.
+type EventBus struct {
+	lock        *sync.RWMutex
+	next        int
+	subscribers map[int]func(Event)
+}
+
+// NewEventBus creates an EventBus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{
+		lock:        &sync.RWMutex{},
+		subscribers: make(map[int]func(Event)),
+	}
+}
+
+// WithEvents sets the EventBus a Net or NetService publishes its events to.
+func WithEvents(bus *EventBus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// Subscribe returns a channel receiving the events, and the function cancelling the subscription.
+// Events are dropped when the channel is full, so that a slow subscriber does not slow down the network.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	lock := &sync.Mutex{}
+	closed := false
+	cancel := b.SubscribeFunc(func(e Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+	return ch, func() {
+		cancel()
+		lock.Lock()
+		defer lock.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+}
+
+// SubscribeFunc calls f, synchronously, on every event, and returns the function cancelling the subscription.
+// f must not block, as it is called by the network goroutines.
+func (b *EventBus) SubscribeFunc(f func(Event)) func() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = f
+	return func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// publish sends e to the subscribers, it can be called on a nil bus.
+func (b *EventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.lock.RLock()
+	if len(b.subscribers) == 0 {
+		b.lock.RUnlock()
+		return
+	}
+	subscribers := make([]func(Event), 0, len(b.subscribers))
+	for _, f := range b.subscribers {
+		subscribers = append(subscribers, f)
+	}
+	b.lock.RUnlock()
+	e.Time = time.Now()
+	for _, f := range subscribers {
+		f(e)
+	}
+}
+
+// connEvent returns the event of a connection, or a message received on it, for the listener serviceId.
+func connEvent(t EventType, conn HostConn, serviceId string, err error) Event {
+	return Event{Type: t, Network: conn.Addr().Network(), Remote: conn.Addr(), ServiceId: serviceId, Err: err}
+}
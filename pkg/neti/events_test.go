@@ -0,0 +1,127 @@
+package neti
+
+import (
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+func TestEventBusSubscribe(t *testing.T) {
+	var nilBus *EventBus
+	nilBus.publish(Event{Type: ListenerStarted})
+
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(1)
+	bus.publish(Event{Type: ListenerStarted})
+	bus.publish(Event{Type: ListenerStopped})
+	if e := <-events; e.Type != ListenerStarted || e.Time.IsZero() {
+		t.Fatalf("received %+v, expected the first event with its time", e)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("received %+v, expected the event over the buffer to be dropped", e)
+	default:
+	}
+	cancel()
+	cancel()
+	bus.publish(Event{Type: ListenerStarted})
+	if _, ok := <-events; ok {
+		t.Fatal("received an event after cancelling the subscription")
+	}
+}
+
+func TestEventBusSubscribeFunc(t *testing.T) {
+	bus := NewEventBus()
+	var first, second []EventType
+	cancel := bus.SubscribeFunc(func(e Event) { first = append(first, e.Type) })
+	defer bus.SubscribeFunc(func(e Event) { second = append(second, e.Type) })()
+	bus.publish(Event{Type: ConnectionOpened})
+	cancel()
+	bus.publish(Event{Type: ConnectionClosed})
+	if len(first) != 1 || first[0] != ConnectionOpened {
+		t.Fatalf("the cancelled subscriber received %v, expected the event before cancelling", first)
+	}
+	if len(second) != 2 {
+		t.Fatalf("the subscriber received %v, expected both events", second)
+	}
+}
+
+// expectEvents receives events until it received the given types, in order, returning the events of these types.
+func expectEvents(t *testing.T, events <-chan Event, types ...EventType) []Event {
+	t.Helper()
+	var received []Event
+	testutil.Within(t, testTimeout, fmt.Sprint("the events ", types), func() {
+		for e := range events {
+			if e.Type == types[len(received)] {
+				received = append(received, e)
+				if len(received) == len(types) {
+					return
+				}
+			}
+		}
+	})
+	return received
+}
+
+func TestTcpEvents(t *testing.T) {
+	serverBus, clientBus := NewEventBus(), NewEventBus()
+	serverEvents, cancelServer := serverBus.Subscribe(16)
+	defer cancelServer()
+	clientEvents, cancelClient := clientBus.Subscribe(16)
+	defer cancelClient()
+
+	server := NewTcpNet(log.StandardLogger(), WithEvents(serverBus))
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := server.(*tcp).listener.Addr()
+	client := NewTcpNet(log.StandardLogger(), WithEvents(clientBus))
+	conn, err := client.Open(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+	_ = client.SendTo(conn, registryMsg{code: 7})
+	if _, err := server.RecvFrom(accepted); err == nil {
+		t.Fatal("received a message with an unregistered code")
+	}
+	_ = conn.Close()
+	_, _ = server.RecvFrom(accepted)
+	_ = server.CloseListener()
+
+	received := expectEvents(t, serverEvents, ListenerStarted, ConnectionAccepted, DecodeFailed, ConnectionClosed, ListenerStopped)
+	if received[0].Local.String() != addr.String() || received[0].Network != "tcp" {
+		t.Fatalf("%+v, expected the listener on %v", received[0], addr)
+	}
+	if received[2].Code != 7 || received[2].Remote.String() != conn.(tcpHostConn).LocalAddr().String() {
+		t.Fatalf("%+v, expected the unknown code 7 from the client", received[2])
+	}
+	if received[3].Err == nil {
+		t.Fatalf("%+v, expected the error closing the connection of the client", received[3])
+	}
+	received = expectEvents(t, clientEvents, ConnectionOpened, ConnectionClosed)
+	if received[0].Remote.String() != addr.String() || received[1].Err != nil {
+		t.Fatalf("%+v, expected the connection to %v, closed locally", received, addr)
+	}
+}
+
+func TestServiceEvents(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger(), WithEvents(bus))
+	defer service.Close()
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+
+	if _, err := senderService.RegisterListener("sender").OpenTo(addr, "missing"); err == nil {
+		t.Fatal("opened a connection to a missing service")
+	}
+	if e := expectEvents(t, events, UnknownService)[0]; e.ServiceId != "missing" || e.Err == nil {
+		t.Fatalf("%+v, expected the rejection of the service missing", e)
+	}
+}
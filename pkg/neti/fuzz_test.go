@@ -0,0 +1,245 @@
+package neti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// The fuzz targets decode arbitrary bytes on every path a peer can reach, they must fail with errors and never panic.
+// The seed corpus is made of valid frames, run them for longer with e.g.
+//
+//	go test ./pkg/neti -run '^$' -fuzz FuzzTcpReceive -fuzztime 1m
+
+var fuzzTrace = SpanContext{TraceID: [16]byte{1, 2, 3}, SpanID: [8]byte{4, 5, 6}, Flags: 1}
+
+// fuzzWraps returns the frames of MessageWraps, with and without a span context, without the code of the frame.
+func fuzzWraps(t testing.TB) [][]byte {
+	var wraps [][]byte
+	for _, w := range []MessageWrap{
+		{Id: "echo", code: 7, buff: bytes.NewBufferString("payload")},
+		{Id: "", code: 0, buff: new(bytes.Buffer)},
+		{Id: "traced", code: 0xFFFE, buff: bytes.NewBufferString("payload"), trace: fuzzTrace},
+	} {
+		buff := new(bytes.Buffer)
+		if err := w.Serialize(buff); err != nil {
+			t.Fatal(err)
+		}
+		wraps = append(wraps, buff.Bytes())
+	}
+	return wraps
+}
+
+// fuzzFrames returns the frames of MessageWraps as sent by Nets, prefixed by their code.
+func fuzzFrames(t testing.TB) [][]byte {
+	var frames [][]byte
+	for i, w := range fuzzWraps(t) {
+		code := uint16(0)
+		if i == 2 {
+			code = tracedWrapCode
+		}
+		frames = append(frames, append(binary.BigEndian.AppendUint16(nil, code), w...))
+	}
+	return append(frames, []byte{0, 0x2A})
+}
+
+// fuzzNet returns a Net of network decoding MessageWraps, as services do.
+func fuzzNet(network string) Net {
+	var n Net
+	if network == "udp" {
+		n = newPacketNet(network, 64<<10, newOptions(nil))
+	} else {
+		n = newStreamNet(network, log.StandardLogger(), newOptions(nil))
+	}
+	n.RegisterMessage(MessageWrap{})
+	n.RegisterMessage(tracedMessageWrap{})
+	return n
+}
+
+func FuzzDecodeBytesFromBuffer(f *testing.F) {
+	f.Add([]byte{0, 3, 'a', 'b', 'c'})
+	f.Add([]byte{0, 0})
+	f.Add([]byte{0xFF, 0xFF, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buff := bytes.NewBuffer(data)
+		b, err := DecodeBytesFromBuffer(buff)
+		if err != nil {
+			return
+		}
+		encoded := new(bytes.Buffer)
+		if err := EncodeBytesToBuffer(b, encoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded.Bytes(), data[:len(data)-buff.Len()]) {
+			t.Fatalf("encoded %x, decoded from %x", encoded.Bytes(), data)
+		}
+	})
+}
+
+func FuzzDecodeString(f *testing.F) {
+	for _, s := range []string{"", "echo", "ünïcödé"} {
+		b, err := EncodeString(s)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := DecodeString(data)
+		if err != nil {
+			return
+		}
+		b, err := EncodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, b) {
+			t.Fatalf("encoded %q as %x, decoded from %x", s, b, data)
+		}
+	})
+}
+
+func FuzzMessageWrap(f *testing.F) {
+	for _, w := range fuzzWraps(f) {
+		f.Add(w)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, d := range []MessageDeserializer{MessageWrap{}.Deserialize, tracedMessageWrap{}.Deserialize} {
+			m, err := d(bytes.NewBuffer(append([]byte(nil), data...)))
+			if err != nil {
+				continue
+			}
+			wrap := m.(MessageWrap)
+			buff := new(bytes.Buffer)
+			if err := wrap.Serialize(buff); err != nil {
+				t.Fatal(err)
+			}
+			// the version of span contexts is not kept, so wraps are compared once decoded again
+			again, err := d(buff)
+			if err != nil {
+				t.Fatalf("%v serialized as %x does not decode: %v", wrap, buff.Bytes(), err)
+			}
+			if w := again.(MessageWrap); w.Id != wrap.Id || w.code != wrap.code || w.trace != wrap.trace || !bytes.Equal(w.buff.Bytes(), wrap.buff.Bytes()) {
+				t.Fatalf("%v decoded again as %v", wrap, w)
+			}
+		}
+	})
+}
+
+func FuzzTcpReceive(f *testing.F) {
+	var stream []byte
+	for _, frame := range fuzzFrames(f) {
+		f.Add(append(binary.BigEndian.AppendUint32(nil, uint32(len(frame))), frame...))
+		stream = append(binary.BigEndian.AppendUint32(stream, uint32(len(frame))), frame...)
+	}
+	f.Add(stream)
+	for _, c := range []Compression{Gzip, Zstd, Snappy} {
+		compressed, err := c.compress(fuzzFrames(f)[0])
+		if err != nil {
+			f.Fatal(err)
+		}
+		frame := append([]byte{uint8(c)}, compressed...)
+		f.Add(append(binary.BigEndian.AppendUint32(nil, uint32(len(frame))|compressedFrameFlag), frame...))
+	}
+	// a length without its frame, and frames shorter than a code
+	f.Add([]byte{0x7F, 0xFF, 0xFF, 0xFF, 0, 0})
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 1, 0})
+	n := fuzzNet("tcp").(*tcp)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		local, remote := net.Pipe()
+		go func() {
+			_, _ = remote.Write(data)
+			_ = remote.Close()
+		}()
+		conn := n.newHostConn(local)
+		defer conn.Close()
+		for {
+			_, err := n.RecvFrom(conn)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+		}
+	})
+}
+
+func FuzzUdpDatagram(f *testing.F) {
+	for _, frame := range fuzzFrames(f) {
+		f.Add(frame)
+		// datagrams of services carry the id of the service before the frame
+		datagram := new(bytes.Buffer)
+		_ = EncodeStringToBuffer("sender", datagram)
+		_ = EncodeBytesToBuffer(frame, datagram)
+		f.Add(datagram.Bytes())
+	}
+	compressed, err := Snappy.compress(fuzzFrames(f)[0])
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(append(binary.BigEndian.AppendUint16(nil, compressedCode), append([]byte{uint8(Snappy)}, compressed...)...))
+	// an empty datagram, and the datagram of a service without its id
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 2, 0, 0})
+	n := fuzzNet("udp")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := decompressDatagram(data)
+		if err != nil {
+			return
+		}
+		_, _ = n.RecvFrom(udpHostConn{b: p})
+		_, _ = n.RecvFrom(&ServiceHostConn{Conn: udpHostConn{b: p}})
+	})
+}
+
+func FuzzHandshake(f *testing.F) {
+	node := &NodeInfo{Id: "node", Version: ProtocolVersion, Codecs: []string{"json"}, ListenAddr: "127.0.0.1:9000"}
+	for _, h := range []*hello{
+		{minVersion: MinProtocolVersion, maxVersion: ProtocolVersion, features: FeatureMultiplexing, targetId: "echo", senderId: "sender", node: node},
+		{minVersion: 2, maxVersion: 2, features: FeatureMultiplexing | FeatureCompression, targetId: "echo", senderId: "sender", node: node, compressions: []Compression{Zstd, Gzip}},
+	} {
+		buff := new(bytes.Buffer)
+		if err := h.serialize(buff); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buff.Bytes())
+	}
+	for _, r := range []*helloReply{
+		{version: ProtocolVersion, features: FeatureMultiplexing | FeatureCompression, node: node, compression: Snappy},
+		{rejection: &HandshakeRejectedError{Reason: RejectUnknownService, MinVersion: 1, MaxVersion: 2, Message: "no listener"}},
+	} {
+		buff := new(bytes.Buffer)
+		if err := r.serialize(buff, false); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buff.Bytes())
+	}
+	legacy := new(bytes.Buffer)
+	_ = EncodeStringToBuffer("echo", legacy)
+	_ = EncodeStringToBuffer("sender", legacy)
+	f.Add(legacy.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if h, err := decodeHello(data); err == nil && !h.legacy && h.node != nil {
+			buff := new(bytes.Buffer)
+			if err := h.serialize(buff); err != nil {
+				return
+			}
+			if again, err := decodeHello(buff.Bytes()); err != nil || !reflect.DeepEqual(again, h) {
+				t.Fatalf("%+v decoded again as %+v, %v", h, again, err)
+			}
+		}
+		if r, err := decodeHelloReply(data, false); err == nil && (r.rejection != nil || r.node != nil) {
+			buff := new(bytes.Buffer)
+			if err := r.serialize(buff, false); err != nil {
+				return
+			}
+			if again, err := decodeHelloReply(buff.Bytes(), false); err != nil || !reflect.DeepEqual(again, r) {
+				t.Fatalf("%+v decoded again as %+v, %v", r, again, err)
+			}
+		}
+	})
+}
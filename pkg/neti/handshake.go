@@ -0,0 +1,246 @@
+package neti
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// handshakeMagic starts every handshake frame of the service protocol.
+var handshakeMagic = []byte("NETI")
+
+const (
+	// MinProtocolVersion is the oldest protocol version services accept connections from.
+	// Version 1 is the handshake without magic bytes nor versions, accepted for rolling upgrades.
+	MinProtocolVersion uint16 = 1
+	// ProtocolVersion is the newest protocol version spoken by the services.
+	ProtocolVersion uint16 = 2
+)
+
+// Feature is a capability of a service, negotiated in the connection handshake.
+type Feature uint32
+
+const (
+	FeatureMultiplexing Feature = 1 << iota //Many NetClients share a service
+	FeatureCompression                      //Frames may be compressed
+	FeatureEncryption                       //Frames may be encrypted
+	FeatureHeartbeats                       //Heartbeats are sent and expected on the connection
+	FeatureTracing                          //Messages may carry the span context of their trace
+)
+
+// Has returns true if all the features in f are set.
+func (f Feature) Has(feature Feature) bool {
+	return f&feature == feature
+}
+
+// String returns a string representation of the features.
+func (f Feature) String() string {
+	var names []string
+	for _, feature := range []struct {
+		f    Feature
+		name string
+	}{{FeatureMultiplexing, "multiplexing"}, {FeatureCompression, "compression"}, {FeatureEncryption, "encryption"}, {FeatureHeartbeats, "heartbeats"}, {FeatureTracing, "tracing"}} {
+		if f.Has(feature.f) {
+			names = append(names, feature.name)
+		}
+	}
+	return fmt.Sprintf("[%v]", strings.Join(names, " "))
+}
+
+// RejectReason is the reason a service rejected a connection.
+type RejectReason uint8
+
+const (
+	RejectIncompatibleVersion RejectReason = iota + 1 //There is no protocol version supported by both ends
+	RejectUnknownService                              //There is no listener registered for the service id
+	RejectUnauthorized                                //The Authorizer of the service rejected the connection
+)
+
+// String returns a string representation of the reason.
+func (r RejectReason) String() string {
+	switch r {
+	case RejectIncompatibleVersion:
+		return "incompatible version"
+	case RejectUnknownService:
+		return "unknown service"
+	case RejectUnauthorized:
+		return "unauthorized"
+	default:
+		return fmt.Sprintf("reason %d", uint8(r))
+	}
+}
+
+// HandshakeRejectedError is returned by NetClient.OpenTo when the peer rejects the connection.
+type HandshakeRejectedError struct {
+	Reason     RejectReason
+	Message    string
+	MinVersion uint16 //Oldest protocol version supported by the peer
+	MaxVersion uint16 //Newest protocol version supported by the peer
+}
+
+func (e *HandshakeRejectedError) Error() string {
+	return fmt.Sprintf("connection rejected by peer (%v, versions %v-%v): %v", e.Reason, e.MinVersion, e.MaxVersion, e.Message)
+}
+
+// hello is the first frame sent on a service connection, by the end opening it.
+type hello struct {
+	minVersion   uint16
+	maxVersion   uint16
+	features     Feature
+	targetId     string
+	senderId     string
+	node         *NodeInfo
+	compressions []Compression //Algorithms supported by the peer, sent with FeatureCompression
+	legacy       bool          //Sent by a peer speaking version 1
+}
+
+// serialize serializes the hello, as the handshake of version 1 (ids and NodeInfo only) when legacy is set.
+func (h *hello) serialize(buff *bytes.Buffer) error {
+	if !h.legacy {
+		buff.Write(handshakeMagic)
+		_ = EncodeNumberToBuffer(h.minVersion, buff)
+		_ = EncodeNumberToBuffer(h.maxVersion, buff)
+		_ = EncodeNumberToBuffer(h.features, buff)
+	}
+	if err := EncodeStringToBuffer(h.targetId, buff); err != nil {
+		return err
+	}
+	if err := EncodeStringToBuffer(h.senderId, buff); err != nil {
+		return err
+	}
+	if err := h.node.Serialize(buff); err != nil {
+		return err
+	}
+	if !h.legacy && h.features.Has(FeatureCompression) {
+		encodeCompressions(h.compressions, buff)
+	}
+	return nil
+}
+
+func decodeHello(b []byte) (*hello, error) {
+	h := &hello{}
+	buff := bytes.NewBuffer(b)
+	if !bytes.HasPrefix(b, handshakeMagic) {
+		h.legacy = true
+		h.minVersion, h.maxVersion = 1, 1
+		h.features = FeatureMultiplexing
+	} else {
+		buff.Next(len(handshakeMagic))
+		for _, n := range []interface{}{&h.minVersion, &h.maxVersion, &h.features} {
+			if err := DecodeNumberFromBuffer(n, buff); err != nil {
+				return nil, errors.New(fmt.Sprint("invalid handshake: ", err))
+			}
+		}
+	}
+	var err error
+	if h.targetId, err = DecodeStringFromBuffer(buff); err != nil {
+		return nil, err
+	}
+	if h.senderId, err = DecodeStringFromBuffer(buff); err != nil {
+		return nil, err
+	}
+	if h.node, err = decodeNodeInfo(buff); err != nil {
+		return nil, err
+	}
+	if h.features.Has(FeatureCompression) {
+		if h.compressions, err = decodeCompressions(buff); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// helloReply answers a hello, accepting or rejecting the connection.
+type helloReply struct {
+	version     uint16
+	features    Feature
+	node        *NodeInfo
+	compression Compression //Algorithm chosen for the connection, sent with FeatureCompression
+	rejection   *HandshakeRejectedError
+}
+
+// serialize serializes the reply, peers speaking version 1 only expect the NodeInfo.
+func (r *helloReply) serialize(buff *bytes.Buffer, legacy bool) error {
+	if legacy {
+		return r.node.Serialize(buff)
+	}
+	buff.Write(handshakeMagic)
+	if r.rejection != nil {
+		_ = EncodeNumberToBuffer(uint8(r.rejection.Reason), buff)
+		_ = EncodeNumberToBuffer(r.rejection.MinVersion, buff)
+		_ = EncodeNumberToBuffer(r.rejection.MaxVersion, buff)
+		return EncodeStringToBuffer(r.rejection.Message, buff)
+	}
+	_ = EncodeNumberToBuffer(uint8(0), buff)
+	_ = EncodeNumberToBuffer(r.version, buff)
+	_ = EncodeNumberToBuffer(r.features, buff)
+	if err := r.node.Serialize(buff); err != nil {
+		return err
+	}
+	if r.features.Has(FeatureCompression) {
+		buff.WriteByte(uint8(r.compression))
+	}
+	return nil
+}
+
+// decodeHelloReply decodes a reply, replies of version 1 (a NodeInfo, if any) are only decoded when legacy is set.
+func decodeHelloReply(b []byte, legacy bool) (*helloReply, error) {
+	if !bytes.HasPrefix(b, handshakeMagic) {
+		if !legacy {
+			return nil, errors.New("invalid handshake reply: peer does not speak a versioned protocol")
+		}
+		node, err := decodeNodeInfo(bytes.NewBuffer(b))
+		if err != nil {
+			return nil, err
+		}
+		return &helloReply{version: 1, features: FeatureMultiplexing, node: node}, nil
+	}
+	buff := bytes.NewBuffer(b[len(handshakeMagic):])
+	r := &helloReply{}
+	var reason uint8
+	if err := DecodeNumberFromBuffer(&reason, buff); err != nil {
+		return nil, errors.New(fmt.Sprint("invalid handshake reply: ", err))
+	}
+	if reason != 0 {
+		r.rejection = &HandshakeRejectedError{Reason: RejectReason(reason)}
+		for _, n := range []interface{}{&r.rejection.MinVersion, &r.rejection.MaxVersion} {
+			if err := DecodeNumberFromBuffer(n, buff); err != nil {
+				return nil, errors.New(fmt.Sprint("invalid handshake reply: ", err))
+			}
+		}
+		var err error
+		if r.rejection.Message, err = DecodeStringFromBuffer(buff); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	for _, n := range []interface{}{&r.version, &r.features} {
+		if err := DecodeNumberFromBuffer(n, buff); err != nil {
+			return nil, errors.New(fmt.Sprint("invalid handshake reply: ", err))
+		}
+	}
+	var err error
+	if r.node, err = decodeNodeInfo(buff); err != nil {
+		return nil, err
+	}
+	if r.features.Has(FeatureCompression) {
+		c, err := buff.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		r.compression = Compression(c)
+	}
+	return r, nil
+}
+
+// negotiateVersion returns the highest version in both ranges.
+func negotiateVersion(min uint16, max uint16, peerMin uint16, peerMax uint16) (uint16, bool) {
+	if peerMax < max {
+		max = peerMax
+	}
+	if peerMin > min {
+		min = peerMin
+	}
+	return max, min <= max
+}
@@ -0,0 +1,127 @@
+package neti
+
+import (
+	"bytes"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+// The nodes speaking version 1 are emulated with a tcp Net: their hello is the target and sender ids,
+// their reply is an empty frame, and their frames are sent on ServiceHostConns as by newer services.
+
+func TestHandshakeToVersion1Node(t *testing.T) {
+	v1 := NewTcpNet(log.StandardLogger())
+	v1.RegisterMessage(MessageWrap{})
+	conns, err := v1.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v1.CloseListener()
+	addr := v1.(*tcp).listener.Addr().String()
+	accepted := make(chan HostConn, 1)
+	go func() {
+		conn := <-conns
+		hello, err := conn.Receive()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if bytes.HasPrefix(hello, handshakeMagic) {
+			t.Error("sent the versioned hello to a node speaking version 1")
+		}
+		buff := bytes.NewBuffer(hello)
+		if target, err := DecodeStringFromBuffer(buff); err != nil || target != "receiver" {
+			t.Errorf("hello targets %q, %v", target, err)
+		}
+		if sender, err := DecodeStringFromBuffer(buff); err != nil || sender != "sender" {
+			t.Errorf("hello is sent by %q, %v", sender, err)
+		}
+		_ = conn.Send([]byte{})
+		accepted <- conn
+	}()
+
+	service := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), WithProtocolVersions(1, 1))
+	defer service.Close()
+	sender := service.RegisterListener("sender")
+	conn, err := sender.OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Version != 1 || conn.Peer != nil {
+		t.Fatalf("connected with version %v to %v, expected version 1 to an unknown node", conn.Version, conn.Peer)
+	}
+	if err := sender.SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var v1Conn HostConn
+	testutil.Within(t, testTimeout, "the handshake", func() { v1Conn = <-accepted })
+	m, err := v1.RecvFrom(&ServiceHostConn{Conn: v1Conn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap := m.(MessageWrap); wrap.Id != "sender" || wrap.MessageCode() != 1 {
+		t.Fatalf("the node speaking version 1 received %v", wrap)
+	}
+}
+
+func TestHandshakeFromVersion1Node(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+
+	v1 := NewTcpNet(log.StandardLogger())
+	conn, err := v1.Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hello := new(bytes.Buffer)
+	_ = EncodeStringToBuffer("receiver", hello)
+	_ = EncodeStringToBuffer("sender", hello)
+	if err := conn.Send(hello.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(reply, handshakeMagic) {
+		t.Fatal("replied with the versioned reply to a node speaking version 1")
+	}
+	var accepted *ServiceHostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-receiver.Accept() })
+	if accepted.Version != 1 || accepted.ServiceId != "sender" {
+		t.Fatalf("accepted %v with version %v, expected sender with version 1", accepted.ServiceId, accepted.Version)
+	}
+
+	payload := new(bytes.Buffer)
+	_ = registryMsg{code: 1, seq: 7}.Serialize(payload)
+	if err := v1.SendTo(&ServiceHostConn{Conn: conn, ServiceId: "receiver"}, MessageWrap{Id: "sender", code: 1, buff: payload}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := receiver.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 7 {
+		t.Fatalf("received %v, %v; expected message 7", m, err)
+	}
+}
+
+func TestHandshakeVersion1Reply(t *testing.T) {
+	node := &NodeInfo{Id: "node", Version: 1}
+	buff := new(bytes.Buffer)
+	_ = node.Serialize(buff)
+	for _, b := range [][]byte{{}, buff.Bytes()} {
+		if _, err := decodeHelloReply(b, false); err == nil {
+			t.Fatalf("decoded the reply %x of version 1 when only speaking version 2", b)
+		}
+		r, err := decodeHelloReply(b, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.version != 1 || r.rejection != nil || (len(b) > 0) != (r.node != nil) {
+			t.Fatalf("decoded %x as %+v", b, r)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package neti
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Heartbeats configures the heartbeats of service connections.
+type Heartbeats struct {
+	Interval     time.Duration //Time between heartbeats, a second if not set
+	Timeout      time.Duration //Time without hearing from the peer before it is down and the connection closed, 5 intervals if not set
+	PhiThreshold float64       //Suspicion level (phi accrual) over which the peer is suspected, 0 to suspect it after half the Timeout
+}
+
+// WithHeartbeats sends heartbeats on the connections of stream services (tcp, unix, ws, quic) and monitors the peers,
+// reporting PeerEvents on the NetClient. Connections are only monitored when both ends enable heartbeats,
+// which is negotiated in the handshake as FeatureHeartbeats.
+// Monitored connections are read in the background, so that a peer is heard even when the application is not receiving,
+// queueing up to monitorQueueSize frames: a peer is not heard while its queue is full, so applications must keep
+// receiving from monitored connections, or the peer is eventually reported down.
+func WithHeartbeats(heartbeats Heartbeats) Option {
+	if heartbeats.Interval <= 0 {
+		heartbeats.Interval = time.Second
+	}
+	if heartbeats.Timeout <= 0 {
+		heartbeats.Timeout = 5 * heartbeats.Interval
+	}
+	return func(o *options) {
+		o.heartbeats = &heartbeats
+	}
+}
+
+// PeerEventType is the type of PeerEvent.
+type PeerEventType uint8
+
+const (
+	PeerUp        PeerEventType = iota + 1 //The connection is established, or the peer is heard again after being suspected
+	PeerSuspected                          //The peer was not heard for longer than expected
+	PeerDown                               //The peer timed out, or the connection failed, and the connection is closed
+)
+
+// String returns the name of the event type.
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerUp:
+		return "up"
+	case PeerSuspected:
+		return "suspected"
+	case PeerDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerEvent reports a change in the state of the peer on a monitored connection.
+type PeerEvent struct {
+	Type PeerEventType
+	Conn *ServiceHostConn
+	Peer *NodeInfo //Identity of the peer, as exchanged in the handshake
+	Err  error     //Why the peer is down
+}
+
+var errHeartbeatTimeout = errors.New("peer timed out")
+
+const (
+	peerEventsBuffer = 64
+	monitorQueueSize = 64
+)
+
+// peerEvents delivers the PeerEvents of a NetClient.
+type peerEvents struct {
+	ch        chan PeerEvent
+	lock      *sync.Mutex
+	callbacks []func(PeerEvent)
+	pending   []PeerEvent //events yet to be delivered to the callbacks
+	running   bool        //a goroutine is delivering the pending events
+}
+
+func newPeerEvents() *peerEvents {
+	return &peerEvents{
+		ch:   make(chan PeerEvent, peerEventsBuffer),
+		lock: &sync.Mutex{},
+	}
+}
+
+// PeerEvents returns the channel of the events of the peers on monitored connections.
+// Events are dropped when the channel is full.
+func (p *peerEvents) PeerEvents() <-chan PeerEvent {
+	return p.ch
+}
+
+// OnPeerEvent calls f on every event of the peers on monitored connections.
+// Callbacks are called in order, on a goroutine of their own, so that a slow callback does not stall the connections.
+func (p *peerEvents) OnPeerEvent(f func(PeerEvent)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.callbacks = append(p.callbacks, f)
+}
+
+func (p *peerEvents) emit(e PeerEvent) {
+	p.lock.Lock()
+	if len(p.callbacks) > 0 {
+		p.pending = append(p.pending, e)
+		if !p.running {
+			p.running = true
+			go p.deliver()
+		}
+	}
+	p.lock.Unlock()
+	select {
+	case p.ch <- e:
+	default:
+	}
+}
+
+// deliver calls the callbacks on the pending events, until there are none.
+func (p *peerEvents) deliver() {
+	for {
+		p.lock.Lock()
+		if len(p.pending) == 0 {
+			p.running = false
+			p.lock.Unlock()
+			return
+		}
+		e, callbacks := p.pending[0], p.callbacks
+		p.pending = p.pending[1:]
+		p.lock.Unlock()
+		for _, f := range callbacks {
+			f(e)
+		}
+	}
+}
+
+// peerMonitor sends heartbeats on a connection and detects the failure of the peer.
+// It reads the connection in the background, filtering out the heartbeats (empty frames)
+// and queueing the other frames for the application.
+type peerMonitor struct {
+	conn     *ServiceHostConn
+	config   Heartbeats
+	events   *peerEvents
+	frames   chan []byte
+	err      error //set before frames is closed
+	stop     chan struct{}
+	lock     *sync.Mutex
+	detector *phiDetector
+	state    PeerEventType
+}
+
+// startPeerMonitor starts monitoring conn, reporting the peer up.
+func startPeerMonitor(conn *ServiceHostConn, config Heartbeats, events *peerEvents) {
+	m := &peerMonitor{
+		conn:     conn,
+		config:   config,
+		events:   events,
+		frames:   make(chan []byte, monitorQueueSize),
+		stop:     make(chan struct{}),
+		lock:     &sync.Mutex{},
+		detector: newPhiDetector(time.Now(), config.Interval),
+		state:    PeerUp,
+	}
+	conn.monitor = m
+	events.emit(PeerEvent{Type: PeerUp, Conn: conn, Peer: conn.Peer})
+	go m.read()
+	go m.beat()
+}
+
+// receive returns the next frame that is not a heartbeat.
+func (m *peerMonitor) receive() ([]byte, error) {
+	if b, ok := <-m.frames; ok {
+		return b, nil
+	}
+	return nil, m.err
+}
+
+func (m *peerMonitor) read() {
+	defer close(m.frames)
+	for {
+		b, err := m.conn.Conn.Receive()
+		if err != nil {
+			m.err = err
+			m.down(err)
+			return
+		}
+		m.heard()
+		if len(b) == 0 {
+			continue
+		}
+		select {
+		case m.frames <- b:
+		case <-m.stop:
+			m.err = net.ErrClosed
+			return
+		}
+	}
+}
+
+func (m *peerMonitor) beat() {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			if err := m.conn.Conn.Send([]byte{}); err != nil {
+				m.down(err)
+				return
+			}
+			if !m.check(time.Now()) {
+				return
+			}
+		}
+	}
+}
+
+// transition changes the state of the peer, returning false if it already was in that state or is down.
+func (m *peerMonitor) transition(from PeerEventType, to PeerEventType) bool {
+	if m.state != from {
+		return false
+	}
+	m.state = to
+	if to == PeerDown {
+		close(m.stop)
+	}
+	return true
+}
+
+func (m *peerMonitor) heard() {
+	m.lock.Lock()
+	m.detector.heartbeat(time.Now())
+	recovered := m.transition(PeerSuspected, PeerUp)
+	m.lock.Unlock()
+	if recovered {
+		m.events.emit(PeerEvent{Type: PeerUp, Conn: m.conn, Peer: m.conn.Peer})
+	}
+}
+
+// check evaluates the failure detector, returning false once the peer is down.
+func (m *peerMonitor) check(now time.Time) bool {
+	m.lock.Lock()
+	elapsed := now.Sub(m.detector.last)
+	suspected := elapsed >= m.config.Timeout/2
+	if m.config.PhiThreshold > 0 {
+		suspected = m.detector.phi(now) >= m.config.PhiThreshold
+	}
+	suspected = suspected && elapsed < m.config.Timeout && m.transition(PeerUp, PeerSuspected)
+	m.lock.Unlock()
+	if suspected {
+		m.events.emit(PeerEvent{Type: PeerSuspected, Conn: m.conn, Peer: m.conn.Peer})
+	}
+	if elapsed >= m.config.Timeout {
+		m.down(errHeartbeatTimeout)
+		return false
+	}
+	return true
+}
+
+// down reports the peer down and closes the connection, unless it already is.
+func (m *peerMonitor) down(err error) {
+	m.lock.Lock()
+	down := m.transition(PeerUp, PeerDown) || m.transition(PeerSuspected, PeerDown)
+	m.lock.Unlock()
+	if down {
+		_ = m.conn.Conn.Close()
+		m.events.emit(PeerEvent{Type: PeerDown, Conn: m.conn, Peer: m.conn.Peer, Err: err})
+	}
+}
+
+// close stops monitoring the connection and closes it, without reporting the peer down.
+func (m *peerMonitor) close() error {
+	m.lock.Lock()
+	_ = m.transition(PeerUp, PeerDown) || m.transition(PeerSuspected, PeerDown)
+	m.lock.Unlock()
+	return m.conn.Conn.Close()
+}
+
+const phiWindowSize = 100
+
+// phiDetector is a phi accrual failure detector (Hayashibara et al.), estimating how likely it is that
+// the peer failed from the distribution of the times between the frames received.
+type phiDetector struct {
+	intervals []float64 //milliseconds, the last phiWindowSize ones
+	next      int
+	last      time.Time
+}
+
+func newPhiDetector(now time.Time, expected time.Duration) *phiDetector {
+	return &phiDetector{
+		intervals: []float64{float64(expected.Milliseconds())},
+		last:      now,
+	}
+}
+
+func (d *phiDetector) heartbeat(now time.Time) {
+	interval := float64(now.Sub(d.last).Milliseconds())
+	d.last = now
+	if len(d.intervals) < phiWindowSize {
+		d.intervals = append(d.intervals, interval)
+	} else {
+		d.intervals[d.next] = interval
+		d.next = (d.next + 1) % phiWindowSize
+	}
+}
+
+// phi returns the suspicion level of the peer: a phi of 1 means a 10% chance of being wrong when suspecting it,
+// 2 means 1%, 3 means 0.1%, ...
+func (d *phiDetector) phi(now time.Time) float64 {
+	var mean, variance float64
+	for _, i := range d.intervals {
+		mean += i
+	}
+	mean /= float64(len(d.intervals))
+	for _, i := range d.intervals {
+		variance += (i - mean) * (i - mean)
+	}
+	std := math.Max(math.Sqrt(variance/float64(len(d.intervals))), math.Max(mean/4, 1))
+	y := (float64(now.Sub(d.last).Milliseconds()) - mean) / std
+	// logistic approximation of the normal cumulative distribution
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if y > 0 {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
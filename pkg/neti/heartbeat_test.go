@@ -0,0 +1,152 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chanConn is a HostConn receiving the frames of a channel, and discarding the frames sent.
+type chanConn struct {
+	frames    chan []byte
+	closeOnce *sync.Once
+	closed    chan struct{}
+}
+
+func newChanConn() chanConn {
+	return chanConn{frames: make(chan []byte, 256), closeOnce: &sync.Once{}, closed: make(chan struct{})}
+}
+
+func (c chanConn) String() string {
+	return "chan"
+}
+
+func (c chanConn) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (c chanConn) Send([]byte) error {
+	return nil
+}
+
+func (c chanConn) Receive() ([]byte, error) {
+	select {
+	case b := <-c.frames:
+		return b, nil
+	case <-c.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (c chanConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestPhiDetector(t *testing.T) {
+	now := time.Now()
+	d := newPhiDetector(now, 100*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		now = now.Add(100 * time.Millisecond)
+		d.heartbeat(now)
+	}
+	if phi := d.phi(now.Add(100 * time.Millisecond)); phi >= 1 {
+		t.Fatalf("phi of %v after the expected interval", phi)
+	}
+	previous := 0.0
+	for _, elapsed := range []time.Duration{150, 200, 300, 500} {
+		phi := d.phi(now.Add(elapsed * time.Millisecond))
+		if phi <= previous {
+			t.Fatalf("phi of %v after %vms, expected more than %v", phi, elapsed, previous)
+		}
+		previous = phi
+	}
+	if previous < 8 {
+		t.Fatalf("phi of %v after 5 missed heartbeats, expected at least 8", previous)
+	}
+}
+
+// monitored starts monitoring a chanConn, with the callbacks on the events of the peer.
+func monitored(config Heartbeats, callbacks ...func(PeerEvent)) (chanConn, *ServiceHostConn, *peerEvents) {
+	conn := newChanConn()
+	sConn := &ServiceHostConn{Conn: conn, ServiceId: "monitored"}
+	events := newPeerEvents()
+	for _, f := range callbacks {
+		events.OnPeerEvent(f)
+	}
+	startPeerMonitor(sConn, config, events)
+	return conn, sConn, events
+}
+
+func TestPeerMonitorSuspectsAndRecovers(t *testing.T) {
+	conn, sConn, events := monitored(Heartbeats{Interval: 10 * time.Millisecond, Timeout: time.Second})
+	defer sConn.Close()
+	expect := func(expected PeerEventType) {
+		t.Helper()
+		testutil.Within(t, testTimeout, "the peer "+expected.String(), func() {
+			if e := <-events.PeerEvents(); e.Type != expected || e.Conn != sConn {
+				t.Errorf("the peer is %v, expected %v", e.Type, expected)
+			}
+		})
+	}
+	expect(PeerUp)
+	expect(PeerSuspected)
+	conn.frames <- []byte{}
+	expect(PeerUp)
+}
+
+func TestPeerMonitorDetectsDeadPeerWhileNotReceiving(t *testing.T) {
+	conn, sConn, events := monitored(Heartbeats{Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond})
+	defer sConn.Close()
+	// the application never receives, so the queue fills up before the peer dies
+	for i := 0; i < 2*monitorQueueSize; i++ {
+		conn.frames <- []byte{1}
+	}
+	testutil.Within(t, testTimeout, "the peer to be down", func() {
+		for e := range events.PeerEvents() {
+			if e.Type == PeerDown {
+				if e.Err != errHeartbeatTimeout {
+					t.Errorf("the peer is down with %v, expected errHeartbeatTimeout", e.Err)
+				}
+				return
+			}
+		}
+	})
+	// the frames queued before the peer was down are still received
+	if b, err := sConn.monitor.receive(); err != nil || len(b) != 1 {
+		t.Fatalf("received %v, %v; expected a queued frame", b, err)
+	}
+}
+
+func TestPeerEventCallbacksDoNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	lock := &sync.Mutex{}
+	var called []PeerEventType
+	done := make(chan struct{})
+	conn, sConn, _ := monitored(Heartbeats{Interval: 10 * time.Millisecond, Timeout: time.Second}, func(e PeerEvent) {
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		called = append(called, e.Type)
+		if e.Type == PeerDown {
+			close(done)
+		}
+	})
+	// the monitor keeps reading while the callback is blocked
+	conn.frames <- []byte{1}
+	testutil.Within(t, testTimeout, "the frame", func() {
+		if b, err := sConn.monitor.receive(); err != nil || len(b) != 1 {
+			t.Errorf("received %v, %v; expected the frame", b, err)
+		}
+	})
+	_ = conn.Close()
+	close(release)
+	testutil.Within(t, testTimeout, "the callbacks", func() { <-done })
+	lock.Lock()
+	defer lock.Unlock()
+	if len(called) < 2 || called[0] != PeerUp || called[len(called)-1] != PeerDown {
+		t.Fatalf("called back on %v, expected the peer up first and down last", called)
+	}
+}
@@ -0,0 +1,107 @@
+package neti
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// SendFunc sends message, serialized as b, on conn.
+// In a Net, b is the frame (the code of message followed by its payload); in a NetClient, b is the payload of message.
+type SendFunc func(conn HostConn, message Message, b []byte) error
+
+// RecvFunc deserializes the message received as b on conn.
+// In a Net, b is the frame (the code of the message followed by its payload); in a NetClient, b is the payload of the message.
+// A nil message and a nil error drops the message.
+type RecvFunc func(conn HostConn, b []byte) (Message, error)
+
+// SendInterceptor wraps the sending of messages. It can modify the message or the bytes before calling next,
+// drop the message by returning without calling next, or fail the send by returning an error.
+type SendInterceptor func(next SendFunc) SendFunc
+
+// RecvInterceptor wraps the reception of messages. It can modify the bytes before calling next, or the message after,
+// drop the message by returning a nil message and a nil error, or short-circuit by returning a message without calling next.
+type RecvInterceptor func(next RecvFunc) RecvFunc
+
+// ErrDropped is returned by the RecvFrom of a datagram Net when an interceptor drops the datagram,
+// stream Nets and NetClients move on to the next message instead.
+var ErrDropped = errors.New("message dropped by an interceptor")
+
+// interceptors are the interceptor chains of a Net or NetClient.
+// The first interceptor registered is the outermost: it is the first to see a message being sent,
+// and the last to see a message being received.
+type interceptors struct {
+	lock *sync.RWMutex
+	send []SendInterceptor
+	recv []RecvInterceptor
+}
+
+func newInterceptors() *interceptors {
+	return &interceptors{lock: &sync.RWMutex{}}
+}
+
+// UseSend appends interceptor to the chain of sent messages.
+func (i *interceptors) UseSend(interceptor SendInterceptor) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.send = append(i.send, interceptor)
+}
+
+// UseRecv appends interceptor to the chain of received messages.
+func (i *interceptors) UseRecv(interceptor RecvInterceptor) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.recv = append(i.recv, interceptor)
+}
+
+// sendChain returns base wrapped in the send interceptors.
+func (i *interceptors) sendChain(base SendFunc) SendFunc {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	for j := len(i.send) - 1; j >= 0; j-- {
+		base = i.send[j](base)
+	}
+	return base
+}
+
+// recvChain returns base wrapped in the receive interceptors.
+func (i *interceptors) recvChain(base RecvFunc) RecvFunc {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	for j := len(i.recv) - 1; j >= 0; j-- {
+		base = i.recv[j](base)
+	}
+	return base
+}
+
+// wrapSend returns the innermost SendFunc of the NetClient id, sending the payload wrapped in a MessageWrap on net,
+// along with the span context trace, if valid.
+func wrapSend(net Net, id string, trace SpanContext) SendFunc {
+	return func(conn HostConn, message Message, b []byte) error {
+		return net.SendTo(conn, MessageWrap{Id: id, Msg: message, code: message.Code(), buff: bytes.NewBuffer(b), trace: trace})
+	}
+}
+
+// decodeWith returns the innermost RecvFunc of the NetClient id, deserializing the payload of a message with code.
+func decodeWith(msgs *deserializers, code uint16, id string) RecvFunc {
+	return func(_ HostConn, b []byte) (Message, error) {
+		d, ok := msgs.lookup(code)
+		if !ok {
+			return nil, &DecodeError{Code: code, ServiceId: id, Err: errors.New("Unknown serializer")}
+		}
+		msg, err := d(bytes.NewBuffer(b))
+		if err != nil {
+			return nil, &DecodeError{Code: code, ServiceId: id, Err: err}
+		}
+		return msg, nil
+	}
+}
+
+// serializePayload returns the payload of message, as seen by the interceptors of a NetClient.
+func serializePayload(message Message) ([]byte, error) {
+	buff := new(bytes.Buffer)
+	if err := message.Serialize(buff); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
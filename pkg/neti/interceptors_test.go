@@ -0,0 +1,77 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// dropFirst returns an interceptor dropping the first message it receives.
+func dropFirst() RecvInterceptor {
+	dropped := &atomic.Bool{}
+	return func(next RecvFunc) RecvFunc {
+		return func(conn HostConn, b []byte) (Message, error) {
+			if dropped.CompareAndSwap(false, true) {
+				return nil, nil
+			}
+			return next(conn, b)
+		}
+	}
+}
+
+func TestUdpNetInterceptorDrops(t *testing.T) {
+	receiver, datagrams := udpLimited(t)
+	receiver.UseRecv(dropFirst())
+	sendFrom(t, "127.0.0.1:0", receiver, 1)
+	sendFrom(t, "127.0.0.1:0", receiver, 2)
+	testutil.Within(t, testTimeout, "the datagrams", func() {
+		if _, err := receiver.RecvFrom(<-datagrams); err != ErrDropped {
+			t.Errorf("receiving the dropped datagram failed with %v, expected ErrDropped", err)
+		}
+		if m, err := receiver.RecvFrom(<-datagrams); err != nil || m.(registryMsg).seq != 2 {
+			t.Errorf("received %v, %v; expected message 2", m, err)
+		}
+	})
+}
+
+func TestUdpServiceInterceptorDrops(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		use  func(service NetService, client NetClient)
+	}{
+		// the service moves on to the next datagram when its Net returns ErrDropped
+		{"Net", func(service NetService, _ NetClient) { service.(*basicUdpService).net.UseRecv(dropFirst()) }},
+		{"NetClient", func(_ NetService, client NetClient) { client.UseRecv(dropFirst()) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr := freeAddr(t, "udp")
+			service := InitBaseUdpService(addr, 1024)
+			defer service.Close()
+			receiver := service.RegisterListener("receiver")
+			receiver.RegisterMessage(registryMsg{code: 1})
+			test.use(service, receiver)
+			senderService := InitBaseUdpService(freeAddr(t, "udp"), 1024)
+			defer senderService.Close()
+			sender := senderService.RegisterListener("sender")
+			conn, err := sender.OpenTo(addr, "receiver")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = sender.SendTo(conn, registryMsg{code: 1, seq: 1})
+			// the datagrams are delivered concurrently, so the second one is sent once the first is dropped
+			time.Sleep(50 * time.Millisecond)
+			_ = sender.SendTo(conn, registryMsg{code: 1, seq: 2})
+			testutil.Within(t, testTimeout, "the datagram after the dropped one", func() {
+				if m, err := receiver.RecvFrom(<-receiver.Accept()); err != nil || m.(registryMsg).seq != 2 {
+					t.Errorf("received %v, %v; expected message 2", m, err)
+				}
+			})
+			select {
+			case conn := <-receiver.Accept():
+				t.Fatalf("received %v, expected the first datagram to be dropped", conn.Msg)
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+// Package testutil provides the helpers shared by the tests of neti and its conformance suite (see nettest).
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+// Within runs f, failing the test if it does not return within timeout.
+func Within(t testing.TB, timeout time.Duration, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for %v", what)
+	}
+}
@@ -0,0 +1,260 @@
+package neti
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// LimitPolicy is what happens to messages over the rate limits.
+type LimitPolicy uint8
+
+const (
+	LimitDrop       LimitPolicy = iota //Drop the message
+	LimitDelay                         //Hold the message until it fits the rate, slowing down the connection (datagrams are dropped)
+	LimitDisconnect                    //Close the connection the message was received on (datagrams are dropped)
+)
+
+// Limits configures connection and message rate limits. Zero values are unlimited.
+type Limits struct {
+	MaxConnections      int //Maximum concurrent connections accepted by a tcp or unix Net
+	MaxConnectionsPerIP int //Maximum concurrent connections accepted from the same ip (or bound unix socket)
+
+	PeerRate     float64 //Messages per second received from the same ip (or bound unix socket) by a tcp, unix, udp or unixgram Net, heartbeats excluded
+	PeerBurst    int     //Messages from the same ip received in a burst over PeerRate
+	ServiceRate  float64 //Messages per second received by the same listener id of a service
+	ServiceBurst int     //Messages received by the same listener id in a burst over ServiceRate
+
+	Policy LimitPolicy //What happens to messages over the rates
+}
+
+// WithLimits sets the limits of a Net or NetService.
+// Nets apply the connection and peer rate limits, services also apply the per listener id rate limits.
+// Messages are only counted once authenticated (see WithClusterKey), so forged ones cannot exhaust the rate of a peer.
+// Datagrams over the rates are always dropped, since all of them are received by the same goroutine.
+// Connection slots are released when connections are closed.
+// Rejected connections and messages over the rates are counted in Stats.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = &limits
+	}
+}
+
+var errLimitDisconnect = errors.New("connection closed: message rate limit exceeded")
+
+// limiter enforces Limits.
+type limiter struct {
+	limits   Limits
+	stats    *Stats
+	lock     *sync.Mutex
+	conns    int
+	connsPer map[string]int
+	peers    *buckets
+	services *buckets
+}
+
+func newLimiter(o *options) *limiter {
+	if o.limits == nil {
+		return nil
+	}
+	return &limiter{
+		limits:   *o.limits,
+		stats:    o.stats,
+		lock:     &sync.Mutex{},
+		connsPer: make(map[string]int),
+		peers:    newBuckets(o.limits.PeerRate, o.limits.PeerBurst),
+		services: newBuckets(o.limits.ServiceRate, o.limits.ServiceBurst),
+	}
+}
+
+// peerKey returns the key of the peer at addr in the per ip limits: its ip, or the path of a bound unix socket.
+// Peers on unbound unix sockets, as the clients of unix stream sockets, cannot be told apart, so they are not limited
+// per ip (they still count in MaxConnections).
+func peerKey(addr net.Addr) (string, bool) {
+	if a, ok := addr.(*net.UnixAddr); ok {
+		if a == nil || a.Name == "" || a.Name == "@" {
+			return "", false
+		}
+		return a.Network() + ":" + a.Name, true
+	}
+	if ip := addrIP(addr); ip != nil {
+		return ip.String(), true
+	}
+	return "", false
+}
+
+// acquireConn reserves a connection slot for a connection from addr, returning the function releasing it.
+func (l *limiter) acquireConn(addr net.Addr) (func(), bool) {
+	key, perPeer := peerKey(addr)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if (l.limits.MaxConnections > 0 && l.conns >= l.limits.MaxConnections) ||
+		(perPeer && l.limits.MaxConnectionsPerIP > 0 && l.connsPer[key] >= l.limits.MaxConnectionsPerIP) {
+		l.stats.RejectedConnections.Add(1)
+		return nil, false
+	}
+	l.conns++
+	if perPeer {
+		l.connsPer[key]++
+	}
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			l.conns--
+			if !perPeer {
+				return
+			}
+			if l.connsPer[key]--; l.connsPer[key] == 0 {
+				delete(l.connsPer, key)
+			}
+		})
+	}, true
+}
+
+// admitPeer applies the peer rate to a message from addr, returning false if it must be dropped
+// and errLimitDisconnect if the connection must be closed.
+func (l *limiter) admitPeer(addr net.Addr) (bool, error) {
+	key, ok := peerKey(addr)
+	if !ok {
+		return true, nil
+	}
+	return l.admit(l.peers, key)
+}
+
+// admitService applies the service rate to a message for the listener id.
+func (l *limiter) admitService(id string) (bool, error) {
+	return l.admit(l.services, id)
+}
+
+// allowPeer applies the peer rate to a datagram from addr, returning false if it must be dropped.
+// Datagrams are never delayed, so that one peer does not hold back the datagrams of the others.
+func (l *limiter) allowPeer(addr net.Addr) bool {
+	key, ok := peerKey(addr)
+	if !ok {
+		return true
+	}
+	return l.allow(l.peers, key)
+}
+
+// allowService applies the service rate to a datagram for the listener id.
+func (l *limiter) allowService(id string) bool {
+	return l.allow(l.services, id)
+}
+
+func (l *limiter) allow(b *buckets, key string) bool {
+	if b == nil || b.allow(key) {
+		return true
+	}
+	l.stats.DroppedMessages.Add(1)
+	return false
+}
+
+func (l *limiter) admit(b *buckets, key string) (bool, error) {
+	if b == nil {
+		return true, nil
+	}
+	switch l.limits.Policy {
+	case LimitDelay:
+		if wait := b.reserve(key); wait > 0 {
+			l.stats.DelayedMessages.Add(1)
+			time.Sleep(wait)
+		}
+		return true, nil
+	case LimitDisconnect:
+		if !b.allow(key) {
+			l.stats.DroppedMessages.Add(1)
+			return false, errLimitDisconnect
+		}
+		return true, nil
+	default:
+		if !b.allow(key) {
+			l.stats.DroppedMessages.Add(1)
+			return false, nil
+		}
+		return true, nil
+	}
+}
+
+const bucketsSweepSize = 4096
+
+// buckets is a set of token buckets, one per key.
+type buckets struct {
+	rate    float64
+	burst   float64
+	lock    *sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newBuckets(rate float64, burst int) *buckets {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &buckets{
+		rate:    rate,
+		burst:   float64(burst),
+		lock:    &sync.Mutex{},
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// refill returns the bucket of key with the tokens accumulated since it was last used.
+func (b *buckets) refill(key string, now time.Time) *bucket {
+	bk, ok := b.buckets[key]
+	if !ok {
+		if len(b.buckets) >= bucketsSweepSize {
+			b.sweep(now)
+		}
+		bk = &bucket{tokens: b.burst, last: now}
+		b.buckets[key] = bk
+	}
+	bk.tokens += now.Sub(bk.last).Seconds() * b.rate
+	if bk.tokens > b.burst {
+		bk.tokens = b.burst
+	}
+	bk.last = now
+	return bk
+}
+
+// sweep forgets the buckets that are full again, they are recreated as new ones when needed.
+func (b *buckets) sweep(now time.Time) {
+	for key, bk := range b.buckets {
+		if bk.tokens+now.Sub(bk.last).Seconds()*b.rate >= b.burst {
+			delete(b.buckets, key)
+		}
+	}
+}
+
+// allow takes a token from the bucket of key, if there is one.
+func (b *buckets) allow(key string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	bk := b.refill(key, time.Now())
+	if bk.tokens < 1 {
+		return false
+	}
+	bk.tokens--
+	return true
+}
+
+// reserve takes a token from the bucket of key, returning how long to wait until it is available.
+func (b *buckets) reserve(key string) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	bk := b.refill(key, time.Now())
+	bk.tokens--
+	if bk.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bk.tokens / b.rate * float64(time.Second))
+}
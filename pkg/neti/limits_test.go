@@ -0,0 +1,215 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuckets(t *testing.T) {
+	b := newBuckets(10, 2)
+	if !b.allow("a") || !b.allow("a") {
+		t.Fatal("the burst was not allowed")
+	}
+	if b.allow("a") {
+		t.Fatal("allowed a message over the burst")
+	}
+	if !b.allow("b") {
+		t.Fatal("the rate of a key was charged to another")
+	}
+	if wait := b.reserve("a"); wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("reserved a token in %v, expected to wait up to 100ms", wait)
+	}
+	if newBuckets(0, 10) != nil {
+		t.Fatal("created buckets without a rate")
+	}
+}
+
+func TestLimiterConnections(t *testing.T) {
+	stats := &Stats{}
+	l := newLimiter(newOptions([]Option{WithLimits(Limits{MaxConnections: 2, MaxConnectionsPerIP: 1}), WithStats(stats)}))
+	a, b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2)}
+	release, ok := l.acquireConn(a)
+	if !ok {
+		t.Fatal("rejected the first connection")
+	}
+	if _, ok := l.acquireConn(a); ok {
+		t.Fatal("accepted a second connection from the same ip")
+	}
+	if _, ok := l.acquireConn(b); !ok {
+		t.Fatal("rejected the connection of another ip")
+	}
+	if _, ok := l.acquireConn(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 3)}); ok {
+		t.Fatal("accepted a connection over MaxConnections")
+	}
+	release()
+	release()
+	if _, ok := l.acquireConn(a); !ok {
+		t.Fatal("rejected a connection once a slot was released")
+	}
+	if n := stats.RejectedConnections.Load(); n != 2 {
+		t.Fatalf("%v rejected connections, expected 2", n)
+	}
+}
+
+func TestLimiterUnixPeers(t *testing.T) {
+	l := newLimiter(newOptions([]Option{WithLimits(Limits{MaxConnectionsPerIP: 1, PeerRate: 0.1, PeerBurst: 1, Policy: LimitDrop})}))
+	// the clients of unix stream sockets are unbound, and cannot be told apart
+	for _, unbound := range []net.Addr{&net.UnixAddr{Net: "unix"}, &net.UnixAddr{Name: "@", Net: "unix"}, (*net.UnixAddr)(nil)} {
+		for i := 0; i < 3; i++ {
+			if _, ok := l.acquireConn(unbound); !ok {
+				t.Fatalf("rejected connection %v from the unbound unix socket %v", i, unbound)
+			}
+			if ok, _ := l.admitPeer(unbound); !ok {
+				t.Fatalf("dropped message %v from the unbound unix socket %v", i, unbound)
+			}
+		}
+	}
+	// bound sockets are limited by their path
+	a, b := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unixgram"}, &net.UnixAddr{Name: "/tmp/b.sock", Net: "unixgram"}
+	if !l.allowPeer(a) || l.allowPeer(a) || !l.allowPeer(b) {
+		t.Fatal("bound unix sockets were not limited by their path")
+	}
+}
+
+// udpLimited returns a udp Net with the given limits, and the channel of its datagrams.
+func udpLimited(t *testing.T, opts ...Option) (Net, <-chan HostConn) {
+	n := NewUdpNet(1024, opts...)
+	n.RegisterMessage(registryMsg{code: 1})
+	datagrams, err := n.Listen(freeAddr(t, "udp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = n.CloseListener() })
+	return n, datagrams
+}
+
+// sendFrom sends a message from a udp Net bound to addr.
+func sendFrom(t *testing.T, addr string, to Net, seq uint32, opts ...Option) {
+	t.Helper()
+	n := NewUdpNet(1024, opts...)
+	if _, err := n.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer n.CloseListener()
+	conn, err := n.Open(to.(*udp).conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SendTo(conn, registryMsg{code: 1, seq: seq}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUdpLimitDelayDoesNotBlock(t *testing.T) {
+	stats := &Stats{}
+	receiver, datagrams := udpLimited(t, WithLimits(Limits{PeerRate: 0.1, PeerBurst: 1, Policy: LimitDelay}), WithStats(stats))
+	for i := 0; i < 5; i++ {
+		sendFrom(t, "127.0.0.1:0", receiver, uint32(i))
+	}
+	// another peer is not held back by the datagrams over the rate of the first one
+	sendFrom(t, "127.0.0.2:0", receiver, 100)
+	received := map[uint32]bool{}
+	testutil.Within(t, testTimeout, "the datagrams within the rates", func() {
+		for len(received) < 2 {
+			m, err := receiver.RecvFrom(<-datagrams)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			received[m.(registryMsg).seq] = true
+		}
+	})
+	if !received[0] || !received[100] {
+		t.Fatalf("received %v, expected the first message of each peer", received)
+	}
+	if stats.DroppedMessages.Load() != 4 || stats.DelayedMessages.Load() != 0 {
+		t.Fatalf("%v dropped and %v delayed datagrams, expected 4 dropped", stats.DroppedMessages.Load(), stats.DelayedMessages.Load())
+	}
+}
+
+func TestUdpLimitsChargedAfterAuthentication(t *testing.T) {
+	stats := &Stats{}
+	key := WithClusterKey(testClusterKey)
+	receiver, datagrams := udpLimited(t, key, WithLimits(Limits{PeerRate: 0.1, PeerBurst: 1}), WithStats(stats))
+	// forged datagrams from the address of the peer
+	forger, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forger.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := forger.WriteTo([]byte{0, 1, 0, 0, 0, 1}, receiver.(*udp).conn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sendFrom(t, "127.0.0.1:0", receiver, 1, key)
+	testutil.Within(t, testTimeout, "the authentic datagram", func() {
+		if m, err := receiver.RecvFrom(<-datagrams); err != nil || m.(registryMsg).seq != 1 {
+			t.Errorf("received %v, %v; expected message 1", m, err)
+		}
+	})
+	if stats.UnauthenticatedFrames.Load() != 5 || stats.DroppedMessages.Load() != 0 {
+		t.Fatalf("%v unauthenticated and %v dropped datagrams, expected 5 unauthenticated",
+			stats.UnauthenticatedFrames.Load(), stats.DroppedMessages.Load())
+	}
+}
+
+func TestTcpLimitDisconnect(t *testing.T) {
+	server := NewTcpNet(log.StandardLogger(), WithLimits(Limits{PeerRate: 0.1, PeerBurst: 1, Policy: LimitDisconnect}))
+	server.RegisterMessage(registryMsg{code: 1})
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	client := NewTcpNet(log.StandardLogger())
+	conn, err := client.Open(server.(*tcp).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 1})
+	_ = client.SendTo(conn, registryMsg{code: 1, seq: 2})
+	if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+	if _, err := server.RecvFrom(accepted); err != errLimitDisconnect {
+		t.Fatalf("receiving the message over the rate failed with %v, expected errLimitDisconnect", err)
+	}
+}
+
+func TestTcpLimitsExemptHeartbeats(t *testing.T) {
+	server := NewTcpNet(log.StandardLogger(), WithLimits(Limits{PeerRate: 0.1, PeerBurst: 1, Policy: LimitDisconnect}))
+	server.RegisterMessage(registryMsg{code: 1})
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	conn, err := NewTcpNet(log.StandardLogger()).Open(server.(*tcp).listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+	for i := 0; i < 3; i++ {
+		if err := conn.Send(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = conn.Send([]byte{0, 1, 0, 0, 0, 1})
+	for i := 0; i < 3; i++ {
+		if b, err := accepted.Receive(); err != nil || len(b) != 0 {
+			t.Fatalf("received %v, %v; expected heartbeat %v", b, err, i)
+		}
+	}
+	if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1 within the rate", m, err)
+	}
+}
@@ -0,0 +1,315 @@
+package neti
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Reconnect configures how a ManagedConn re-dials its peer.
+type Reconnect struct {
+	MinBackoff time.Duration //Delay before the first retry, doubled on every failed attempt, 100ms if not set
+	MaxBackoff time.Duration //Maximum delay between attempts, 30s if not set
+	Jitter     float64       //Fraction of the delay randomly added or removed, so that peers do not retry in lockstep
+	QueueSize  int           //Messages queued while disconnected, sent once reconnected; 0 rejects sends while disconnected
+}
+
+// ErrDisconnected is returned by ManagedConn.Send when disconnected and the queue is full (or disabled).
+var ErrDisconnected = errors.New("managed connection is disconnected")
+
+// ErrManagedConnClosed is returned by the operations on a closed ManagedConn.
+var ErrManagedConnClosed = errors.New("managed connection is closed")
+
+// ReconnectEventType is the type of ReconnectEvent.
+type ReconnectEventType uint8
+
+const (
+	Connected    ReconnectEventType = iota + 1 //The connection is (re)established
+	Disconnected                               //The connection failed
+	Reconnecting                               //An attempt to dial failed, the next one is in Delay
+	Failed                                     //The peer rejected the connection for good, Err is why, and dialing stopped
+)
+
+// String returns the name of the event type.
+func (t ReconnectEventType) String() string {
+	switch t {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	case Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// ReconnectEvent reports a change in the state of a ManagedConn.
+type ReconnectEvent struct {
+	Type    ReconnectEventType
+	Conn    *ServiceHostConn //The new connection, for Connected
+	Attempt int              //Failed attempts since disconnected
+	Delay   time.Duration    //Delay until the next attempt, for Reconnecting
+	Err     error            //Why the connection or the attempt failed
+}
+
+const reconnectEventsBuffer = 16
+
+// ManagedConn is a connection of a NetClient that re-dials its peer in the background whenever it fails.
+type ManagedConn struct {
+	client NetClient
+	addr   string
+	id     string
+	config Reconnect
+	events chan ReconnectEvent
+
+	lock      *sync.Mutex
+	connected *sync.Cond
+	conn      *ServiceHostConn //nil while disconnected
+	queue     []Message
+	flushing  bool //Whether the queue is being sent on conn, messages sent meanwhile are queued after it
+	broken    chan struct{}
+	closed    bool
+	err       error //why dialing stopped, when the peer rejected the connection
+}
+
+// newManagedConn creates a ManagedConn and starts dialing, it is how NetClients implement OpenManaged.
+func newManagedConn(client NetClient, addr string, id string, config Reconnect) *ManagedConn {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+	m := &ManagedConn{
+		client: client,
+		addr:   addr,
+		id:     id,
+		config: config,
+		events: make(chan ReconnectEvent, reconnectEventsBuffer),
+		lock:   &sync.Mutex{},
+		broken: make(chan struct{}, 1),
+	}
+	m.connected = sync.NewCond(m.lock)
+	go m.dial()
+	return m
+}
+
+// String returns the string representation of the ManagedConn.
+func (m *ManagedConn) String() string {
+	return fmt.Sprintf("%v %v (managed)", m.id, m.addr)
+}
+
+// Events returns the channel of the reconnect events, events are dropped when it is full.
+func (m *ManagedConn) Events() <-chan ReconnectEvent {
+	return m.events
+}
+
+// Conn returns the current connection, nil while disconnected.
+func (m *ManagedConn) Conn() *ServiceHostConn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.conn
+}
+
+// Err returns the rejection that stopped the ManagedConn from dialing, nil while it is dialing or connected.
+func (m *ManagedConn) Err() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+// closedErr returns the error of the operations on a closed ManagedConn. The lock must be held.
+func (m *ManagedConn) closedErr() error {
+	if m.err != nil {
+		return m.err
+	}
+	return ErrManagedConnClosed
+}
+
+// Send sends the message on the current connection, or queues it while disconnected.
+// Once the peer rejected the connection for good, it returns the *HandshakeRejectedError.
+func (m *ManagedConn) Send(message Message) error {
+	m.lock.Lock()
+	if m.closed {
+		defer m.lock.Unlock()
+		return m.closedErr()
+	}
+	if m.conn == nil || m.flushing {
+		defer m.lock.Unlock()
+		if len(m.queue) >= m.config.QueueSize {
+			return ErrDisconnected
+		}
+		m.queue = append(m.queue, message)
+		return nil
+	}
+	conn := m.conn
+	m.lock.Unlock()
+	// the lock is not held while sending, so a stalled write does not block Close nor the dialer
+	if err := m.client.SendTo(conn, message); err != nil {
+		m.lock.Lock()
+		m.disconnect(conn, err)
+		m.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Receive receives the next message, waiting for the connection to be re-established when it fails.
+// Only stream services receive on connections, datagram services deliver messages on Accept.
+// Once the peer rejected the connection for good, it returns the *HandshakeRejectedError.
+func (m *ManagedConn) Receive() (Message, error) {
+	if m.client.Type() == UDP {
+		return nil, errors.New("datagram services deliver messages on Accept")
+	}
+	for {
+		m.lock.Lock()
+		for m.conn == nil && !m.closed {
+			m.connected.Wait()
+		}
+		conn, closed, err := m.conn, m.closed, m.closedErr()
+		m.lock.Unlock()
+		if closed {
+			return nil, err
+		}
+		msg, err := m.client.RecvFrom(conn)
+		var decodeErr *DecodeError
+		if err == nil || errors.As(err, &decodeErr) {
+			return msg, err
+		}
+		m.lock.Lock()
+		m.disconnect(conn, err)
+		m.lock.Unlock()
+	}
+}
+
+// Close closes the connection and stops re-dialing, queued messages are discarded.
+func (m *ManagedConn) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.queue = nil
+	m.connected.Broadcast()
+	close(m.broken)
+	if m.conn != nil {
+		return m.conn.Close()
+	}
+	return nil
+}
+
+// disconnect closes conn, if it is still the current connection, and wakes up the dialer. The lock must be held.
+func (m *ManagedConn) disconnect(conn *ServiceHostConn, err error) {
+	if m.conn != conn || m.closed {
+		return
+	}
+	m.conn = nil
+	_ = conn.Close()
+	m.emit(ReconnectEvent{Type: Disconnected, Err: err})
+	m.broken <- struct{}{}
+}
+
+func (m *ManagedConn) emit(e ReconnectEvent) {
+	select {
+	case m.events <- e:
+	default:
+	}
+}
+
+// fail stops the ManagedConn after the peer rejected the connection, discarding the queued messages.
+func (m *ManagedConn) fail(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	m.err = err
+	m.queue = nil
+	m.connected.Broadcast()
+	m.emit(ReconnectEvent{Type: Failed, Err: err})
+}
+
+// retryable returns whether dialing again may succeed after err: rejections of the handshake
+// (incompatible versions, unknown services, unauthorized peers) are not retried.
+func retryable(err error) bool {
+	var rejected *HandshakeRejectedError
+	return !errors.As(err, &rejected)
+}
+
+// dial (re)establishes the connection whenever it is broken, until the ManagedConn is closed
+// or the peer rejects the connection.
+func (m *ManagedConn) dial() {
+	for attempt := 0; ; {
+		conn, err := m.client.OpenTo(m.addr, m.id)
+		if err == nil && m.connect(conn) {
+			attempt = 0
+			if _, ok := <-m.broken; !ok {
+				return
+			}
+			continue
+		} else if err == nil {
+			return
+		} else if !retryable(err) {
+			m.fail(err)
+			return
+		}
+		attempt++
+		delay := m.backoff(attempt)
+		m.emit(ReconnectEvent{Type: Reconnecting, Attempt: attempt, Delay: delay, Err: err})
+		select {
+		case <-time.After(delay):
+		case <-m.broken:
+			return
+		}
+	}
+}
+
+// connect makes conn the current connection and sends the queued messages, returning false if the ManagedConn is closed.
+// The lock is not held while sending, the messages sent meanwhile are queued so that they follow the queued ones.
+func (m *ManagedConn) connect(conn *ServiceHostConn) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		_ = conn.Close()
+		return false
+	}
+	m.conn = conn
+	m.flushing = true
+	m.emit(ReconnectEvent{Type: Connected, Conn: conn})
+	for len(m.queue) > 0 && m.conn == conn {
+		message := m.queue[0]
+		m.lock.Unlock()
+		err := m.client.SendTo(conn, message)
+		m.lock.Lock()
+		if err != nil {
+			m.disconnect(conn, err)
+		} else if len(m.queue) > 0 {
+			m.queue = m.queue[1:]
+		}
+	}
+	m.flushing = false
+	m.connected.Broadcast()
+	return true
+}
+
+// backoff returns the delay before the next attempt: exponential in the attempts, bounded and with jitter.
+func (m *ManagedConn) backoff(attempt int) time.Duration {
+	delay := m.config.MinBackoff
+	for i := 1; i < attempt && delay < m.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > m.config.MaxBackoff {
+		delay = m.config.MaxBackoff
+	}
+	if m.config.Jitter > 0 {
+		delay += time.Duration(float64(delay) * m.config.Jitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
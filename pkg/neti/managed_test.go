@@ -0,0 +1,120 @@
+package neti
+
+import (
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next reconnect event of conn of the given type, skipping the others.
+func nextEvent(t *testing.T, conn *ManagedConn, eventType ReconnectEventType) ReconnectEvent {
+	t.Helper()
+	var event ReconnectEvent
+	testutil.Within(t, testTimeout, "a "+eventType.String()+" event", func() {
+		for e := range conn.Events() {
+			if e.Type == eventType {
+				event = e
+				return
+			}
+		}
+	})
+	return event
+}
+
+func TestManagedConnBackoff(t *testing.T) {
+	m := &ManagedConn{config: Reconnect{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	for attempt, expected := range []time.Duration{100, 100, 200, 400, 800, 1000, 1000} {
+		if delay := m.backoff(attempt); delay != expected*time.Millisecond {
+			t.Fatalf("attempt %v waits %v, expected %vms", attempt, delay, expected)
+		}
+	}
+	m.config.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := m.backoff(3); delay < 200*time.Millisecond || delay > 600*time.Millisecond {
+			t.Fatalf("waits %v with a jitter of 0.5, expected 200ms-600ms", delay)
+		}
+	}
+}
+
+func TestManagedConnReconnects(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+	conn := senderService.RegisterListener("sender").OpenManaged(addr, "receiver", Reconnect{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, QueueSize: 1})
+	defer conn.Close()
+	if err := conn.Send(registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Send(registryMsg{code: 1, seq: 2}); err != ErrDisconnected {
+		t.Fatalf("sending over the queue size failed with %v, expected ErrDisconnected", err)
+	}
+	// the peer is not up yet
+	nextEvent(t, conn, Reconnecting)
+
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+	nextEvent(t, conn, Connected)
+	testutil.Within(t, testTimeout, "the queued message", func() {
+		if m, err := receiver.RecvFrom(<-receiver.Accept()); err != nil || m.(registryMsg).seq != 1 {
+			t.Errorf("received %v, %v; expected the queued message", m, err)
+		}
+	})
+}
+
+func TestManagedConnStopsOnRejection(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+	conn := senderService.RegisterListener("sender").OpenManaged(addr, "missing", Reconnect{MinBackoff: 10 * time.Millisecond, QueueSize: 1})
+	defer conn.Close()
+
+	var rejected *HandshakeRejectedError
+	if e := nextEvent(t, conn, Failed); !errors.As(e.Err, &rejected) || rejected.Reason != RejectUnknownService {
+		t.Fatalf("failed with %v, expected the rejection of the unknown service", e.Err)
+	}
+	if !errors.As(conn.Err(), &rejected) {
+		t.Fatalf("Err() = %v, expected the rejection", conn.Err())
+	}
+	if err := conn.Send(registryMsg{code: 1}); !errors.As(err, &rejected) {
+		t.Fatalf("sending failed with %v, expected the rejection", err)
+	}
+	testutil.Within(t, testTimeout, "Receive to return", func() {
+		if _, err := conn.Receive(); !errors.As(err, &rejected) {
+			t.Errorf("receiving failed with %v, expected the rejection", err)
+		}
+	})
+}
+
+func TestManagedConnCloseWhileSending(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+	conn := senderService.RegisterListener("sender").OpenManaged(addr, "receiver", Reconnect{MinBackoff: 10 * time.Millisecond})
+	nextEvent(t, conn, Connected)
+	testutil.Within(t, testTimeout, "the connection", func() { <-receiver.Accept() })
+
+	// the receiver never reads, so the writes stall once the buffers of the connection are full
+	big := textMsg{text: strings.Repeat("x", 60000)}
+	sent := make(chan error, 1)
+	go func() {
+		for {
+			if err := conn.Send(big); err != nil {
+				sent <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	testutil.Within(t, testTimeout, "Close", func() { _ = conn.Close() })
+	testutil.Within(t, testTimeout, "the stalled write to fail", func() { <-sent })
+}
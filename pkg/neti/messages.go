@@ -2,15 +2,33 @@ package neti
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 )
 
+// DecodeError is returned when a message is received but cannot be deserialized, the connection is still usable.
+type DecodeError struct {
+	Code      uint16 //Code of the message
+	ServiceId string //Id of the listener receiving the message
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Unable to deserialize message with code %v: %v", e.Code, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 // MessageWrap is a wrapper for messages that are sent over the network.
 type MessageWrap struct {
-	Id   string
-	Msg  Message
-	code uint16
-	buff *bytes.Buffer
+	Id    string
+	Msg   Message
+	code  uint16
+	buff  *bytes.Buffer
+	trace SpanContext //Span of the message, sent when valid
+	size  int         //Size of the frame it was received in
 }
 
 // String returns a string representation of the message.
@@ -23,11 +41,19 @@ func (m MessageWrap) Name() string {
 	return "MessageWrap"
 }
 
-// Code returns the code of the message.
+// Code returns the code of the message, messages carrying a span context have their own code.
 func (m MessageWrap) Code() uint16 {
+	if m.trace.IsValid() {
+		return tracedWrapCode
+	}
 	return 0
 }
 
+// SpanContext returns the span context carried by the message, if any.
+func (m MessageWrap) SpanContext() SpanContext {
+	return m.trace
+}
+
 // Buff returns the buffer of the message.
 func (m MessageWrap) Buff() *bytes.Buffer {
 	return m.buff
@@ -38,28 +64,62 @@ func (m MessageWrap) MessageCode() uint16 {
 	return m.code
 }
 
-// Serialize serializes the message.
+// Serialize serializes the message, or the payload it was deserialized with (or given by a NetClient) if any.
 func (m MessageWrap) Serialize(buff *bytes.Buffer) error {
-	_ = EncodeStringToBuffer(m.Id, buff)
-	_ = EncodeNumberToBuffer(m.Msg.Code(), buff)
-	if err := m.Msg.Serialize(buff); err != nil {
-		panic(err)
+	if m.trace.IsValid() {
+		encodeSpanContext(m.trace, buff)
+	}
+	if err := EncodeStringToBuffer(m.Id, buff); err != nil {
 		return err
 	}
-
-	return nil
+	if m.buff != nil {
+		_ = EncodeNumberToBuffer(m.code, buff)
+		return writeFully(buff, m.buff.Bytes())
+	}
+	_ = EncodeNumberToBuffer(m.Msg.Code(), buff)
+	return m.Msg.Serialize(buff)
 }
 
-// Deserialize deserializes the message.
+// Deserialize deserializes the message, the payload is deserialized by the NetClient receiving it.
 func (m MessageWrap) Deserialize(buff *bytes.Buffer) (Message, error) {
-	m.Id, _ = DecodeStringFromBuffer(buff)
-	_ = DecodeNumberFromBuffer(&m.code, buff)
-	//msg, err := m.Msg.Deserialize(buff)
-	//if err != nil {
-	//	panic(err)
-	//	return nil, err
-	//}
-	//m.Msg = msg
+	var err error
+	m.size = binary.Size(m.Code()) + buff.Len()
+	if m.Id, err = DecodeStringFromBuffer(buff); err != nil {
+		return nil, err
+	}
+	if err = DecodeNumberFromBuffer(&m.code, buff); err != nil {
+		return nil, err
+	}
 	m.buff = buff
 	return m, nil
 }
+
+const tracedWrapCode uint16 = 1
+
+// tracedMessageWrap deserializes the MessageWraps carrying a span context, which are sent with their own code
+// so that peers unaware of tracing reject them instead of misreading them.
+type tracedMessageWrap struct {
+	MessageWrap
+}
+
+// Code returns the code of the MessageWraps carrying a span context.
+func (t tracedMessageWrap) Code() uint16 {
+	return tracedWrapCode
+}
+
+// Deserialize deserializes a MessageWrap preceded by its span context.
+func (t tracedMessageWrap) Deserialize(buff *bytes.Buffer) (Message, error) {
+	size := binary.Size(t.Code()) + buff.Len()
+	trace, err := decodeSpanContext(buff)
+	if err != nil {
+		return nil, err
+	}
+	m, err := t.MessageWrap.Deserialize(buff)
+	if err != nil {
+		return nil, err
+	}
+	wrap := m.(MessageWrap)
+	wrap.trace = trace
+	wrap.size = size
+	return wrap, nil
+}
@@ -0,0 +1,271 @@
+package neti
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects the metrics of the Nets and NetServices it is set on (see WithMetrics),
+// and exposes them in the Prometheus text format through ServeHTTP, so that it can be mounted on an http server.
+// It is safe for concurrent use, pass the same Metrics to many Nets and NetServices to aggregate them.
+//
+// The metrics are:
+//
+//	neti_messages_sent_total, neti_bytes_sent_total           counters by network, service and code
+//	neti_messages_received_total, neti_bytes_received_total   counters by network, service and code
+//	neti_decode_errors_total                                  counter by network, service and code
+//	neti_dropped_messages_total                               counter by network, service and reason
+//	neti_open_connections                                     gauge by network
+//	neti_dial_duration_seconds, neti_send_duration_seconds    histograms by network
+//
+// Bytes are those of the frames (the code of the message followed by its payload), without the framing,
+// authentication and compression overhead. The service is the id of the NetClient, empty for messages sent on a Net.
+// The messages of services are counted as received once delivered to a NetClient, after their authorization,
+// so that the series are bounded by the NetClients registered rather than by the ids that peers send.
+type Metrics struct {
+	lock     *sync.Mutex
+	families map[string]*metricFamily
+	buses    map[*EventBus]bool //buses the Metrics is subscribed to, so that sharing a bus does not count twice
+}
+
+// Buckets of the latency histograms, in seconds.
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type metricKind string
+
+const (
+	counterMetric   metricKind = "counter"
+	gaugeMetric     metricKind = "gauge"
+	histogramMetric metricKind = "histogram"
+)
+
+type metricFamily struct {
+	name   string
+	help   string
+	kind   metricKind
+	labels []string
+	series map[string]*metricSeries //by the label values, joined
+}
+
+type metricSeries struct {
+	labels  []string
+	value   float64  //value of counters and gauges, sum of histograms
+	buckets []uint64 //counts of histograms, per bucket of latencyBuckets
+	count   uint64
+}
+
+// NewMetrics creates Metrics without any samples.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		lock:     &sync.Mutex{},
+		families: make(map[string]*metricFamily),
+		buses:    make(map[*EventBus]bool),
+	}
+	traffic := []string{"network", "service", "code"}
+	m.family("neti_messages_sent_total", "Messages sent.", counterMetric, traffic...)
+	m.family("neti_bytes_sent_total", "Bytes of the messages sent.", counterMetric, traffic...)
+	m.family("neti_messages_received_total", "Messages received.", counterMetric, traffic...)
+	m.family("neti_bytes_received_total", "Bytes of the messages received.", counterMetric, traffic...)
+	m.family("neti_decode_errors_total", "Messages received that could not be deserialized.", counterMetric, traffic...)
+	m.family("neti_dropped_messages_total", "Frames and messages received that were dropped.", counterMetric, "network", "service", "reason")
+	m.family("neti_open_connections", "Connections currently open.", gaugeMetric, "network")
+	m.family("neti_dial_duration_seconds", "Time to open a connection of a stream Net, including the authentication handshake.", histogramMetric, "network")
+	m.family("neti_send_duration_seconds", "Time to send a message on a connection.", histogramMetric, "network")
+	return m
+}
+
+// WithMetrics sets the Metrics updated by a Net or NetService.
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+	}
+}
+
+func (m *Metrics) family(name string, help string, kind metricKind, labels ...string) {
+	m.families[name] = &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+}
+
+// observe adds v to the series of the metric name with the label values, or to its histogram.
+func (m *Metrics) observe(name string, v float64, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f := m.families[name]
+	key := strings.Join(labels, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: labels}
+		if f.kind == histogramMetric {
+			s.buckets = make([]uint64, len(latencyBuckets))
+		}
+		f.series[key] = s
+	}
+	s.value += v
+	if f.kind == histogramMetric {
+		s.count++
+		for i, b := range latencyBuckets {
+			if v <= b {
+				s.buckets[i]++
+			}
+		}
+	}
+}
+
+// sent records a message sent on conn, serialized in a frame of size bytes, and the time it took.
+func (m *Metrics) sent(conn HostConn, message Message, size int, took time.Duration) {
+	if m == nil {
+		return
+	}
+	network, service, code := trafficLabels(conn, message)
+	m.observe("neti_messages_sent_total", 1, network, service, code)
+	m.observe("neti_bytes_sent_total", float64(size), network, service, code)
+	m.observe("neti_send_duration_seconds", took.Seconds(), network)
+}
+
+// received records a message received on conn in a frame of size bytes.
+// The messages of services are recorded once delivered, see delivered.
+func (m *Metrics) received(conn HostConn, message Message, size int) {
+	if _, ok := message.(MessageWrap); ok || m == nil {
+		return
+	}
+	network, service, code := trafficLabels(conn, message)
+	m.observe("neti_messages_received_total", 1, network, service, code)
+	m.observe("neti_bytes_received_total", float64(size), network, service, code)
+}
+
+// delivered records the message wrap received on conn and delivered to the NetClient service.
+func (m *Metrics) delivered(conn HostConn, service string, wrap MessageWrap) {
+	if m == nil {
+		return
+	}
+	network, code := conn.Addr().Network(), strconv.Itoa(int(wrap.code))
+	m.observe("neti_messages_received_total", 1, network, service, code)
+	m.observe("neti_bytes_received_total", float64(wrap.size), network, service, code)
+}
+
+// dialed records a connection opened to network, and the time it took.
+func (m *Metrics) dialed(network string, took time.Duration) {
+	m.observe("neti_dial_duration_seconds", took.Seconds(), network)
+}
+
+// connections adds delta to the connections open on network.
+func (m *Metrics) connections(network string, delta float64) {
+	m.observe("neti_open_connections", delta, network)
+}
+
+// subscribe counts the decode errors and dropped messages published on bus.
+func (m *Metrics) subscribe(bus *EventBus) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.buses[bus] {
+		return
+	}
+	m.buses[bus] = true
+	bus.SubscribeFunc(m.event)
+}
+
+func (m *Metrics) event(e Event) {
+	switch e.Type {
+	case DecodeFailed:
+		m.observe("neti_decode_errors_total", 1, e.Network, e.ServiceId, strconv.Itoa(int(e.Code)))
+	case MessageDropped:
+		m.observe("neti_dropped_messages_total", 1, e.Network, e.ServiceId, dropReason(e.Err))
+	}
+}
+
+// trafficLabels returns the network, service and code labels of a message on conn.
+// The messages of services are wrapped, their service is the id of the NetClient sending them and their code the one of the wrapped message.
+func trafficLabels(conn HostConn, message Message) (string, string, string) {
+	service, code := "", message.Code()
+	if wrap, ok := message.(MessageWrap); ok {
+		service, code = wrap.Id, wrap.MessageCode()
+	}
+	return conn.Addr().Network(), service, strconv.Itoa(int(code))
+}
+
+func dropReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrUnauthenticated):
+		return "unauthenticated"
+	case errors.Is(err, ErrUndecryptable):
+		return "undecryptable"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	default:
+		return "invalid"
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(writer)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	buff := new(bytes.Buffer)
+	m.lock.Lock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.families[name].write(buff)
+	}
+	m.lock.Unlock()
+	return buff.WriteTo(w)
+}
+
+func (f *metricFamily) write(buff *bytes.Buffer) {
+	if len(f.series) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(buff, "# HELP %v %v\n# TYPE %v %v\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.labelPairs(s.labels)
+		if f.kind != histogramMetric {
+			_, _ = fmt.Fprintf(buff, "%v{%v} %v\n", f.name, labels, formatValue(s.value))
+			continue
+		}
+		for i, b := range latencyBuckets {
+			_, _ = fmt.Fprintf(buff, "%v_bucket{%v,le=\"%v\"} %v\n", f.name, labels, formatValue(b), s.buckets[i])
+		}
+		_, _ = fmt.Fprintf(buff, "%v_bucket{%v,le=\"+Inf\"} %v\n", f.name, labels, s.count)
+		_, _ = fmt.Fprintf(buff, "%v_sum{%v} %v\n", f.name, labels, formatValue(s.value))
+		_, _ = fmt.Fprintf(buff, "%v_count{%v} %v\n", f.name, labels, s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (f *metricFamily) labelPairs(values []string) string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + "=\"" + labelEscaper.Replace(v) + "\""
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
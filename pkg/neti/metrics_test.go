@@ -0,0 +1,120 @@
+package neti
+
+import (
+	"bytes"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sample returns the line of the series of metrics starting with prefix, empty if there is none.
+func sample(t *testing.T, metrics *Metrics, prefix string) string {
+	t.Helper()
+	buff := new(bytes.Buffer)
+	if _, err := metrics.WriteTo(buff); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buff.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestMetricsWriteTo(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.connections("tcp", 1)
+	metrics.connections("tcp", 1)
+	metrics.connections("tcp", -1)
+	metrics.dialed("tcp", 2*time.Millisecond)
+	metrics.observe("neti_dropped_messages_total", 1, "udp", "a \"quoted\"\nid", "invalid")
+	for prefix, expected := range map[string]string{
+		"neti_open_connections{": `neti_open_connections{network="tcp"} 1`,
+		`neti_dial_duration_seconds_bucket{network="tcp",le="0.001"}`: `neti_dial_duration_seconds_bucket{network="tcp",le="0.001"} 0`,
+		`neti_dial_duration_seconds_bucket{network="tcp",le="0.005"}`: `neti_dial_duration_seconds_bucket{network="tcp",le="0.005"} 1`,
+		"neti_dial_duration_seconds_count":                            `neti_dial_duration_seconds_count{network="tcp"} 1`,
+		"neti_dropped_messages_total{":                                `neti_dropped_messages_total{network="udp",service="a \"quoted\"\nid",reason="invalid"} 1`,
+		"# TYPE neti_open_connections":                                "# TYPE neti_open_connections gauge",
+	} {
+		if line := sample(t, metrics, prefix); line != expected {
+			t.Fatalf("%q, expected %q", line, expected)
+		}
+	}
+	if line := sample(t, metrics, "# HELP neti_messages_sent_total"); line != "" {
+		t.Fatalf("wrote %q, expected metrics without series to be omitted", line)
+	}
+}
+
+func TestMetricsServiceLabels(t *testing.T) {
+	senderMetrics, receiverMetrics := NewMetrics(), NewMetrics()
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger(), WithMetrics(receiverMetrics))
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), WithMetrics(senderMetrics))
+	defer senderService.Close()
+	conn, err := senderService.RegisterListener("sender").OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := senderService.RegisterListener("sender").SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Within(t, testTimeout, "the message", func() {
+		if _, err := receiver.RecvFrom(<-receiver.Accept()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if line := sample(t, senderMetrics, "neti_messages_sent_total{"); line != `neti_messages_sent_total{network="tcp",service="sender",code="1"} 1` {
+		t.Fatalf("%q, expected the message sent by the sender", line)
+	}
+	// the series of the receiver are labelled with its own id, not with the one sent by the peer
+	if line := sample(t, receiverMetrics, "neti_messages_received_total{"); line != `neti_messages_received_total{network="tcp",service="receiver",code="1"} 1` {
+		t.Fatalf("%q, expected the message received by the receiver", line)
+	}
+	sent := strings.TrimPrefix(sample(t, senderMetrics, "neti_bytes_sent_total{"), `neti_bytes_sent_total{network="tcp",service="sender",code="1"}`)
+	received := strings.TrimPrefix(sample(t, receiverMetrics, "neti_bytes_received_total{"), `neti_bytes_received_total{network="tcp",service="receiver",code="1"}`)
+	if sent == "" || sent != received {
+		t.Fatalf("sent %q bytes and received %q, expected the size of the frame in both", sent, received)
+	}
+}
+
+func TestMetricsUdpUnauthorized(t *testing.T) {
+	deny, err := NewDenyList("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16)
+	defer cancel()
+	metrics := NewMetrics()
+	addr := freeAddr(t, "udp")
+	service := InitBaseUdpService(addr, 1024, WithAuthorizer(deny), WithMetrics(metrics), WithEvents(bus))
+	defer service.Close()
+	service.RegisterListener("admin").RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseUdpService(freeAddr(t, "udp"), 1024)
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+	for _, id := range []string{"admin", "unknown"} {
+		conn, err := sender.OpenTo(addr, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = sender.SendTo(conn, registryMsg{code: 1})
+	}
+	expectEvents(t, events, MessageDropped)
+	expectEvents(t, events, UnknownService)
+
+	if line := sample(t, metrics, "neti_messages_received_total"); line != "" {
+		t.Fatalf("%q, expected the datagrams that were not delivered not to be counted", line)
+	}
+	if line := sample(t, metrics, "neti_dropped_messages_total{"); line != `neti_dropped_messages_total{network="udp",service="admin",reason="unauthorized"} 1` {
+		t.Fatalf("%q, expected the unauthorized datagram", line)
+	}
+}
@@ -0,0 +1,287 @@
+package nettest
+
+import (
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// NetConfig describes a Net implementation to TestNet.
+type NetConfig struct {
+	New      func(t *testing.T) neti.Net //Creates a Net, the suite creates one listening and one dialing it per test
+	Addr     func(t *testing.T) string   //Returns a free address to listen on, e.g. LocalAddr or UnixAddr
+	Datagram bool                        //Messages arrive each as a HostConn of the listener, and Open requires Listen (e.g. UDP)
+	MaxSize  int                         //Payload size of the largest message, 1MB if not set (keep it under the buffer size of datagrams)
+}
+
+// TestNet runs the conformance suite against the Net of config.
+func TestNet(t *testing.T, config NetConfig) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 1 << 20
+	}
+	t.Run("RoundTrip", func(t *testing.T) { testNetRoundTrip(t, config) })
+	t.Run("ConcurrentSenders", func(t *testing.T) { testNetConcurrentSenders(t, config) })
+	t.Run("LargeMessages", func(t *testing.T) { testNetLargeMessages(t, config) })
+	t.Run("UnknownCode", func(t *testing.T) { testNetUnknownCode(t, config) })
+	t.Run("CloseConnection", func(t *testing.T) { testNetCloseConnection(t, config) })
+	t.Run("ListenerShutdown", func(t *testing.T) { testNetListenerShutdown(t, config) })
+}
+
+// netPair is a listening Net and a Net with a connection to it.
+type netPair struct {
+	config  NetConfig
+	server  neti.Net
+	client  neti.Net
+	addr    string
+	conns   <-chan neti.HostConn //Connections (or datagrams) of the server
+	conn    neti.HostConn        //Connection of the client to the server
+	replies <-chan neti.HostConn //Datagrams of the client
+	errs    chan error           //Errors of the echo server
+}
+
+func newNetPair(t *testing.T, config NetConfig) *netPair {
+	t.Helper()
+	p := &netPair{config: config, server: config.New(t), client: config.New(t), addr: config.Addr(t), errs: make(chan error, 16)}
+	p.server.RegisterMessage(message{code: echoCode})
+	p.client.RegisterMessage(message{code: echoCode})
+	p.client.RegisterMessage(message{code: unknownCode})
+	var err error
+	if p.conns, err = p.server.Listen(p.addr); err != nil {
+		t.Fatalf("Listen(%v): %v", p.addr, err)
+	}
+	t.Cleanup(func() { _ = p.server.CloseListener() })
+	if config.Datagram {
+		addr := config.Addr(t)
+		if p.replies, err = p.client.Listen(addr); err != nil {
+			t.Fatalf("Listen(%v): %v", addr, err)
+		}
+		t.Cleanup(func() { _ = p.client.CloseListener() })
+	}
+	if p.conn, err = p.client.Open(p.addr); err != nil {
+		t.Fatalf("Open(%v): %v", p.addr, err)
+	}
+	t.Cleanup(func() { _ = p.conn.Close() })
+	return p
+}
+
+// echo echoes the messages received by the server, reporting the errors of receiving them.
+func (p *netPair) echo() {
+	report := func(err error) {
+		select {
+		case p.errs <- err:
+		default:
+		}
+	}
+	go func() {
+		for conn := range p.conns {
+			if p.config.Datagram {
+				if m, err := p.server.RecvFrom(conn); err != nil {
+					report(err)
+				} else {
+					_ = p.server.SendTo(conn, m)
+				}
+				continue
+			}
+			go func(conn neti.HostConn) {
+				for {
+					m, err := p.server.RecvFrom(conn)
+					if closed(err) {
+						return
+					} else if err != nil {
+						report(err)
+						continue
+					}
+					if err = p.server.SendTo(conn, m); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+}
+
+// recv receives the next message of the client.
+func (p *netPair) recv() received {
+	if !p.config.Datagram {
+		m, err := p.client.RecvFrom(p.conn)
+		return received{m, err}
+	}
+	conn, ok := <-p.replies
+	if !ok {
+		return received{nil, io.EOF}
+	}
+	m, err := p.client.RecvFrom(conn)
+	return received{m, err}
+}
+
+// accept returns the next connection of the server.
+func (p *netPair) accept(t *testing.T) neti.HostConn {
+	t.Helper()
+	var conn neti.HostConn
+	testutil.Within(t, Timeout, "a connection", func() { conn = <-p.conns })
+	if conn == nil {
+		t.Fatal("listener closed before accepting the connection")
+	}
+	return conn
+}
+
+// closed returns whether err is the error of receiving on a closed connection.
+func closed(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+func testNetRoundTrip(t *testing.T, config NetConfig) {
+	p := newNetPair(t, config)
+	p.echo()
+	var sent []message
+	for i := 0; i < 100; i++ {
+		sent = append(sent, newMessage(echoCode, uint32(i), i%64))
+	}
+	// the messages are sent while receiving the replies, a sender blocked on a full socket would block the echo
+	w := newWindow(config.Datagram)
+	failed := make(chan error, 1)
+	go func() {
+		defer close(failed)
+		for _, m := range sent {
+			w.acquire()
+			if err := p.client.SendTo(p.conn, m); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+	if config.Datagram {
+		collect(t, sent, w.release(p.recv))
+	} else {
+		// streams keep the order of the messages
+		for _, m := range sent {
+			var r received
+			testutil.Within(t, Timeout, m.String(), func() { r = p.recv() })
+			if r.err != nil || !m.equal(r.msg) {
+				t.Fatalf("received %v, %v; expected %v", r.msg, r.err, m)
+			}
+		}
+	}
+	if err := <-failed; err != nil {
+		t.Fatalf("SendTo: %v", err)
+	}
+}
+
+func testNetConcurrentSenders(t *testing.T, config NetConfig) {
+	p := newNetPair(t, config)
+	p.echo()
+	const senders, messages = 8, 50
+	var sent []message
+	for i := 0; i < senders*messages; i++ {
+		sent = append(sent, newMessage(echoCode, uint32(i), 100+i%1000))
+	}
+	w := newWindow(config.Datagram)
+	failed := make(chan error, senders)
+	wg := &sync.WaitGroup{}
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for _, m := range sent[s*messages : (s+1)*messages] {
+				w.acquire()
+				if err := p.client.SendTo(p.conn, m); err != nil {
+					failed <- err
+					return
+				}
+			}
+		}(s)
+	}
+	collect(t, sent, w.release(p.recv))
+	wg.Wait()
+	close(failed)
+	for err := range failed {
+		t.Fatalf("SendTo: %v", err)
+	}
+}
+
+func testNetLargeMessages(t *testing.T, config NetConfig) {
+	p := newNetPair(t, config)
+	p.echo()
+	for i, size := range []int{0, 1, 1 << 10, 16 << 10, config.MaxSize} {
+		m := newMessage(echoCode, uint32(i), size)
+		if err := p.client.SendTo(p.conn, m); err != nil {
+			t.Fatalf("SendTo(%v): %v", m, err)
+		}
+		collect(t, []message{m}, p.recv)
+	}
+}
+
+func testNetUnknownCode(t *testing.T, config NetConfig) {
+	p := newNetPair(t, config)
+	p.echo()
+	if err := p.client.SendTo(p.conn, newMessage(unknownCode, 0, 10)); err != nil {
+		t.Fatalf("SendTo: %v", err)
+	}
+	var err error
+	testutil.Within(t, Timeout, "the error of the unknown code", func() { err = <-p.errs })
+	if err == nil {
+		t.Fatal("receiving a message with an unknown code did not fail")
+	}
+	// the connection is still usable
+	m := newMessage(echoCode, 1, 10)
+	if err := p.client.SendTo(p.conn, m); err != nil {
+		t.Fatalf("SendTo(%v): %v", m, err)
+	}
+	collect(t, []message{m}, p.recv)
+}
+
+func testNetCloseConnection(t *testing.T, config NetConfig) {
+	if config.Datagram {
+		t.Skip("datagrams have no connections")
+	}
+	t.Run("ByClient", func(t *testing.T) {
+		p := newNetPair(t, config)
+		accepted := p.accept(t)
+		if err := p.conn.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		var err error
+		testutil.Within(t, Timeout, "the receive on the closed connection to fail", func() { _, err = p.server.RecvFrom(accepted) })
+		if err == nil {
+			t.Fatal("receiving on a connection closed by the peer succeeded")
+		}
+		if err = p.client.SendTo(p.conn, newMessage(echoCode, 0, 10)); err == nil {
+			t.Fatal("sending on a closed connection succeeded")
+		}
+	})
+	t.Run("ByServer", func(t *testing.T) {
+		p := newNetPair(t, config)
+		accepted := p.accept(t)
+		if err := accepted.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		var err error
+		testutil.Within(t, Timeout, "the receive on the closed connection to fail", func() { _, err = p.client.RecvFrom(p.conn) })
+		if err == nil {
+			t.Fatal("receiving on a connection closed by the peer succeeded")
+		}
+	})
+}
+
+func testNetListenerShutdown(t *testing.T, config NetConfig) {
+	p := newNetPair(t, config)
+	if err := p.server.CloseListener(); err != nil {
+		t.Fatalf("CloseListener: %v", err)
+	}
+	testutil.Within(t, Timeout, "the listener to close its channel", func() {
+		for range p.conns {
+		}
+	})
+	if config.Datagram {
+		return
+	}
+	if conn, err := p.client.Open(p.addr); err == nil {
+		_ = conn.Close()
+		t.Fatal("Open succeeded after the listener was closed")
+	}
+}
@@ -0,0 +1,182 @@
+// Package nettest provides a conformance suite for implementations of neti.Net and neti.NetService.
+//
+// TestNet and TestService run the suite against an implementation as subtests: round trips, concurrent senders,
+// large messages, unknown message codes, and the semantics of closing connections and listeners.
+// The implementations of neti run it in this package's tests, other implementations run it in theirs:
+//
+//	func TestMyNet(t *testing.T) {
+//		nettest.TestNet(t, nettest.NetConfig{
+//			New:  func(t *testing.T) neti.Net { return NewMyNet() },
+//			Addr: nettest.LocalAddr,
+//		})
+//	}
+package nettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Timeout bounds every wait of the suite, a message or an event not seen in time fails the test.
+var Timeout = 5 * time.Second
+
+// LocalAddr returns a free TCP and UDP address on the loopback.
+func LocalAddr(t *testing.T) string {
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		_ = l.Close()
+		// the port must be free for UDP too, as services of both transports are tested
+		if p, err := net.ListenPacket("udp", addr); err == nil {
+			_ = p.Close()
+			return addr
+		}
+	}
+	t.Fatal("no free port on the loopback")
+	return ""
+}
+
+// UnixAddr returns the path of a socket in a temporary directory of the test, removed at its end.
+func UnixAddr(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "nettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "sock")
+}
+
+// message is the message of the suite, its checksum detects payloads corrupted by interleaved or truncated frames.
+type message struct {
+	code    uint16
+	seq     uint32
+	payload []byte
+}
+
+func newMessage(code uint16, seq uint32, size int) message {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(int(seq) + i)
+	}
+	return message{code: code, seq: seq, payload: payload}
+}
+
+func (m message) String() string {
+	return fmt.Sprintf("%v{code=%v, seq=%v, %v bytes}", m.Name(), m.code, m.seq, len(m.payload))
+}
+
+func (m message) Name() string {
+	return "nettest.message"
+}
+
+func (m message) Code() uint16 {
+	return m.code
+}
+
+func (m message) Serialize(buff *bytes.Buffer) error {
+	var header [12]byte
+	binary.BigEndian.PutUint32(header[:4], m.seq)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(m.payload)))
+	binary.BigEndian.PutUint32(header[8:], crc32.ChecksumIEEE(m.payload))
+	buff.Write(header[:])
+	buff.Write(m.payload)
+	return nil
+}
+
+func (m message) Deserialize(buff *bytes.Buffer) (neti.Message, error) {
+	header := buff.Next(12)
+	if len(header) != 12 {
+		return nil, errors.New("message too short")
+	}
+	size := binary.BigEndian.Uint32(header[4:8])
+	if int(size) != buff.Len() {
+		return nil, errors.New(fmt.Sprintf("payload of %v bytes, expected %v", buff.Len(), size))
+	}
+	payload := append([]byte(nil), buff.Bytes()...)
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[8:]) {
+		return nil, errors.New("corrupted payload")
+	}
+	return message{code: m.code, seq: binary.BigEndian.Uint32(header[:4]), payload: payload}, nil
+}
+
+// equal returns whether m and o have the same code, sequence number and payload.
+func (m message) equal(o neti.Message) bool {
+	other, ok := o.(message)
+	return ok && other.code == m.code && other.seq == m.seq && bytes.Equal(other.payload, m.payload)
+}
+
+const (
+	echoCode    uint16 = 100 //Echoed back
+	unknownCode uint16 = 101 //Only registered by the senders
+)
+
+// window bounds the messages in flight of datagrams, dropped by the receiving socket once its buffer is full.
+// The window of streams is nil, and does not bound them.
+type window chan struct{}
+
+func newWindow(datagram bool) window {
+	if !datagram {
+		return nil
+	}
+	return make(window, 32)
+}
+
+func (w window) acquire() {
+	if w != nil {
+		w <- struct{}{}
+	}
+}
+
+// release returns recv, releasing a message of the window after each receive.
+func (w window) release(recv func() received) func() received {
+	return func() received {
+		r := recv()
+		if w != nil {
+			<-w
+		}
+		return r
+	}
+}
+
+// received is a message received, or the error of receiving it.
+type received struct {
+	msg neti.Message
+	err error
+}
+
+// collect checks that the messages received are expected, in any order, failing on duplicates and unknown messages.
+func collect(t *testing.T, expected []message, recv func() received) {
+	t.Helper()
+	pending := make(map[uint32]message, len(expected))
+	for _, m := range expected {
+		pending[m.seq] = m
+	}
+	for len(pending) > 0 {
+		var r received
+		testutil.Within(t, Timeout, fmt.Sprintf("%v more messages", len(pending)), func() { r = recv() })
+		if r.err != nil {
+			t.Fatalf("receive failed with %v pending messages: %v", len(pending), r.err)
+		}
+		m, ok := r.msg.(message)
+		if !ok {
+			t.Fatalf("received %v, expected a nettest message", r.msg)
+		}
+		if e, ok := pending[m.seq]; !ok || !e.equal(m) {
+			t.Fatalf("received unexpected or duplicated %v", m)
+		}
+		delete(pending, m.seq)
+	}
+}
@@ -0,0 +1,100 @@
+package nettest_test
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/nettest"
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+func TestTcpNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:  func(t *testing.T) neti.Net { return neti.NewTcpNet(logrus.StandardLogger()) },
+		Addr: nettest.LocalAddr,
+	})
+}
+
+func TestUnixNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:  func(t *testing.T) neti.Net { return neti.NewUnixNet(logrus.StandardLogger()) },
+		Addr: nettest.UnixAddr,
+	})
+}
+
+func TestUdpNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:      func(t *testing.T) neti.Net { return neti.NewUdpNet(64 * 1024) },
+		Addr:     nettest.LocalAddr,
+		Datagram: true,
+		MaxSize:  60 * 1024,
+	})
+}
+
+func TestUnixgramNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:      func(t *testing.T) neti.Net { return neti.NewUnixgramNet(64 * 1024) },
+		Addr:     nettest.UnixAddr,
+		Datagram: true,
+		MaxSize:  8 * 1024,
+	})
+}
+
+func TestTcpService(t *testing.T) {
+	nettest.TestService(t, nettest.ServiceConfig{
+		New: func(t *testing.T) neti.NetService {
+			return neti.InitBaseTcpService(nettest.LocalAddr(t), logrus.StandardLogger())
+		},
+	})
+}
+
+func TestUdpService(t *testing.T) {
+	nettest.TestService(t, nettest.ServiceConfig{
+		New: func(t *testing.T) neti.NetService {
+			return neti.InitBaseUdpService(nettest.LocalAddr(t), 64*1024)
+		},
+	})
+}
+
+func TestSimService(t *testing.T) {
+	// the simulated network is a single service, its NetClients are addressed by their ids
+	sim := neti.NewSimUDPService()
+	nettest.TestService(t, nettest.ServiceConfig{
+		New:          func(t *testing.T) neti.NetService { return sim },
+		Unserialized: true,
+	})
+}
+
+// wsAddr returns the url of a free address on the loopback.
+func wsAddr(t *testing.T) string {
+	return "ws://" + nettest.LocalAddr(t) + "/neti"
+}
+
+func TestWsNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:  func(t *testing.T) neti.Net { return neti.NewWsNet(logrus.StandardLogger()) },
+		Addr: wsAddr,
+	})
+}
+
+func TestQuicNet(t *testing.T) {
+	nettest.TestNet(t, nettest.NetConfig{
+		New:  func(t *testing.T) neti.Net { return neti.NewInsecureQuicNet(logrus.StandardLogger()) },
+		Addr: nettest.LocalAddr,
+	})
+}
+
+func TestWsService(t *testing.T) {
+	nettest.TestService(t, nettest.ServiceConfig{
+		New: func(t *testing.T) neti.NetService {
+			return neti.InitBaseWsService(wsAddr(t), logrus.StandardLogger())
+		},
+	})
+}
+
+func TestQuicService(t *testing.T) {
+	nettest.TestService(t, nettest.ServiceConfig{
+		New: func(t *testing.T) neti.NetService {
+			return neti.InitBaseQuicService(nettest.LocalAddr(t), neti.InsecureQuicTLSConfig(), logrus.StandardLogger())
+		},
+	})
+}
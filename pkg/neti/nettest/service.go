@@ -0,0 +1,253 @@
+package nettest
+
+import (
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	"io"
+	"sync"
+	"testing"
+)
+
+// ServiceConfig describes a NetService implementation to TestService.
+// The NetClients of the suite are opened with the address returned by their Self.
+type ServiceConfig struct {
+	New          func(t *testing.T) neti.NetService //Creates a service, the suite creates a sending one and an echoing one per test
+	MaxSize      int                                //Payload size of the largest message, 60KB if not set (frames of services are at most 64KB)
+	Unserialized bool                               //Messages are delivered without being serialized (e.g. the simulator), unknown codes are not tested
+}
+
+// TestService runs the conformance suite against the NetService of config.
+func TestService(t *testing.T, config ServiceConfig) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 60 << 10
+	}
+	t.Run("RoundTrip", func(t *testing.T) { testServiceRoundTrip(t, config) })
+	t.Run("Multiplexing", func(t *testing.T) { testServiceMultiplexing(t, config) })
+	t.Run("ConcurrentSenders", func(t *testing.T) { testServiceConcurrentSenders(t, config) })
+	t.Run("LargeMessages", func(t *testing.T) { testServiceLargeMessages(t, config) })
+	t.Run("UnknownCode", func(t *testing.T) { testServiceUnknownCode(t, config) })
+	t.Run("Close", func(t *testing.T) { testServiceClose(t, config) })
+}
+
+// servicePair is a NetClient with a connection to the NetClient echo of another service.
+type servicePair struct {
+	config   ServiceConfig
+	sender   neti.NetService
+	receiver neti.NetService
+	client   neti.NetClient
+	conn     *neti.ServiceHostConn
+	errs     chan error //Errors of the echoing NetClients
+}
+
+func newServicePair(t *testing.T, config ServiceConfig) *servicePair {
+	t.Helper()
+	p := &servicePair{config: config, sender: config.New(t), receiver: config.New(t), errs: make(chan error, 16)}
+	t.Cleanup(func() {
+		_ = p.sender.Close()
+		_ = p.receiver.Close()
+	})
+	p.client = p.sender.RegisterListener("sender")
+	p.client.RegisterMessage(message{code: echoCode})
+	p.client.RegisterMessage(message{code: unknownCode})
+	p.conn = p.open(t, p.echo("echo", 0))
+	return p
+}
+
+// echo registers the NetClient id on the receiving service, echoing the messages it receives with their sequence number
+// shifted by shift, and returns it.
+func (p *servicePair) echo(id string, shift uint32) neti.NetClient {
+	c := p.receiver.RegisterListener(id)
+	c.RegisterMessage(message{code: echoCode})
+	report := func(err error) {
+		select {
+		case p.errs <- err:
+		default:
+		}
+	}
+	reply := func(conn *neti.ServiceHostConn, m neti.Message) error {
+		e := m.(message)
+		e.seq += shift
+		return c.SendTo(conn, e)
+	}
+	go func() {
+		for conn := range c.Accept() {
+			if c.Type() == neti.UDP {
+				if m, err := c.RecvFrom(conn); err != nil {
+					report(err)
+				} else {
+					_ = reply(conn, m)
+				}
+				continue
+			}
+			go func(conn *neti.ServiceHostConn) {
+				for {
+					m, err := c.RecvFrom(conn)
+					var decodeErr *neti.DecodeError
+					if errors.As(err, &decodeErr) {
+						report(err)
+						continue
+					} else if err != nil {
+						return
+					}
+					if err = reply(conn, m); err != nil {
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return c
+}
+
+// open opens a connection of the sending NetClient to the NetClient to.
+func (p *servicePair) open(t *testing.T, to neti.NetClient) *neti.ServiceHostConn {
+	t.Helper()
+	conn, err := p.client.OpenTo(to.Self(), to.Id())
+	if err != nil {
+		t.Fatalf("OpenTo(%v, %v): %v", to.Self(), to.Id(), err)
+	}
+	return conn
+}
+
+// recv receives the next message of the sending NetClient, on conn for streams.
+func (p *servicePair) recv(conn *neti.ServiceHostConn) func() received {
+	return func() received {
+		if p.client.Type() != neti.UDP {
+			m, err := p.client.RecvFrom(conn)
+			return received{m, err}
+		}
+		in, ok := <-p.client.Accept()
+		if !ok {
+			return received{nil, io.EOF}
+		}
+		m, err := p.client.RecvFrom(in)
+		return received{m, err}
+	}
+}
+
+func (p *servicePair) send(t *testing.T, conn *neti.ServiceHostConn, messages ...message) {
+	t.Helper()
+	for _, m := range messages {
+		if err := p.client.SendTo(conn, m); err != nil {
+			t.Fatalf("SendTo(%v): %v", m, err)
+		}
+	}
+}
+
+func testServiceRoundTrip(t *testing.T, config ServiceConfig) {
+	p := newServicePair(t, config)
+	var sent []message
+	for i := 0; i < 100; i++ {
+		sent = append(sent, newMessage(echoCode, uint32(i), i%64))
+	}
+	p.send(t, p.conn, sent...)
+	collect(t, sent, p.recv(p.conn))
+}
+
+func testServiceMultiplexing(t *testing.T, config ServiceConfig) {
+	p := newServicePair(t, config)
+	other := p.open(t, p.echo("other", 1000))
+	var sent, expected []message
+	for i := 0; i < 20; i++ {
+		m := newMessage(echoCode, uint32(i), 10)
+		sent = append(sent, m)
+		expected = append(expected, m)
+		shifted := m
+		shifted.seq += 1000
+		expected = append(expected, shifted)
+	}
+	p.send(t, p.conn, sent...)
+	p.send(t, other, sent...)
+	if p.client.Type() == neti.UDP {
+		collect(t, expected, p.recv(nil))
+		return
+	}
+	// each connection receives the replies of its NetClient
+	collect(t, sent, p.recv(p.conn))
+	for i := range sent {
+		sent[i].seq += 1000
+	}
+	collect(t, sent, p.recv(other))
+}
+
+func testServiceConcurrentSenders(t *testing.T, config ServiceConfig) {
+	p := newServicePair(t, config)
+	const senders, messages = 8, 50
+	var sent []message
+	for i := 0; i < senders*messages; i++ {
+		sent = append(sent, newMessage(echoCode, uint32(i), 100+i%1000))
+	}
+	w := newWindow(p.client.Type() == neti.UDP)
+	failed := make(chan error, senders)
+	wg := &sync.WaitGroup{}
+	for s := 0; s < senders; s++ {
+		wg.Add(1)
+		go func(s int) {
+			defer wg.Done()
+			for _, m := range sent[s*messages : (s+1)*messages] {
+				w.acquire()
+				if err := p.client.SendTo(p.conn, m); err != nil {
+					failed <- err
+					return
+				}
+			}
+		}(s)
+	}
+	collect(t, sent, w.release(p.recv(p.conn)))
+	wg.Wait()
+	close(failed)
+	for err := range failed {
+		t.Fatalf("SendTo: %v", err)
+	}
+}
+
+func testServiceLargeMessages(t *testing.T, config ServiceConfig) {
+	p := newServicePair(t, config)
+	for i, size := range []int{0, 1, 1 << 10, 16 << 10, config.MaxSize} {
+		m := newMessage(echoCode, uint32(i), size)
+		p.send(t, p.conn, m)
+		collect(t, []message{m}, p.recv(p.conn))
+	}
+}
+
+func testServiceUnknownCode(t *testing.T, config ServiceConfig) {
+	if config.Unserialized {
+		t.Skip("messages are not serialized")
+	}
+	p := newServicePair(t, config)
+	p.send(t, p.conn, newMessage(unknownCode, 0, 10))
+	if p.client.Type() != neti.UDP {
+		var err error
+		testutil.Within(t, Timeout, "the error of the unknown code", func() { err = <-p.errs })
+		var decodeErr *neti.DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Code != unknownCode {
+			t.Fatalf("receiving a message with an unknown code failed with %v, expected a DecodeError", err)
+		}
+	}
+	// the message is skipped, and the connection is still usable
+	m := newMessage(echoCode, 1, 10)
+	p.send(t, p.conn, m)
+	collect(t, []message{m}, p.recv(p.conn))
+}
+
+func testServiceClose(t *testing.T, config ServiceConfig) {
+	p := newServicePair(t, config)
+	if p.client.Type() == neti.UDP {
+		t.Skip("datagrams have no connections")
+	}
+	echo := p.echo("closed", 0)
+	if err := p.receiver.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	var err error
+	testutil.Within(t, Timeout, "the connection to the closed service", func() {
+		var conn *neti.ServiceHostConn
+		if conn, err = p.client.OpenTo(echo.Self(), echo.Id()); err == nil {
+			_ = conn.Close()
+		}
+	})
+	if err == nil {
+		t.Fatal("OpenTo succeeded after the service was closed")
+	}
+}
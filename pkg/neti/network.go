@@ -7,6 +7,10 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
 )
 
 type MessageDeserializer func(*bytes.Buffer) (Message, error)
@@ -55,6 +59,7 @@ type SentMessage struct {
 // It can be used to connect to other hosts.
 type Net interface {
 	RegisterMessage(message Message)
+	UnregisterMessage(code uint16)
 	Listen(addr string) (<-chan HostConn, error)
 	CloseListener() error
 	Open(addr string) (HostConn, error)
@@ -63,6 +68,8 @@ type Net interface {
 	RecvFrom(conn HostConn) (Message, error)
 	SendTo(conn HostConn, m Message) error
 	SendToAsync(conn HostConn, m Message, ch chan<- SentMessage)
+	UseSend(interceptor SendInterceptor)
+	UseRecv(interceptor RecvInterceptor)
 }
 
 func writeFully(writer io.Writer, b []byte) error {
@@ -74,14 +81,52 @@ func writeFully(writer io.Writer, b []byte) error {
 	return err
 }
 
+// readFully reads the next toRead bytes, a single Read returning only part of a large frame.
+// The buffer grows with the bytes read, so that a length sent without its frame does not allocate it.
 func readFully(reader io.Reader, toRead int) ([]byte, error) {
-	b := make([]byte, toRead)
-	n, err := reader.Read(b)
-	if n != toRead {
-		//log.Warn("Expected to read ", toRead, " read ", n)
-		log.Panicln("Expected to read ", toRead, " read ", n)
+	buff := bytes.NewBuffer(make([]byte, 0, min(toRead, readChunkSize)))
+	if _, err := io.CopyN(buff, reader, int64(toRead)); err == io.EOF {
+		// the length of the frame was read, the connection closing before its end truncates it
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
 	}
-	return b, err
+	return buff.Bytes(), nil
+}
+
+// readChunkSize is the size up to which frames are read into a buffer allocated upfront.
+const readChunkSize = 64 << 10
+
+// splitNetworkAddr splits an address of the form network://address (e.g. unix:///tmp/node.sock)
+// into its network and address. Addresses without a scheme are returned as is with the given default network.
+func splitNetworkAddr(addr string, defaultNetwork string) (string, string) {
+	if i := strings.Index(addr, "://"); i > 0 {
+		return addr[:i], addr[i+len("://"):]
+	}
+	return defaultNetwork, addr
+}
+
+// removeStaleSocket removes a unix socket file left behind by a previous process, so it can be bound again.
+// The socket is dialed first: a socket a live process is listening on is in use and left untouched,
+// only one refusing connections is stale. Files that are not sockets are left untouched.
+func removeStaleSocket(network string, path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(fmt.Sprintf("%s exists and is not a socket", path))
+	}
+	conn, err := net.DialTimeout(network, path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return errors.New(fmt.Sprintf("%s is in use by another process", path))
+	} else if !errors.Is(err, syscall.ECONNREFUSED) {
+		return errors.New(fmt.Sprintf("Unable to check whether %s is in use: %v", path, err))
+	}
+	return os.Remove(path)
 }
 
 // GetInterfaceIpv4Addr returns the IPv4 address of the interface with the given name.
@@ -0,0 +1,120 @@
+package neti
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Option configures optional features of a Net or a NetService.
+type Option func(*options)
+
+type options struct {
+	nodeId          string
+	codecs          []string
+	addressBook     *AddressBook
+	minVersion      uint16
+	maxVersion      uint16
+	clusterKey      []byte
+	keyring         *Keyring
+	authorizer      Authorizer
+	limits          *Limits
+	compression     *compressionConfig
+	heartbeats      *Heartbeats
+	events          *EventBus
+	metrics         *Metrics
+	tracer          Tracer
+	datagramTracing bool
+	capture         *Capture
+	stats           *Stats
+	allowedOrigins  []string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		minVersion: MinProtocolVersion,
+		maxVersion: ProtocolVersion,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.nodeId == "" {
+		o.nodeId = randomNodeId()
+	}
+	if o.addressBook == nil {
+		o.addressBook = NewAddressBook()
+	}
+	if o.stats == nil {
+		o.stats = &Stats{}
+	}
+	if o.metrics != nil {
+		if o.events == nil {
+			o.events = NewEventBus()
+		}
+		o.metrics.subscribe(o.events)
+	}
+	return o
+}
+
+// WithNodeId sets the identity of the node, exchanged with peers when connections are established.
+// It should be stable across restarts, so that peers recognize the node even if its address changes.
+// If not set, a random id is generated.
+func WithNodeId(id string) Option {
+	return func(o *options) {
+		o.nodeId = id
+	}
+}
+
+// WithCodecs sets the names of the codecs the node advertises to its peers.
+func WithCodecs(codecs ...string) Option {
+	return func(o *options) {
+		o.codecs = append(o.codecs, codecs...)
+	}
+}
+
+// WithAddressBook sets the AddressBook used to resolve node ids (see NodeAddr).
+// It is filled with the peers learned on handshakes, sharing a book between services shares what they learn.
+func WithAddressBook(book *AddressBook) Option {
+	return func(o *options) {
+		o.addressBook = book
+	}
+}
+
+// WithProtocolVersions restricts the protocol versions a service speaks to [min, max],
+// e.g. to keep speaking an older version until all nodes of a cluster are upgraded.
+func WithProtocolVersions(min uint16, max uint16) Option {
+	return func(o *options) {
+		o.minVersion = min
+		o.maxVersion = max
+	}
+}
+
+// rejectConnectionOptions panics if opts set the options the tcp and udp Nets apply to connections,
+// which the Nets over transport, secured by TLS, do not.
+func rejectConnectionOptions(transport string, opts *options) {
+	var unsupported []string
+	if opts.clusterKey != nil {
+		unsupported = append(unsupported, "WithClusterKey")
+	}
+	if opts.keyring != nil {
+		unsupported = append(unsupported, "WithEncryption")
+	}
+	if opts.compression != nil {
+		unsupported = append(unsupported, "WithCompression")
+	}
+	if l := opts.limits; l != nil && (l.MaxConnections != 0 || l.MaxConnectionsPerIP != 0 || l.PeerRate != 0) {
+		unsupported = append(unsupported, "the connection and peer limits of WithLimits")
+	}
+	if len(unsupported) > 0 {
+		panic(fmt.Sprintf("%v does not apply %v", transport, strings.Join(unsupported, ", ")))
+	}
+}
+
+func randomNodeId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,164 @@
+package neti
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const nodeScheme = "node://"
+
+// NodeInfo describes a node, it is exchanged by both ends when a service connection is established.
+type NodeInfo struct {
+	Id         string   //Stable identity of the node, independent of its addresses
+	Version    uint16   //Newest protocol version of the node
+	Codecs     []string //Codecs the node supports
+	ListenAddr string   //Address the node accepts connections on
+}
+
+// String returns a string representation of the NodeInfo.
+func (n *NodeInfo) String() string {
+	return fmt.Sprintf("{id: %v, version: %v, codecs: %v, addr: %v}", n.Id, n.Version, n.Codecs, n.ListenAddr)
+}
+
+// Serialize serializes the NodeInfo to the buffer.
+func (n *NodeInfo) Serialize(buff *bytes.Buffer) error {
+	if err := EncodeStringToBuffer(n.Id, buff); err != nil {
+		return err
+	}
+	if err := EncodeNumberToBuffer(n.Version, buff); err != nil {
+		return err
+	}
+	if err := EncodeNumberToBuffer(uint16(len(n.Codecs)), buff); err != nil {
+		return err
+	}
+	for _, codec := range n.Codecs {
+		if err := EncodeStringToBuffer(codec, buff); err != nil {
+			return err
+		}
+	}
+	return EncodeStringToBuffer(n.ListenAddr, buff)
+}
+
+// Deserialize deserializes the NodeInfo from the buffer.
+func (n *NodeInfo) Deserialize(buff *bytes.Buffer) error {
+	var err error
+	if n.Id, err = DecodeStringFromBuffer(buff); err != nil {
+		return err
+	}
+	if err = DecodeNumberFromBuffer(&n.Version, buff); err != nil {
+		return err
+	}
+	var nCodecs uint16
+	if err = DecodeNumberFromBuffer(&nCodecs, buff); err != nil {
+		return err
+	}
+	n.Codecs = make([]string, nCodecs)
+	for i := range n.Codecs {
+		if n.Codecs[i], err = DecodeStringFromBuffer(buff); err != nil {
+			return err
+		}
+	}
+	n.ListenAddr, err = DecodeStringFromBuffer(buff)
+	return err
+}
+
+// decodeNodeInfo decodes the NodeInfo in the buffer, if any.
+// Peers running versions without node identities do not send it.
+func decodeNodeInfo(buff *bytes.Buffer) (*NodeInfo, error) {
+	if buff.Len() == 0 {
+		return nil, nil
+	}
+	n := &NodeInfo{}
+	if err := n.Deserialize(buff); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// NodeAddr returns the address of the node with the given id, to be used in NetClient.OpenTo.
+// The address is resolved with the AddressBook of the service.
+func NodeAddr(nodeId string) string {
+	return nodeScheme + nodeId
+}
+
+// AddressBook maps node ids to the addresses they can be reached at.
+// It is safe for concurrent use.
+type AddressBook struct {
+	lock  *sync.RWMutex
+	addrs map[string]string
+}
+
+// NewAddressBook creates an empty AddressBook.
+func NewAddressBook() *AddressBook {
+	return &AddressBook{
+		lock:  &sync.RWMutex{},
+		addrs: make(map[string]string),
+	}
+}
+
+// Set sets the address of a node.
+func (a *AddressBook) Set(nodeId string, addr string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.addrs[nodeId] = addr
+}
+
+// learn sets the address of a node announced in a handshake.
+// Only authenticated peers replace the address of a known node, so that a peer cannot claim the id of another node.
+func (a *AddressBook) learn(nodeId string, addr string, authenticated bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, known := a.addrs[nodeId]; known && !authenticated {
+		return
+	}
+	a.addrs[nodeId] = addr
+}
+
+// Lookup returns the address of a node.
+func (a *AddressBook) Lookup(nodeId string) (string, bool) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	addr, ok := a.addrs[nodeId]
+	return addr, ok
+}
+
+// Remove removes a node.
+func (a *AddressBook) Remove(nodeId string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.addrs, nodeId)
+}
+
+// resolve resolves addresses of the form node://id to the address of the node,
+// other addresses are returned as is together with an empty node id.
+func (a *AddressBook) resolve(addr string) (string, string, error) {
+	if !strings.HasPrefix(addr, nodeScheme) {
+		return addr, "", nil
+	}
+	nodeId := strings.TrimPrefix(addr, nodeScheme)
+	if resolved, ok := a.Lookup(nodeId); ok {
+		return resolved, nodeId, nil
+	}
+	return "", nodeId, errors.New(fmt.Sprintf("unknown address for node %v", nodeId))
+}
+
+// reachableAddr replaces an unspecified host in listenAddr (e.g. 0.0.0.0:10000)
+// with the host the peer connected from.
+func reachableAddr(listenAddr string, from net.Addr) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil || from == nil {
+		return listenAddr
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listenAddr
+	}
+	fromHost, _, err := net.SplitHostPort(from.String())
+	if err != nil {
+		return listenAddr
+	}
+	return net.JoinHostPort(fromHost, port)
+}
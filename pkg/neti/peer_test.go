@@ -0,0 +1,75 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"testing"
+)
+
+// connectAs opens a connection to the listener of service at addr from a new service of node id.
+func connectAs(t *testing.T, addr string, id string, opts ...Option) {
+	t.Helper()
+	peer := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), append(opts, WithNodeId(id))...)
+	defer peer.Close()
+	conn, err := peer.RegisterListener("sender").OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
+
+func TestAddressBookLearnsFromHandshakes(t *testing.T) {
+	key := WithClusterKey([]byte("cluster key"))
+	for _, test := range []struct {
+		name    string
+		opts    []Option
+		replace bool
+	}{
+		{"Unauthenticated", nil, false},
+		{"Authenticated", []Option{key}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			book := NewAddressBook()
+			book.Set("node-2", "10.0.0.2:10000")
+			addr := freeAddr(t, "tcp")
+			service := InitBaseTcpService(addr, log.StandardLogger(), append(test.opts, WithAddressBook(book))...)
+			defer service.Close()
+			receiver := service.RegisterListener("receiver")
+			// the address is learned once the connection is accepted
+			accepted := func() { _ = (<-receiver.Accept()).Close() }
+
+			// a peer claiming the id of a known node
+			connectAs(t, addr, "node-2", test.opts...)
+			testutil.Within(t, testTimeout, "the connection of node-2", accepted)
+			if a, _ := book.Lookup("node-2"); (a != "10.0.0.2:10000") != test.replace {
+				t.Fatalf("the address of node-2 is %v after a peer claimed its id", a)
+			}
+			// new nodes are learned either way
+			connectAs(t, addr, "node-3", test.opts...)
+			testutil.Within(t, testTimeout, "the connection of node-3", accepted)
+			if _, ok := book.Lookup("node-3"); !ok {
+				t.Fatal("node-3 was not learned from its handshake")
+			}
+		})
+	}
+}
+
+func TestAddressBookDialedPeerCannotClaimKnownNode(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	impostor := InitBaseTcpService(addr, log.StandardLogger(), WithNodeId("node-2"))
+	defer impostor.Close()
+	impostor.RegisterListener("receiver")
+
+	book := NewAddressBook()
+	book.Set("node-2", "10.0.0.2:10000")
+	service := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), WithAddressBook(book))
+	defer service.Close()
+	conn, err := service.RegisterListener("sender").OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	if a, _ := book.Lookup("node-2"); a != "10.0.0.2:10000" {
+		t.Fatalf("the address of node-2 is %v after dialing a peer claiming its id", a)
+	}
+}
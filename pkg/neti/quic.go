@@ -0,0 +1,389 @@
+package neti
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	quicALPN = "neti"
+	// maxQuicFrameSize bounds the length of the frames received on QUIC streams, so that a peer cannot exhaust memory.
+	maxQuicFrameSize = 64 << 20
+	// quicStreamOpened is written first on the streams opened, peers only learn of a stream once written to.
+	quicStreamOpened byte = 1
+	// quicStreamAccepted is the reply of a listening peer to quicStreamOpened, before the stream is accepted.
+	quicStreamAccepted byte = 2
+	// quicOpenTimeout bounds the wait for the announcement of a stream, and for its reply.
+	quicOpenTimeout = 5 * time.Second
+)
+
+// ErrFrameTooLarge is returned when sending or receiving a frame longer than the limit of the Net.
+var ErrFrameTooLarge = errors.New("frame exceeds the maximum frame size")
+
+type quicHostConn struct {
+	stream   quic.Stream
+	conn     quic.Connection
+	sendLock *sync.Mutex
+}
+
+func (q quicHostConn) String() string {
+	return q.Addr().String()
+}
+
+func (q quicHostConn) Addr() net.Addr {
+	return q.conn.RemoteAddr()
+}
+
+// LocalAddr returns the local address of the QUIC connection.
+func (q quicHostConn) LocalAddr() net.Addr {
+	return q.conn.LocalAddr()
+}
+
+func (q quicHostConn) Send(b []byte) error {
+	if len(b) > maxQuicFrameSize {
+		return ErrFrameTooLarge
+	}
+	q.sendLock.Lock()
+	defer q.sendLock.Unlock()
+	err := binary.Write(q.stream, binary.BigEndian, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+	return writeFully(q.stream, b)
+}
+
+func (q quicHostConn) Receive() ([]byte, error) {
+	var size uint32
+	err := binary.Read(q.stream, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxQuicFrameSize {
+		// the rest of the stream cannot be framed anymore
+		_ = q.Close()
+		return nil, ErrFrameTooLarge
+	}
+	return readFully(q.stream, int(size))
+}
+
+// Close closes the stream, the underlying connection stays open for other streams.
+func (q quicHostConn) Close() error {
+	q.stream.CancelRead(0)
+	return q.stream.Close()
+}
+
+// NewQuicNet creates a Net over QUIC.
+// Each HostConn is an independent stream, and the streams to the same peer share a single QUIC connection,
+// so a large frame on one HostConn does not delay the others.
+// All connections share the UDP socket bound by Listen (or an ephemeral one if Open is called first).
+// tlsConf is required, it panics if tlsConf is nil: use NewInsecureQuicNet (or InsecureQuicTLSConfig) to opt out of
+// authenticating peers.
+// It also panics if opts set the cluster key, encryption, compression or peer limits, which QUIC does not apply:
+// connections are secured by tlsConf instead.
+func NewQuicNet(log *logrus.Logger, tlsConf *tls.Config, opts ...Option) Net {
+	return newQuicNet(log, tlsConf, newOptions(opts))
+}
+
+// NewInsecureQuicNet creates a Net over QUIC with an ephemeral self-signed certificate that does not verify
+// the certificates of peers: traffic is encrypted, but peers are not authenticated.
+func NewInsecureQuicNet(log *logrus.Logger, opts ...Option) Net {
+	return NewQuicNet(log, InsecureQuicTLSConfig(), opts...)
+}
+
+// newQuicNet creates a Net over QUIC publishing to the events, metrics and capture of opts.
+func newQuicNet(log *logrus.Logger, tlsConf *tls.Config, opts *options) *quicNet {
+	if tlsConf == nil {
+		panic("a tls configuration is required for QUIC, use InsecureQuicTLSConfig to not authenticate peers")
+	}
+	rejectConnectionOptions("QUIC", opts)
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{quicALPN}
+	return &quicNet{
+		tcp: tcp{
+			network:          "quic",
+			msgDeserializers: newDeserializers(),
+			log:              log,
+			events:           opts.events,
+			metrics:          opts.metrics,
+			capture:          opts.capture,
+			interceptors:     newInterceptors(),
+		},
+		tlsConf: tlsConf,
+		config: &quic.Config{
+			MaxIncomingStreams: 1 << 16,
+			KeepAlivePeriod:    15 * time.Second,
+		},
+		conns:     make(map[string]quic.Connection),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+		pending:   &sync.WaitGroup{},
+		lock:      &sync.RWMutex{},
+	}
+}
+
+type quicNet struct {
+	tcp       //message registration and serialization are shared with the tcp Net
+	tlsConf   *tls.Config
+	config    *quic.Config
+	transport *quic.Transport
+	listener  *quic.Listener
+	acpt      chan HostConn   //nil when not listening
+	pending   *sync.WaitGroup //streams being handed to acpt, which is closed once they are
+	conns     map[string]quic.Connection
+	closed    chan struct{}
+	closeOnce *sync.Once
+	lock      *sync.RWMutex
+}
+
+func (q *quicNet) getTransport(addr string) (*quic.Transport, error) {
+	if q.transport == nil {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		q.transport = &quic.Transport{Conn: conn}
+	}
+	return q.transport, nil
+}
+
+func (q *quicNet) Listen(addr string) (<-chan HostConn, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.transport != nil {
+		return nil, errors.New("unable to listen: the QUIC socket is already bound, call Listen before Open")
+	}
+	transport, err := q.getTransport(addr)
+	if err != nil {
+		return nil, err
+	}
+	q.listener, err = transport.Listen(q.tlsConf, q.config)
+	if err != nil {
+		return nil, err
+	}
+
+	q.acpt = make(chan HostConn)
+	go func() {
+		for {
+			conn, err := q.listener.Accept(context.Background())
+			if err != nil {
+				q.log.Debug("Error on accept ", err)
+				q.lock.Lock()
+				acpt := q.acpt
+				q.acpt = nil
+				q.lock.Unlock()
+				q.pending.Wait()
+				close(acpt)
+				return
+			}
+			q.lock.Lock()
+			// streams to this peer can be opened on the connection it opened to us
+			if _, ok := q.conns[conn.RemoteAddr().String()]; !ok {
+				q.conns[conn.RemoteAddr().String()] = conn
+			}
+			q.lock.Unlock()
+			go q.acceptStreams(conn)
+		}
+	}()
+	return q.acpt, nil
+}
+
+// acceptStreams accepts the streams opened by the peer on conn.
+func (q *quicNet) acceptStreams(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			q.log.Debug("Error on accept stream from ", conn.RemoteAddr(), " ", err)
+			q.forget(conn)
+			return
+		}
+		go q.acceptStream(conn, stream)
+	}
+}
+
+// acceptStream hands a stream opened by the peer on conn to the channel returned by Listen, replying to its
+// announcement. Streams are refused when not listening.
+func (q *quicNet) acceptStream(conn quic.Connection, stream quic.Stream) {
+	hConn := quicHostConn{stream: stream, conn: conn, sendLock: &sync.Mutex{}}
+	if err := expectByte(stream, quicStreamOpened); err != nil {
+		q.log.Debug("Error on accept stream from ", conn.RemoteAddr(), " ", err)
+		_ = hConn.Close()
+		return
+	}
+	q.lock.RLock()
+	acpt := q.acpt
+	if acpt == nil {
+		q.lock.RUnlock()
+		_ = hConn.Close()
+		return
+	}
+	q.pending.Add(1)
+	q.lock.RUnlock()
+	defer q.pending.Done()
+	if _, err := stream.Write([]byte{quicStreamAccepted}); err != nil {
+		_ = hConn.Close()
+		return
+	}
+	// the lock is not held while waiting to be accepted, so Open and Listen are not blocked by streams nobody accepts
+	select {
+	case acpt <- hConn:
+	case <-q.closed:
+		_ = hConn.Close()
+	}
+}
+
+// expectByte reads the byte expected on stream, within quicOpenTimeout.
+func expectByte(stream quic.Stream, expected byte) error {
+	_ = stream.SetReadDeadline(time.Now().Add(quicOpenTimeout))
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(stream, b); err != nil {
+		return err
+	}
+	if b[0] != expected {
+		return errors.New(fmt.Sprintf("unexpected byte %v at the start of the stream", b[0]))
+	}
+	return stream.SetReadDeadline(time.Time{})
+}
+
+func (q *quicNet) forget(conn quic.Connection) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.conns[conn.RemoteAddr().String()] == conn {
+		delete(q.conns, conn.RemoteAddr().String())
+	}
+}
+
+func (q *quicNet) CloseListener() error {
+	closing := false
+	q.closeOnce.Do(func() {
+		close(q.closed)
+		closing = true
+	})
+	if !closing {
+		return nil
+	}
+	q.lock.Lock()
+	for addr, conn := range q.conns {
+		_ = conn.CloseWithError(0, "closed")
+		delete(q.conns, addr)
+	}
+	q.lock.Unlock()
+	var err error
+	if q.listener != nil {
+		err = q.listener.Close()
+	}
+	if q.transport != nil {
+		if tErr := q.transport.Close(); tErr != nil && err == nil {
+			err = tErr
+		}
+		if cErr := q.transport.Conn.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+func (q *quicNet) connectionTo(addr string) (quic.Connection, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if conn, ok := q.conns[udpAddr.String()]; ok && conn.Context().Err() == nil {
+		return conn, nil
+	}
+	transport, err := q.getTransport(":0")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := transport.Dial(context.Background(), udpAddr, q.tlsConf, q.config)
+	if err != nil {
+		return nil, err
+	}
+	q.conns[udpAddr.String()] = conn
+	go q.acceptStreams(conn)
+	return conn, nil
+}
+
+// Open opens a new stream to addr, reusing the QUIC connection to addr if there is one.
+// It returns once the peer, listening, replied to the announcement of the stream.
+func (q *quicNet) Open(addr string) (HostConn, error) {
+	conn, err := q.connectionTo(addr)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	hConn := quicHostConn{stream: stream, conn: conn, sendLock: &sync.Mutex{}}
+	if _, err = stream.Write([]byte{quicStreamOpened}); err == nil {
+		err = expectByte(stream, quicStreamAccepted)
+	}
+	if err != nil {
+		_ = hConn.Close()
+		return nil, errors.New(fmt.Sprintf("unable to open a stream to %v: %v", addr, err))
+	}
+	return hConn, nil
+}
+
+func (q *quicNet) OpenAsync(addr string, ch chan<- ReceivedConnection) {
+	go func() {
+		conn, err := q.Open(addr)
+		ch <- ReceivedConnection{
+			Addr: addr,
+			Conn: conn,
+			Err:  err,
+		}
+	}()
+}
+
+// InsecureQuicTLSConfig returns a tls configuration with an ephemeral self-signed certificate
+// that does not verify the certificates of peers, for tests and trusted networks.
+func InsecureQuicTLSConfig() *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: quicALPN},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		InsecureSkipVerify: true,
+	}
+}
+
+// InitBaseQuicService creates a new service over QUIC, listening on listenAddr (ip:port).
+// Every ServiceHostConn opened by a NetClient is a separate stream of the QUIC connection to the peer.
+// tlsConf is required as in NewQuicNet, and it panics, as NewQuicNet does, if opts set options QUIC does not apply.
+func InitBaseQuicService(listenAddr string, tlsConf *tls.Config, logger *logrus.Logger, opts ...Option) NetService {
+	o := newOptions(opts)
+	return initStreamService(listenAddr, newQuicNet(logger, tlsConf, o), QUIC, logger, o)
+}
@@ -0,0 +1,147 @@
+package neti
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"testing"
+	"time"
+)
+
+// quicPair returns a listening QUIC Net, the connection a second Net opened to it and the accepted connection.
+func quicPair(t *testing.T) (Net, HostConn, HostConn) {
+	server := NewInsecureQuicNet(log.StandardLogger())
+	server.RegisterMessage(registryMsg{code: 1})
+	addr := freeAddr(t, "udp")
+	conns, err := server.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = server.CloseListener() })
+	client := NewInsecureQuicNet(log.StandardLogger())
+	t.Cleanup(func() { _ = client.CloseListener() })
+	conn, err := client.Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a stream", func() { accepted = <-conns })
+	return server, conn, accepted
+}
+
+func TestQuicNetRequiresTLSConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewQuicNet accepted a nil tls configuration")
+		}
+	}()
+	NewQuicNet(log.StandardLogger(), nil)
+}
+
+func TestQuicRoundTrip(t *testing.T) {
+	server, _, accepted := quicPair(t)
+	if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+}
+
+func TestQuicVerifiesPeers(t *testing.T) {
+	server := NewInsecureQuicNet(log.StandardLogger())
+	addr := freeAddr(t, "udp")
+	if _, err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	// the self-signed certificate of the server is not trusted by a configuration verifying peers
+	client := NewQuicNet(log.StandardLogger(), &tls.Config{ServerName: "localhost"})
+	defer client.CloseListener()
+	if conn, err := client.Open(addr); err == nil {
+		_ = conn.Close()
+		t.Fatal("opened a connection to an untrusted peer")
+	}
+}
+
+func TestQuicFrameTooLarge(t *testing.T) {
+	server, conn, accepted := quicPair(t)
+	if _, err := server.RecvFrom(accepted); err != nil {
+		t.Fatal(err)
+	}
+	// a length header beyond the limit, without the frame
+	if _, err := conn.(quicHostConn).stream.Write(binary.BigEndian.AppendUint32(nil, maxQuicFrameSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := accepted.Receive(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("receiving a frame too large failed with %v, expected ErrFrameTooLarge", err)
+	}
+	if err := conn.Send(make([]byte, maxQuicFrameSize+1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("sending a frame too large failed with %v, expected ErrFrameTooLarge", err)
+	}
+}
+
+func TestQuicOpenWithoutAccept(t *testing.T) {
+	server := NewInsecureQuicNet(log.StandardLogger())
+	addr := freeAddr(t, "udp")
+	conns, err := server.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewInsecureQuicNet(log.StandardLogger())
+	defer client.CloseListener()
+	// the client listens for the stream the server opens back
+	clientAddr := freeAddr(t, "udp")
+	if _, err := client.Listen(clientAddr); err != nil {
+		t.Fatal(err)
+	}
+	// the stream is handed to the server, and waits for someone to accept it
+	if _, err := client.Open(addr); err != nil {
+		t.Fatal(err)
+	}
+	// the server reuses the connection the client opened to it, once accepted
+	q := server.(*quicNet)
+	for accepted := false; !accepted; time.Sleep(10 * time.Millisecond) {
+		q.lock.RLock()
+		_, accepted = q.conns[clientAddr]
+		q.lock.RUnlock()
+	}
+	time.Sleep(50 * time.Millisecond)
+	testutil.Within(t, testTimeout, "a stream to the client", func() {
+		stream, err := server.Open(clientAddr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = stream.Close()
+	})
+	testutil.Within(t, testTimeout, "CloseListener", func() {
+		if err := server.CloseListener(); err != nil {
+			t.Error(err)
+		}
+	})
+	testutil.Within(t, testTimeout, "the accepted streams to be closed", func() {
+		for range conns {
+		}
+	})
+}
+
+func TestQuicRejectsConnectionOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"ClusterKey":  WithClusterKey(testClusterKey),
+		"Encryption":  WithEncryption(testKeyring(t, AESGCM)),
+		"Compression": WithCompression(0, Snappy),
+		"PeerLimits":  WithLimits(Limits{MaxConnectionsPerIP: 1}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("created a QUIC service with an option it does not apply")
+				}
+			}()
+			InitBaseQuicService(freeAddr(t, "udp"), InsecureQuicTLSConfig(), log.StandardLogger(), opt)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package neti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MessageRegistry maps the codes of messages to their types, per service id, to deserialize frames outside a NetClient
+// (e.g. captured frames).
+type MessageRegistry struct {
+	lock     *sync.RWMutex
+	messages map[string]map[uint16]Message
+}
+
+// NewMessageRegistry creates an empty MessageRegistry.
+func NewMessageRegistry() *MessageRegistry {
+	return &MessageRegistry{
+		lock:     &sync.RWMutex{},
+		messages: make(map[string]map[uint16]Message),
+	}
+}
+
+// Register registers the messages of the NetClient serviceId, or of every service if serviceId is empty.
+func (r *MessageRegistry) Register(serviceId string, messages ...Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.messages[serviceId] == nil {
+		r.messages[serviceId] = make(map[uint16]Message)
+	}
+	for _, m := range messages {
+		r.messages[serviceId][m.Code()] = m
+	}
+}
+
+// Lookup returns the message registered with code for serviceId, or else for every service.
+func (r *MessageRegistry) Lookup(serviceId string, code uint16) (Message, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if m, ok := r.messages[serviceId][code]; ok {
+		return m, true
+	}
+	m, ok := r.messages[""][code]
+	return m, ok
+}
+
+// Decode deserializes the payload of a message with code sent to serviceId.
+func (r *MessageRegistry) Decode(serviceId string, code uint16, payload []byte) (Message, error) {
+	m, ok := r.Lookup(serviceId, code)
+	if !ok {
+		return nil, &DecodeError{Code: code, ServiceId: serviceId, Err: errors.New("Unknown serializer")}
+	}
+	msg, err := m.Deserialize(bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, &DecodeError{Code: code, ServiceId: serviceId, Err: err}
+	}
+	return msg, nil
+}
+
+// ServiceFrame is a frame of a NetService, as parsed by ParseServiceFrame.
+type ServiceFrame struct {
+	Trace       SpanContext //Span context of the message, if any
+	ServiceId   string      //Id of the NetClient that sent the message
+	MessageCode uint16      //Code of the message
+	Payload     []byte      //Payload of the message
+}
+
+// String returns a short representation of the message of the frame.
+func (f ServiceFrame) String() string {
+	return fmt.Sprintf("%v code %v (%v bytes)", f.ServiceId, f.MessageCode, len(f.Payload))
+}
+
+// ParseServiceFrame parses a frame of a NetService (a MessageWrap, possibly preceded by a span context),
+// as written to capture files.
+func ParseServiceFrame(frame []byte) (ServiceFrame, error) {
+	var f ServiceFrame
+	if len(frame) < 2 {
+		return f, errors.New("frame too short")
+	}
+	code := binary.BigEndian.Uint16(frame)
+	buff := bytes.NewBuffer(frame[2:])
+	switch code {
+	case 0:
+	case tracedWrapCode:
+		trace, err := decodeSpanContext(buff)
+		if err != nil {
+			return f, err
+		}
+		f.Trace = trace
+	default:
+		return f, errors.New(fmt.Sprintf("Not a service frame, code %v", code))
+	}
+	if buff.Len() < 2 {
+		return f, errors.New("frame too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(buff.Next(2)))
+	if buff.Len() < idLen+2 {
+		return f, errors.New("frame too short")
+	}
+	f.ServiceId = string(buff.Next(idLen))
+	f.MessageCode = binary.BigEndian.Uint16(buff.Next(2))
+	f.Payload = buff.Bytes()
+	return f, nil
+}
+
+// deserializers maps the codes of the messages registered in a Net or a NetClient to their deserializers.
+// It is safe for concurrent use, protocols may register their messages while connections are receiving.
+type deserializers struct {
+	lock   *sync.RWMutex
+	byCode map[uint16]MessageDeserializer
+}
+
+func newDeserializers() *deserializers {
+	return &deserializers{
+		lock:   &sync.RWMutex{},
+		byCode: make(map[uint16]MessageDeserializer),
+	}
+}
+
+// register registers the deserializer of message, returning false if its code is already registered.
+func (d *deserializers) register(message Message) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.byCode[message.Code()]; ok {
+		return false
+	}
+	d.byCode[message.Code()] = message.Deserialize
+	return true
+}
+
+// unregister removes the deserializer of code, returning false if it was not registered.
+func (d *deserializers) unregister(code uint16) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.byCode[code]; !ok {
+		return false
+	}
+	delete(d.byCode, code)
+	return true
+}
+
+// lookup returns the deserializer of code.
+func (d *deserializers) lookup(code uint16) (MessageDeserializer, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	deserialize, ok := d.byCode[code]
+	return deserialize, ok
+}
@@ -0,0 +1,353 @@
+package neti
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests of this file register and unregister messages and listeners while the networks receive,
+// run them with the race detector: go test -race -run 'Register|Deserializers' ./pkg/neti
+
+// registryMsg is a message with a configurable code.
+type registryMsg struct {
+	code uint16
+	seq  uint32
+}
+
+func (m registryMsg) String() string {
+	return fmt.Sprintf("%v{code=%v, seq=%v}", m.Name(), m.code, m.seq)
+}
+
+func (m registryMsg) Name() string {
+	return "registryMsg"
+}
+
+func (m registryMsg) Code() uint16 {
+	return m.code
+}
+
+func (m registryMsg) Serialize(buff *bytes.Buffer) error {
+	return binary.Write(buff, binary.BigEndian, m.seq)
+}
+
+func (m registryMsg) Deserialize(buff *bytes.Buffer) (Message, error) {
+	if err := binary.Read(buff, binary.BigEndian, &m.seq); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// freeAddr returns a free address on the loopback, for services that listen on the address they are given.
+func freeAddr(t *testing.T, network string) string {
+	t.Helper()
+	if network == "udp" {
+		p, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+		return p.LocalAddr().String()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// testTimeout bounds the waits of the tests.
+const testTimeout = 5 * time.Second
+
+func TestDeserializersConcurrentRegistration(t *testing.T) {
+	d := newDeserializers()
+	wg := &sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				code := uint16(g*1000 + i)
+				if !d.register(registryMsg{code: code}) {
+					t.Errorf("code %v already registered", code)
+				}
+				if _, ok := d.lookup(code); !ok {
+					t.Errorf("code %v not found once registered", code)
+				}
+				d.lookup(uint16((g+1)%8*1000 + i))
+				if i%2 == 0 && !d.unregister(code) {
+					t.Errorf("code %v not unregistered", code)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 200; i++ {
+			if _, ok := d.lookup(uint16(g*1000 + i)); ok != (i%2 == 1) {
+				t.Errorf("lookup(%v) = %v after the registrations", g*1000+i, ok)
+			}
+		}
+	}
+	if d.register(registryMsg{code: 1}) && d.register(registryMsg{code: 1}) {
+		t.Error("registered the same code twice")
+	}
+	if d.unregister(0xFFFF) {
+		t.Error("unregistered a code that was never registered")
+	}
+}
+
+func TestTcpRegisterMessageWhileReceiving(t *testing.T) {
+	server := NewTcpNet(log.StandardLogger())
+	client := NewTcpNet(log.StandardLogger())
+	server.RegisterMessage(registryMsg{code: 1})
+	conns, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	addr := server.(*tcp).listener.Addr().String()
+	conn, err := client.Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+
+	const messages = 500
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < messages; i++ {
+			_ = client.SendTo(conn, registryMsg{code: uint16(1 + i%2), seq: uint32(i)})
+		}
+	}()
+	// code 2 is registered and unregistered while the messages are received, it is known or not but never corrupted
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				server.RegisterMessage(registryMsg{code: 2})
+			} else {
+				server.UnregisterMessage(2)
+			}
+		}
+	}()
+	for i := 0; i < messages; i++ {
+		m, err := server.RecvFrom(accepted)
+		if err != nil {
+			if i%2 == 0 {
+				t.Fatalf("receiving the registered message %v failed: %v", i, err)
+			}
+			continue
+		}
+		if r := m.(registryMsg); r.seq != uint32(i) || r.code != uint16(1+i%2) {
+			t.Fatalf("received %v, expected message %v", r, i)
+		}
+	}
+	<-done
+}
+
+func TestUdpRegisterMessageWhileReceiving(t *testing.T) {
+	server := NewUdpNet(1024)
+	client := NewUdpNet(1024)
+	datagrams, err := server.Listen(freeAddr(t, "udp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.CloseListener()
+	if _, err := client.Listen(freeAddr(t, "udp")); err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseListener()
+	conn, err := client.Open(server.(*udp).conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.RegisterMessage(registryMsg{code: uint16(i)})
+			if i%2 == 0 {
+				server.UnregisterMessage(uint16(i))
+			}
+			_ = client.SendTo(conn, registryMsg{code: uint16(i), seq: uint32(i)})
+		}
+	}()
+	received := 0
+	for received < 50 {
+		var d HostConn
+		testutil.Within(t, testTimeout, fmt.Sprintf("%v more datagrams", 50-received), func() { d = <-datagrams })
+		m, err := server.RecvFrom(d)
+		if err != nil {
+			continue
+		}
+		if r := m.(registryMsg); r.code%2 == 0 || uint32(r.code) != r.seq {
+			t.Fatalf("received %v, which was unregistered or corrupted", r)
+		}
+		received++
+	}
+	<-done
+}
+
+func TestTcpServiceRegisterListenerWhileAccepting(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+
+	const listeners = 20
+	wg := &sync.WaitGroup{}
+	for i := 0; i < listeners; i++ {
+		id := fmt.Sprintf("listener-%v", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := service.RegisterListener(id)
+			c.RegisterMessage(registryMsg{code: 1})
+			go func() {
+				for conn := range c.Accept() {
+					_, _ = c.RecvFrom(conn)
+				}
+			}()
+			// the listener is registered, so connections to it are accepted while others register
+			conn, err := sender.OpenTo(addr, id)
+			if err != nil {
+				t.Errorf("OpenTo(%v): %v", id, err)
+				return
+			}
+			defer conn.Close()
+			if err := sender.SendTo(conn, registryMsg{code: 1, seq: 7}); err != nil {
+				t.Errorf("SendTo(%v): %v", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	service.UnregisterListener("listener-0")
+	_, err := sender.OpenTo(addr, "listener-0")
+	var rejected *HandshakeRejectedError
+	if !errors.As(err, &rejected) || rejected.Reason != RejectUnknownService {
+		t.Fatalf("OpenTo an unregistered listener failed with %v, expected RejectUnknownService", err)
+	}
+	if conn, err := sender.OpenTo(addr, "listener-1"); err != nil {
+		t.Fatalf("OpenTo a registered listener failed with %v", err)
+	} else {
+		_ = conn.Close()
+	}
+}
+
+func TestTcpClientUnregisterMessage(t *testing.T) {
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger())
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+
+	conn, err := sender.OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted *ServiceHostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-receiver.Accept() })
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 1})
+	if m, err := receiver.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+	receiver.UnregisterMessage(1)
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 2})
+	var decodeErr *DecodeError
+	if _, err := receiver.RecvFrom(accepted); !errors.As(err, &decodeErr) || decodeErr.Code != 1 {
+		t.Fatalf("receiving an unregistered message failed with %v, expected a DecodeError", err)
+	}
+	// registering the message again, the connection is still usable
+	receiver.RegisterMessage(registryMsg{code: 1})
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 3})
+	if m, err := receiver.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 3 {
+		t.Fatalf("received %v, %v; expected message 3", m, err)
+	}
+}
+
+func TestUdpServiceRegisterListenerWhileDelivering(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(1024)
+	defer cancel()
+	addr := freeAddr(t, "udp")
+	service := InitBaseUdpService(addr, 1024, WithEvents(bus))
+	defer service.Close()
+	senderService := InitBaseUdpService(freeAddr(t, "udp"), 1024)
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+
+	const listeners = 20
+	received := make(chan string, listeners)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < listeners; i++ {
+		id := fmt.Sprintf("listener-%v", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := service.RegisterListener(id)
+			c.RegisterMessage(registryMsg{code: 1})
+			go func() {
+				for conn := range c.Accept() {
+					if _, err := c.RecvFrom(conn); err == nil {
+						received <- id
+					}
+				}
+			}()
+			conn, err := sender.OpenTo(addr, id)
+			if err != nil {
+				t.Errorf("OpenTo(%v): %v", id, err)
+				return
+			}
+			if err := sender.SendTo(conn, registryMsg{code: 1}); err != nil {
+				t.Errorf("SendTo(%v): %v", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	seen := make(map[string]bool)
+	for len(seen) < listeners {
+		testutil.Within(t, testTimeout, fmt.Sprintf("%v more listeners to receive", listeners-len(seen)), func() { seen[<-received] = true })
+	}
+
+	service.UnregisterListener("listener-0")
+	conn, err := sender.OpenTo(addr, "listener-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.SendTo(conn, registryMsg{code: 1})
+	var dropped Event
+	testutil.Within(t, testTimeout, "the datagram to the unregistered listener", func() {
+		for dropped = range events {
+			if dropped.Type == UnknownService {
+				return
+			}
+		}
+	})
+	if dropped.ServiceId != "listener-0" {
+		t.Fatalf("datagram to %v dropped, expected listener-0", dropped.ServiceId)
+	}
+}
@@ -0,0 +1,317 @@
+package neti
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayConfig configures a Replayer.
+type ReplayConfig struct {
+	Target     string        //Address of the service under test
+	Transport  TransportType //TCP (also for unix addresses) or UDP, TCP if not set
+	ListenAddr string        //Address the simulated peers listen on, for the connections the node opens, 127.0.0.1:0 if not set
+	Speed      float64       //1 replays with the original timing, 2 twice as fast, ..., 0 as fast as possible
+	Timeout    time.Duration //Time to wait for the expected messages once the frames are replayed, a second if not set
+	BuffSize   int           //Size of the datagrams of UDP, 64KB if not set
+	Options    []Option      //Options of the simulated peers, e.g. the cluster key of the node
+
+	// Match returns whether the node sent the expected message, by default when the service ids, codes and payloads are equal.
+	Match func(expected ServiceFrame, actual ServiceFrame) bool
+}
+
+// ReplayResult is the outcome of a replay.
+type ReplayResult struct {
+	Replayed   int            //Frames replayed to the node
+	Skipped    int            //Frames that are not frames of a service, which are not replayed
+	Expected   []ServiceFrame //Messages sent by the node in the capture
+	Received   []ServiceFrame //Messages sent by the node to the simulated peers, in the order they were received
+	Missing    []ServiceFrame //Expected messages that were not received
+	Unexpected []ServiceFrame //Received messages that were not expected
+}
+
+// Err returns an error describing the missing and unexpected messages, if any.
+func (r *ReplayResult) Err() error {
+	if len(r.Missing) == 0 && len(r.Unexpected) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%v messages missing and %v unexpected", len(r.Missing), len(r.Unexpected))
+	for _, f := range r.Missing {
+		_, _ = fmt.Fprintf(&b, "\n  missing:    %v", f)
+	}
+	for _, f := range r.Unexpected {
+		_, _ = fmt.Fprintf(&b, "\n  unexpected: %v", f)
+	}
+	return errors.New(b.String())
+}
+
+// Replayer replays the frames a node received in a capture (see WithCapture) to a node, from simulated peers
+// standing in for the remote ends, and checks that the node sends the messages it sent in the capture.
+// Replaying the capture of a node of a cluster against a single node reproduces what it went through.
+type Replayer struct {
+	frames []CapturedFrame
+	config ReplayConfig
+}
+
+// NewReplayer creates a Replayer of the frames of a capture of the node under test.
+func NewReplayer(frames []CapturedFrame, config ReplayConfig) *Replayer {
+	if config.Transport == 0 {
+		config.Transport = TCP
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = "127.0.0.1:0"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	if config.BuffSize <= 0 {
+		config.BuffSize = 64 * 1024
+	}
+	if config.Match == nil {
+		config.Match = func(expected ServiceFrame, actual ServiceFrame) bool {
+			return expected.ServiceId == actual.ServiceId && expected.MessageCode == actual.MessageCode &&
+				bytes.Equal(expected.Payload, actual.Payload)
+		}
+	}
+	frames = append([]CapturedFrame(nil), frames...)
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+	return &Replayer{frames: frames, config: config}
+}
+
+// LoadCapture reads the frames of capture files, e.g. path.2, path.1 and path for a rolled capture.
+func LoadCapture(paths ...string) ([]CapturedFrame, error) {
+	var frames []CapturedFrame
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		reader := NewCaptureReader(file)
+		for {
+			f, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				_ = file.Close()
+				return nil, errors.New(fmt.Sprintf("%v: %v", path, err))
+			}
+			frames = append(frames, f)
+		}
+		_ = file.Close()
+	}
+	return frames, nil
+}
+
+// replayPeers are the simulated peers of a replay, sharing a service that records the messages it receives.
+type replayPeers struct {
+	service  NetService
+	net      Net
+	clients  map[string]NetClient
+	conns    map[string]*ServiceHostConn //by remote address and service id
+	lock     *sync.Mutex
+	received []ServiceFrame
+}
+
+// Run replays the frames and waits for the messages of the node, until they were all received or the timeout expires.
+// The returned result tells which were missing or unexpected.
+func (r *Replayer) Run() (*ReplayResult, error) {
+	result := &ReplayResult{}
+	var inputs []CapturedFrame
+	var times []time.Time
+	for _, f := range r.frames {
+		frame, err := ParseServiceFrame(f.Frame)
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		if f.Direction == Sent {
+			result.Expected = append(result.Expected, frame)
+		} else {
+			inputs = append(inputs, f)
+			times = append(times, f.Time)
+		}
+	}
+
+	peers, err := r.startPeers()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = peers.service.Close() }()
+
+	start := time.Now()
+	for i, f := range inputs {
+		if r.config.Speed > 0 {
+			delay := time.Duration(float64(times[i].Sub(times[0])) / r.config.Speed)
+			time.Sleep(time.Until(start.Add(delay)))
+		}
+		frame, _ := ParseServiceFrame(f.Frame)
+		if err := peers.send(r.config.Target, f.Remote, frame, targetId(f, frame)); err != nil {
+			return result, err
+		}
+		result.Replayed++
+	}
+
+	deadline := time.Now().Add(r.config.Timeout)
+	for {
+		result.Received = peers.messages()
+		result.Missing, result.Unexpected = r.compare(result.Expected, result.Received)
+		if len(result.Missing) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return result, nil
+}
+
+// compare matches each received message with an expected one, returning those left over.
+func (r *Replayer) compare(expected []ServiceFrame, received []ServiceFrame) ([]ServiceFrame, []ServiceFrame) {
+	matched := make([]bool, len(expected))
+	var unexpected []ServiceFrame
+	for _, actual := range received {
+		found := false
+		for i, e := range expected {
+			if !matched[i] && r.config.Match(e, actual) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			unexpected = append(unexpected, actual)
+		}
+	}
+	var missing []ServiceFrame
+	for i, e := range expected {
+		if !matched[i] {
+			missing = append(missing, e)
+		}
+	}
+	return missing, unexpected
+}
+
+// startPeers starts the service of the simulated peers, recording the messages of services it receives.
+func (r *Replayer) startPeers() (peers *replayPeers, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Unable to listen on %v: %v", r.config.ListenAddr, p))
+		}
+	}()
+	peers = &replayPeers{
+		clients: make(map[string]NetClient),
+		conns:   make(map[string]*ServiceHostConn),
+		lock:    &sync.Mutex{},
+	}
+	switch r.config.Transport {
+	case TCP:
+		service := InitBaseTcpService(r.config.ListenAddr, logrus.StandardLogger(), r.config.Options...).(*basicTcpService)
+		peers.service, peers.net = service, service.net
+	case UDP:
+		service := InitBaseUdpService(r.config.ListenAddr, r.config.BuffSize, r.config.Options...).(*basicUdpService)
+		peers.service, peers.net = service, service.net
+	default:
+		return nil, errors.New(fmt.Sprintf("Replaying is not supported on transport %v", r.config.Transport))
+	}
+	peers.net.UseRecv(func(next RecvFunc) RecvFunc {
+		return func(conn HostConn, b []byte) (Message, error) {
+			m, err := next(conn, b)
+			if wrap, ok := m.(MessageWrap); ok && err == nil {
+				peers.lock.Lock()
+				peers.received = append(peers.received, ServiceFrame{
+					Trace:       wrap.trace,
+					ServiceId:   wrap.Id,
+					MessageCode: wrap.code,
+					Payload:     wrap.buff.Bytes(),
+				})
+				peers.lock.Unlock()
+			}
+			return nil, err
+		}
+	})
+	for _, f := range r.frames {
+		if frame, err := ParseServiceFrame(f.Frame); err != nil {
+			continue
+		} else if f.Direction == Sent {
+			// the node sends to the NetClients of its peers
+			peers.client(targetId(f, frame))
+		} else {
+			peers.client(frame.ServiceId)
+		}
+	}
+	return peers, nil
+}
+
+// client returns the NetClient of the simulated peers for the service id, reading the connections the node opens to it.
+func (p *replayPeers) client(id string) NetClient {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c, ok := p.clients[id]; ok {
+		return c
+	}
+	c := p.service.RegisterListener(id)
+	p.clients[id] = c
+	if c.Type() != UDP {
+		go func() {
+			for conn := range c.Accept() {
+				go drain(c, conn)
+			}
+		}()
+	}
+	return c
+}
+
+// targetId returns the id of the NetClient the frame f of a service is for.
+// Captures without it are of nodes whose NetClients all have the same id as their peers.
+func targetId(f CapturedFrame, frame ServiceFrame) string {
+	if f.Target != "" {
+		return f.Target
+	}
+	return frame.ServiceId
+}
+
+// send sends the message of frame to the NetClient id of target, on the connection of the simulated peer remote.
+func (p *replayPeers) send(target string, remote string, frame ServiceFrame, id string) error {
+	c := p.client(frame.ServiceId)
+	key := remote + "/" + frame.ServiceId + "/" + id
+	p.lock.Lock()
+	conn, ok := p.conns[key]
+	p.lock.Unlock()
+	if !ok {
+		var err error
+		if conn, err = c.OpenTo(target, id); err != nil {
+			return err
+		}
+		p.lock.Lock()
+		p.conns[key] = conn
+		p.lock.Unlock()
+		if c.Type() != UDP {
+			go drain(c, conn)
+		}
+	}
+	wrap := MessageWrap{Id: frame.ServiceId, code: frame.MessageCode, buff: bytes.NewBuffer(frame.Payload), trace: frame.Trace}
+	return p.net.SendTo(conn, wrap)
+}
+
+func (p *replayPeers) messages() []ServiceFrame {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]ServiceFrame(nil), p.received...)
+}
+
+// drain reads conn until it is closed, its messages are recorded by the interceptor of the simulated peers.
+func drain(c NetClient, conn *ServiceHostConn) {
+	for {
+		if _, err := c.RecvFrom(conn); err != nil {
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// echoNode starts a node whose NetClient echo replies to every message with the sequence number reply(seq).
+func echoNode(t *testing.T, addr string, reply func(uint32) uint32, opts ...Option) NetService {
+	t.Helper()
+	service := InitBaseTcpService(addr, log.StandardLogger(), opts...)
+	t.Cleanup(func() { _ = service.Close() })
+	echo := service.RegisterListener("echo")
+	echo.RegisterMessage(registryMsg{code: 1})
+	go func() {
+		for conn := range echo.Accept() {
+			go func(conn *ServiceHostConn) {
+				for {
+					m, err := echo.RecvFrom(conn)
+					if err != nil {
+						return
+					}
+					_ = echo.SendTo(conn, registryMsg{code: 1, seq: reply(m.(registryMsg).seq)})
+				}
+			}(conn)
+		}
+	}()
+	return service
+}
+
+// recordEcho records the capture of an echo node answering three messages of a peer, returning the capture files.
+func recordEcho(t *testing.T) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "node.jsonl")
+	capture, err := NewCapture(path, CaptureConfig{MaxSize: 1024, MaxFiles: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t, "tcp")
+	node := echoNode(t, addr, func(seq uint32) uint32 { return seq + 100 }, WithCapture(capture))
+	peerService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger())
+	defer peerService.Close()
+	// the NetClient of the peer has its own id, replies are sent to it
+	peer := peerService.RegisterListener("peer")
+	peer.RegisterMessage(registryMsg{code: 1})
+	conn, err := peer.OpenTo(addr, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint32(1); seq <= 3; seq++ {
+		_ = peer.SendTo(conn, registryMsg{code: 1, seq: seq})
+		testutil.Within(t, testTimeout, "the reply", func() {
+			if m, err := peer.RecvFrom(conn); err != nil || m.(registryMsg).seq != seq+100 {
+				t.Errorf("received %v, %v; expected the reply to %v", m, err, seq)
+			}
+		})
+	}
+	_ = conn.Close()
+	_ = node.Close()
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the oldest files first, as rolled
+	var paths []string
+	for _, p := range []string{path + ".5", path + ".4", path + ".3", path + ".2", path + ".1", path} {
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func TestReplay(t *testing.T) {
+	frames, err := LoadCapture(recordEcho(t)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		reply func(uint32) uint32
+		ok    bool
+	}{
+		{"SameNode", func(seq uint32) uint32 { return seq + 100 }, true},
+		{"ChangedNode", func(seq uint32) uint32 { return seq + 200 }, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr := freeAddr(t, "tcp")
+			echoNode(t, addr, test.reply)
+			result, err := NewReplayer(frames, ReplayConfig{Target: addr, Timeout: 500 * time.Millisecond}).Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Replayed != 3 || len(result.Expected) != 3 || len(result.Received) != 3 {
+				t.Fatalf("replayed %v frames, expecting %v messages and receiving %v; expected 3 of each",
+					result.Replayed, len(result.Expected), len(result.Received))
+			}
+			if err := result.Err(); (err == nil) != test.ok {
+				t.Fatalf("the replay failed with %v", err)
+			}
+			if !test.ok && (len(result.Missing) != 3 || len(result.Unexpected) != 3) {
+				t.Fatalf("%v missing and %v unexpected, expected the 3 replies of each", len(result.Missing), len(result.Unexpected))
+			}
+		})
+	}
+}
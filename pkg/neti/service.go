@@ -2,6 +2,8 @@ package neti
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 )
@@ -9,8 +11,10 @@ import (
 type TransportType uint8
 
 const (
-	UDP TransportType = 1
-	TCP TransportType = 2
+	UDP  TransportType = 1
+	TCP  TransportType = 2
+	WS   TransportType = 3
+	QUIC TransportType = 4
 )
 
 // NetClient is an interface for a network client for a NetService.
@@ -18,14 +22,22 @@ const (
 // It can be used to send and receive messages.
 // It can be used to connect to other hosts.
 type NetClient interface {
-	RegisterMessage(message Message)                         //Register Message in the NetClient (Known how to deserialize)
-	RecvFrom(conn *ServiceHostConn) (Message, error)         //Receive Message from ServiceHostConn
-	SendTo(conn *ServiceHostConn, message Message) error     //Send Message to ServiceHostConn
-	OpenTo(addr string, id string) (*ServiceHostConn, error) //Open ServiceHostConn to peer with addr to NetClient NodeID
-	Accept() <-chan *ServiceHostConn                         //Accept Connection
-	Self() string                                            //Self Address
-	Type() TransportType                                     //Transport Type
-	Id() string                                              //NodeID of NetClient
+	RegisterMessage(message Message)                                                 //Register Message in the NetClient (Known how to deserialize)
+	UnregisterMessage(code uint16)                                                   //Stop deserializing the Messages with code
+	RecvFrom(conn *ServiceHostConn) (Message, error)                                 //Receive Message from ServiceHostConn
+	SendTo(conn *ServiceHostConn, message Message) error                             //Send Message to ServiceHostConn
+	RecvFromContext(conn *ServiceHostConn) (context.Context, Message, error)         //Receive Message from ServiceHostConn, with the context of its trace
+	SendToContext(ctx context.Context, conn *ServiceHostConn, message Message) error //Send Message to ServiceHostConn, as part of the trace of ctx
+	OpenTo(addr string, id string) (*ServiceHostConn, error)                         //Open ServiceHostConn to peer with addr (or NodeAddr) to NetClient NodeID
+	Accept() <-chan *ServiceHostConn                                                 //Accept Connection
+	Self() string                                                                    //Self Address
+	Type() TransportType                                                             //Transport Type
+	Id() string                                                                      //NodeID of NetClient
+	PeerEvents() <-chan PeerEvent                                                    //Events of the peers on connections monitored with heartbeats
+	OnPeerEvent(f func(PeerEvent))                                                   //Call f on the events of the peers on connections monitored with heartbeats
+	OpenManaged(addr string, id string, config Reconnect) *ManagedConn               //Open a connection that re-dials the peer whenever it fails
+	UseSend(interceptor SendInterceptor)                                             //Add an interceptor of the messages sent, seeing their payload
+	UseRecv(interceptor RecvInterceptor)                                             //Add an interceptor of the messages received, seeing their payload
 }
 
 // NetService is an interface for a network service for a NetClient.
@@ -33,14 +45,24 @@ type NetClient interface {
 // NetService multiplexes the connections to the NetClient.
 type NetService interface {
 	RegisterListener(id string) NetClient
+	UnregisterListener(id string) //Stop delivering connections and messages to the NetClient id
 	GetConfiguration() Configuration
+	Close() error //Stop listening, removing the socket file for unix addresses
 }
 
 // ServiceHostConn is a HostConn that multiplexes the connections to the NetClient.
 type ServiceHostConn struct {
-	Conn      HostConn
-	ServiceId string
-	Msg       Message
+	Conn        HostConn
+	ServiceId   string
+	Msg         Message
+	Peer        *NodeInfo   //Identity of the node on the other end, when exchanged in the connection handshake
+	Version     uint16      //Protocol version negotiated in the connection handshake
+	Features    Feature     //Features negotiated in the connection handshake
+	Compression Compression //Algorithm compressing the frames sent on the connection, negotiated with FeatureCompression
+
+	monitor *peerMonitor    //Set when the connection is monitored with heartbeats
+	ctx     context.Context //Context of the trace of Msg, for datagram services
+	target  string          //Id of the NetClient the last frame received was for
 }
 
 // String returns the string representation of the ServiceHostConn.
@@ -67,18 +89,33 @@ func (s *ServiceHostConn) Send(b []byte) error {
 
 // Receive receives the bytes from the Host on the other end of the ServiceHostConn.
 func (s *ServiceHostConn) Receive() ([]byte, error) {
-	b, err := s.Conn.Receive()
+	receive := s.Conn.Receive
+	if s.monitor != nil {
+		receive = s.monitor.receive
+	}
+	b, err := receive()
+	if err != nil {
+		return nil, err
+	}
 	buff := bytes.NewBuffer(b)
-	if s.ServiceId, err = DecodeStringFromBuffer(buff); err != nil {
+	if s.target, err = DecodeStringFromBuffer(buff); err != nil {
 		return nil, err
 	}
+	if s.target == "" {
+		return nil, errors.New("Received a frame without a service id")
+	}
 	if s.ServiceId == "" {
-		panic("Service Id is empty")
+		// datagrams are routed by the NetClient they are for,
+		// connections keep the id of the NetClient on the other end, set on the handshake
+		s.ServiceId = s.target
 	}
 	return DecodeBytesFromBuffer(buff)
 }
 
 // Close closes the ServiceHostConn.
 func (s *ServiceHostConn) Close() error {
+	if s.monitor != nil {
+		return s.monitor.close()
+	}
 	return s.Conn.Close()
 }
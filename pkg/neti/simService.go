@@ -1,9 +1,12 @@
 package neti
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"net"
+	"sync"
 )
 
 type simAddr struct {
@@ -47,31 +50,60 @@ func (s *simConn) Close() error {
 }
 
 type simClient struct {
-	id       string
-	service  *simService
-	listenCh chan *ServiceHostConn
+	id          string
+	service     *simService
+	listenCh    chan *ServiceHostConn
+	*peerEvents //never emitted, there are no connections to monitor
+	*interceptors
 }
 
 func (s *simClient) RegisterMessage(message Message) {
 	//noop
 }
 
+func (s *simClient) UnregisterMessage(code uint16) {
+	//noop, messages are not deserialized
+}
+
 func (s *simClient) RecvFrom(conn *ServiceHostConn) (Message, error) {
+	_, msg, err := s.RecvFromContext(conn)
+	return msg, err
+}
+
+// RecvFromContext returns the message delivered on conn, and the context of its trace.
+func (s *simClient) RecvFromContext(conn *ServiceHostConn) (context.Context, Message, error) {
 	if conn.Msg != nil {
-		defer func() { conn.Msg = nil }()
-		return conn.Msg, nil
+		defer func() { conn.Msg, conn.ctx = nil, nil }()
+		return conn.ctx, conn.Msg, nil
 	}
-	return nil, errors.New(fmt.Sprintf("Nothing to receive from connection"))
+	return context.Background(), nil, errors.New(fmt.Sprintf("Nothing to receive from connection"))
 }
 
+// SendTo delivers the message to the client of conn. Messages are not serialized, so interceptors see nil bytes.
 func (s *simClient) SendTo(conn *ServiceHostConn, message Message) error {
-	c := &ServiceHostConn{conn.Conn, s.id, message}
-	go s.service.deliver(c, s.id)
-	return nil
+	return s.SendToContext(context.Background(), conn, message)
+}
+
+// SendToContext delivers the message to the client of conn, propagating the span context of ctx (spans are not recorded).
+func (s *simClient) SendToContext(ctx context.Context, conn *ServiceHostConn, message Message) error {
+	return s.sendChain(func(_ HostConn, message Message, _ []byte) error {
+		c := &ServiceHostConn{Conn: conn.Conn, ServiceId: s.id, Msg: message, ctx: context.Background()}
+		if trace, ok := SpanContextFromContext(ctx); ok {
+			trace.Remote = true
+			c.ctx = ContextWithSpanContext(c.ctx, trace)
+		}
+		go s.service.deliver(c, s.id)
+		return nil
+	})(conn, message, nil)
+}
+
+// OpenManaged opens a ManagedConn to the peer with addr, dialing it in the background.
+func (s *simClient) OpenManaged(addr string, id string, config Reconnect) *ManagedConn {
+	return newManagedConn(s, addr, id, config)
 }
 
 func (s *simClient) OpenTo(addr string, id string) (*ServiceHostConn, error) {
-	conn := &ServiceHostConn{&simConn{addr, simAddr{addr}}, id, nil}
+	conn := &ServiceHostConn{Conn: &simConn{addr, simAddr{addr}}, ServiceId: id}
 	return conn, nil
 }
 
@@ -92,12 +124,19 @@ func (s *simClient) Id() string {
 }
 
 func (s *simClient) deliver(conn *ServiceHostConn) {
-	s.listenCh <- conn
+	msg, err := s.recvChain(func(HostConn, []byte) (Message, error) {
+		return conn.Msg, nil
+	})(conn, nil)
+	if msg != nil && err == nil {
+		conn.Msg = msg
+		s.listenCh <- conn
+	}
 }
 
 type simService struct {
 	protos     map[string]uint64
 	listenners map[string]*simClient
+	lock       *sync.RWMutex //Guards protos and listenners
 }
 
 // NewSimUDPService creates a new SimUDPService
@@ -106,11 +145,14 @@ func NewSimUDPService() NetService {
 	return &simService{
 		protos:     make(map[string]uint64),
 		listenners: make(map[string]*simClient),
+		lock:       &sync.RWMutex{},
 	}
 
 }
 
 func (s *simService) RegisterListener(id string) NetClient {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if _, ok := s.protos[id]; !ok {
 		s.protos[id] = 0
 	}
@@ -122,8 +164,16 @@ func (s *simService) RegisterListener(id string) NetClient {
 	return client
 }
 
+// UnregisterListener removes the NetClient id (with its sequence number, as returned by its Id),
+// the messages sent to it are dropped.
+func (s *simService) UnregisterListener(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.listenners, id)
+}
+
 func (s *simService) newSimNetClient(id string) *simClient {
-	return &simClient{id, s, make(chan *ServiceHostConn)}
+	return &simClient{id, s, make(chan *ServiceHostConn), newPeerEvents(), newInterceptors()}
 }
 
 func (s *simService) GetConfiguration() Configuration {
@@ -131,11 +181,23 @@ func (s *simService) GetConfiguration() Configuration {
 	panic("implement me")
 }
 
+func (s *simService) Close() error {
+	//noop
+	return nil
+}
+
 func (s *simService) deliver(conn *ServiceHostConn, sender_id string) {
 	id := conn.Conn.String()
 	conn.Conn = &simConn{
 		id:   conn.ServiceId,
 		addr: simAddr{conn.ServiceId},
 	}
-	s.listenners[id].deliver(conn)
+	s.lock.RLock()
+	c, ok := s.listenners[id]
+	s.lock.RUnlock()
+	if !ok {
+		log.Warn("Dropping message from ", sender_id, " to the unknown listener ", id)
+		return
+	}
+	c.deliver(conn)
 }
@@ -0,0 +1,23 @@
+package neti
+
+import "sync/atomic"
+
+// Stats counts the events of a Net or NetService worth monitoring.
+// The counters are updated atomically and can be read at any time, pass the same Stats to many Nets to aggregate them.
+type Stats struct {
+	FailedAuthentications atomic.Uint64 //Connections closed because the peer did not prove knowledge of the cluster key
+	UnauthenticatedFrames atomic.Uint64 //Frames dropped because their HMAC did not verify
+	ReplayedFrames        atomic.Uint64 //Frames dropped because their sequence number was already received
+	UndecryptableFrames   atomic.Uint64 //Frames dropped because they could not be decrypted
+	Unauthorized          atomic.Uint64 //Connections and datagrams rejected by the Authorizer of a service
+	RejectedConnections   atomic.Uint64 //Connections closed on accept because of the connection limits
+	DroppedMessages       atomic.Uint64 //Messages dropped, or whose connection was closed, because they exceeded a rate limit
+	DelayedMessages       atomic.Uint64 //Messages held back because they exceeded a rate limit
+}
+
+// WithStats sets the Stats updated by a Net or NetService.
+func WithStats(stats *Stats) Option {
+	return func(o *options) {
+		o.stats = stats
+	}
+}
@@ -8,12 +8,20 @@ import (
 	"github.com/sirupsen/logrus"
 	"net"
 	"sync"
+	"time"
 )
 
 type tcpHostConn struct {
 	conn      net.Conn
 	serviceId string
 	sendLock  *sync.Mutex
+	auth      *streamAuth
+	limiter   *limiter
+	release   func() //releases the connection slot taken from the limiter, if any
+	compress  *frameCompressor
+	events    *EventBus
+	metrics   *Metrics //set once the connection is counted in the open connections
+	closeOnce *sync.Once
 }
 
 func (t tcpHostConn) ServiceId() string {
@@ -28,66 +36,226 @@ func (t tcpHostConn) Addr() net.Addr {
 	return t.conn.RemoteAddr()
 }
 
+// LocalAddr returns the local address of the connection.
+func (t tcpHostConn) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
 func (t tcpHostConn) Send(b []byte) error {
 	t.sendLock.Lock()
 	defer t.sendLock.Unlock()
-	err := binary.Write(t.conn, binary.BigEndian, uint32(len(b)))
+	// frames are compressed before they are sealed, so that receivers only decompress authentic frames
+	header := uint32(0)
+	if c, compressed := t.compress.compress(b); c != NoCompression {
+		b = append([]byte{uint8(c)}, compressed...)
+		header = compressedFrameFlag
+	}
+	if t.auth != nil {
+		b = t.auth.seal(b)
+	}
+	err := binary.Write(t.conn, binary.BigEndian, uint32(len(b))|header)
 	if err != nil {
 		return err
 	}
 	return writeFully(t.conn, b)
 }
 
+func (t tcpHostConn) compressions() []Compression {
+	if t.compress == nil {
+		return nil
+	}
+	return t.compress.config.algorithms
+}
+
+// authenticated returns whether the peer proved knowledge of the cluster key.
+func (t tcpHostConn) authenticated() bool {
+	return t.auth != nil
+}
+
+func (t tcpHostConn) setCompression(c Compression) {
+	if t.compress != nil {
+		t.compress.algorithm.Store(uint32(c))
+	}
+}
+
 func (t tcpHostConn) Close() error {
+	t.closed(nil)
+	if t.release != nil {
+		t.release()
+	}
 	return t.conn.Close()
 }
 
+// closed publishes the ConnectionClosed event of the connection, once.
+func (t tcpHostConn) closed(err error) {
+	if t.closeOnce != nil {
+		t.closeOnce.Do(func() {
+			t.events.publish(Event{Type: ConnectionClosed, Network: t.conn.RemoteAddr().Network(), Remote: t.Addr(), Err: err})
+			t.metrics.connections(t.conn.RemoteAddr().Network(), -1)
+		})
+	}
+}
+
+// dropped publishes the MessageDropped event of a frame received on the connection.
+func (t tcpHostConn) dropped(err error) {
+	t.events.publish(Event{Type: MessageDropped, Network: t.conn.RemoteAddr().Network(), Remote: t.Addr(), Err: err})
+}
+
+// Receive receives the next frame, skipping the frames that fail authentication or decompression,
+// or exceed the peer rate. Heartbeats, empty frames, are not counted in the peer rate.
 func (t tcpHostConn) Receive() ([]byte, error) {
+	for {
+		b, compressed, err := t.receiveFrame()
+		if err != nil {
+			t.closed(err)
+			return nil, err
+		}
+		if t.auth != nil {
+			var ok bool
+			if b, ok = t.auth.open(b); !ok {
+				t.dropped(ErrUnauthenticated)
+				continue
+			}
+		}
+		if t.limiter != nil && (compressed || len(b) > 0) {
+			if ok, err := t.limiter.admitPeer(t.Addr()); err != nil {
+				t.closed(err)
+				_ = t.Close()
+				return nil, err
+			} else if !ok {
+				t.dropped(ErrRateLimited)
+				continue
+			}
+		}
+		if compressed {
+			if b, err = t.decompress(b); err != nil {
+				t.dropped(err)
+				continue
+			}
+		}
+		return b, nil
+	}
+}
+
+// receiveFrame receives the next frame, and whether its header is flagged as compressed.
+func (t tcpHostConn) receiveFrame() ([]byte, bool, error) {
 	var size uint32
 	err := binary.Read(t.conn, binary.BigEndian, &size)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	b, err := readFully(t.conn, int(size&^compressedFrameFlag))
+	return b, size&compressedFrameFlag != 0, err
+}
+
+// decompress decompresses a frame flagged as compressed, which are only accepted with compression enabled.
+func (t tcpHostConn) decompress(b []byte) ([]byte, error) {
+	if t.compress == nil {
+		return nil, errCompressionDisabled
+	}
+	if len(b) == 0 {
+		return nil, errors.New("invalid compressed frame")
 	}
-	return readFully(t.conn, int(size))
+	return Compression(b[0]).decompress(b[1:])
 }
 
-func NewTcpNet(log *logrus.Logger) Net {
+func NewTcpNet(log *logrus.Logger, opts ...Option) Net {
+	return newStreamNet("tcp", log, newOptions(opts))
+}
+
+// NewUnixNet creates a Net over unix domain stream sockets.
+// It uses the same framing as NewTcpNet, addresses are socket paths.
+func NewUnixNet(log *logrus.Logger, opts ...Option) Net {
+	return newStreamNet("unix", log, newOptions(opts))
+}
+
+func newStreamNet(network string, log *logrus.Logger, opts *options) Net {
 	return &tcp{
+		network:          network,
 		listener:         nil,
-		msgDeserializers: make(map[uint16]MessageDeserializer),
+		msgDeserializers: newDeserializers(),
 		log:              log,
+		auth:             newClusterAuth(opts),
+		limiter:          newLimiter(opts),
+		compression:      opts.compression,
+		events:           opts.events,
+		metrics:          opts.metrics,
+		capture:          opts.capture,
+		interceptors:     newInterceptors(),
 	}
 }
 
 type tcp struct {
+	network          string
 	listener         net.Listener
-	msgDeserializers map[uint16]MessageDeserializer
+	msgDeserializers *deserializers
 	log              *logrus.Logger
+	auth             *clusterAuth
+	limiter          *limiter
+	compression      *compressionConfig
+	events           *EventBus
+	metrics          *Metrics
+	capture          *Capture
+	*interceptors
 }
 
 func (t tcp) RegisterMessage(message Message) {
-	if _, ok := t.msgDeserializers[message.Code()]; !ok {
-		t.msgDeserializers[message.Code()] = message.Deserialize
-	} else {
+	if !t.msgDeserializers.register(message) {
 		t.log.Warn(fmt.Sprintf("Message with Id: %v already registered, ignoring message: %v", message.Code(), message))
 	}
 }
 
+// UnregisterMessage stops deserializing the messages with code, which are then received as unknown codes.
+func (t tcp) UnregisterMessage(code uint16) {
+	t.msgDeserializers.unregister(code)
+}
+
 func (t tcp) CloseListener() error {
 	return t.listener.Close()
 }
 
 func (t tcp) Open(addr string) (HostConn, error) {
-	conn, err := net.Dial("tcp", addr)
+	network, addr := splitNetworkAddr(addr, t.network)
+	start := time.Now()
+	conn, err := net.Dial(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	hConn := tcpHostConn{conn: conn, sendLock: &sync.Mutex{}}
+	hConn := t.newHostConn(conn)
+	if t.auth != nil {
+		if hConn.auth, err = t.auth.clientHandshake(hConn); err != nil {
+			t.events.publish(Event{Type: HandshakeFailed, Network: network, Remote: conn.RemoteAddr(), Err: err})
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	hConn.limiter = t.limiter
+	t.events.publish(Event{Type: ConnectionOpened, Network: network, Remote: conn.RemoteAddr()})
+	t.metrics.dialed(network, time.Since(start))
+	t.opened(&hConn)
 
 	return hConn, err
 }
 
+// opened counts hConn in the open connections, until it is closed.
+func (t tcp) opened(hConn *tcpHostConn) {
+	if t.metrics != nil {
+		hConn.metrics = t.metrics
+		t.metrics.connections(hConn.Addr().Network(), 1)
+	}
+}
+
+func (t tcp) newHostConn(conn net.Conn) tcpHostConn {
+	return tcpHostConn{
+		conn:      conn,
+		sendLock:  &sync.Mutex{},
+		compress:  newFrameCompressor(t.compression),
+		events:    t.events,
+		closeOnce: &sync.Once{},
+	}
+}
+
 func (t tcp) OpenAsync(addr string, ch chan<- ReceivedConnection) {
 	go func() {
 		conn, err := t.Open(addr)
@@ -99,15 +267,36 @@ func (t tcp) OpenAsync(addr string, ch chan<- ReceivedConnection) {
 	}()
 }
 
+// recvAndDeserialize receives the next frame that is not dropped by the interceptors, and deserializes it.
 func (t tcp) recvAndDeserialize(conn HostConn) (Message, error) {
-	b, err := conn.Receive()
-	if err != nil {
-		return nil, err
+	recv := t.recvChain(t.deserialize)
+	for {
+		b, err := conn.Receive()
+		if err != nil {
+			return nil, err
+		}
+		if msg, err := recv(conn, b); msg != nil || err != nil {
+			return msg, err
+		}
+	}
+}
+
+func (t tcp) deserialize(conn HostConn, b []byte) (Message, error) {
+	if len(b) < binary.Size(uint16(0)) {
+		t.events.publish(Event{Type: DecodeFailed, Network: t.network, Remote: conn.Addr()})
+		return nil, errors.New(fmt.Sprint("Frame of ", len(b), " bytes is too short for a message code"))
 	}
 	code := binary.BigEndian.Uint16(b)
-	if d, ok := t.msgDeserializers[code]; ok {
-		return d(bytes.NewBuffer(b[binary.Size(code):]))
+	if d, ok := t.msgDeserializers.lookup(code); ok {
+		msg, err := d(bytes.NewBuffer(b[binary.Size(code):]))
+		if err == nil {
+			t.metrics.received(conn, msg, len(b))
+		}
+		t.capture.record(Received, conn, msg, b)
+		return msg, err
 	}
+	t.capture.record(Received, conn, nil, b)
+	t.events.publish(Event{Type: DecodeFailed, Network: t.network, Remote: conn.Addr(), Code: code})
 	return nil, errors.New(fmt.Sprintln("Unknown Msg code", code))
 }
 
@@ -144,7 +333,20 @@ func (t tcp) SendTo(conn HostConn, message Message) error {
 		"to":   conn.Addr().String(),
 		"size": len(payloadBytes),
 	}).Debug("Sending")
-	return conn.Send(buf.Bytes())
+	return t.sendChain(sendFrame(t.metrics, t.capture))(conn, message, buf.Bytes())
+}
+
+// sendFrame returns the innermost SendFunc of a Net, sending the frame on the connection.
+func sendFrame(metrics *Metrics, capture *Capture) SendFunc {
+	return func(conn HostConn, message Message, b []byte) error {
+		start := time.Now()
+		if err := conn.Send(b); err != nil {
+			return err
+		}
+		metrics.sent(conn, message, len(b), time.Since(start))
+		capture.record(Sent, conn, message, b)
+		return nil
+	}
 }
 
 func (t tcp) SendToAsync(conn HostConn, message Message, ch chan<- SentMessage) {
@@ -155,26 +357,69 @@ func (t tcp) SendToAsync(conn HostConn, message Message, ch chan<- SentMessage)
 }
 
 func (t *tcp) Listen(addr string) (<-chan HostConn, error) {
+	network, addr := splitNetworkAddr(addr, t.network)
+	if network == "unix" {
+		if err := removeStaleSocket(network, addr); err != nil {
+			return nil, err
+		}
+	}
 
-	listener, err := net.Listen("tcp", addr)
+	listener, err := net.Listen(network, addr)
 	if err != nil {
 		return nil, err
 	}
 
 	ch := make(chan HostConn)
 	t.listener = listener
+	t.events.publish(Event{Type: ListenerStarted, Network: network, Local: listener.Addr()})
 	go func() {
+		handshakes := &sync.WaitGroup{}
 		for {
 			conn, err := t.listener.Accept()
 			if err != nil {
-				fmt.Println("Error on accept", err.Error())
+				if errors.Is(err, net.ErrClosed) {
+					t.log.Debug("Listener closed: ", err)
+				} else {
+					t.log.Warn("Error on accept: ", err)
+				}
+				handshakes.Wait()
+				t.events.publish(Event{Type: ListenerStopped, Network: network, Local: listener.Addr(), Err: err})
 				close(ch)
 				return
-			} else {
-				ch <- tcpHostConn{
-					conn:     conn,
-					sendLock: &sync.Mutex{},
+			}
+			hConn := t.newHostConn(conn)
+			if t.limiter != nil {
+				release, ok := t.limiter.acquireConn(conn.RemoteAddr())
+				if !ok {
+					t.log.Warn("Rejecting connection from ", hConn, ": connection limit reached")
+					hConn.closed(ErrConnectionLimit)
+					_ = conn.Close()
+					continue
 				}
+				hConn.release = release
+			}
+			if t.auth == nil {
+				hConn.limiter = t.limiter
+				t.events.publish(Event{Type: ConnectionAccepted, Network: network, Remote: conn.RemoteAddr()})
+				t.opened(&hConn)
+				ch <- hConn
+			} else {
+				handshakes.Add(1)
+				go func(hConn tcpHostConn) {
+					defer handshakes.Done()
+					if auth, err := t.auth.serverHandshake(hConn); err != nil {
+						t.log.Warn("Rejecting connection from ", hConn, ": ", err)
+						t.events.publish(Event{Type: HandshakeFailed, Network: network, Remote: hConn.Addr(), Err: err})
+						hConn.closed(err)
+						_ = hConn.Close()
+					} else {
+						hConn.auth = auth
+						hConn.limiter = t.limiter
+						t.events.publish(Event{Type: ConnectionAccepted, Network: network, Remote: hConn.Addr()})
+						t.opened(&hConn)
+						ch <- hConn
+					}
+				}(hConn)
 			}
 		}
 	}()
@@ -0,0 +1,304 @@
+package neti
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span of a trace, as propagated by the W3C trace context (https://www.w3.org/TR/trace-context/).
+// Its fields are those of the SpanContext of OpenTelemetry, so that converting between them is a copy.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte //Trace flags, 1 if sampled
+	Remote  bool //The span was started by a peer and received in a message
+}
+
+// IsValid returns whether the trace and span ids are set.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != [16]byte{} && s.SpanID != [8]byte{}
+}
+
+// TraceParent returns the traceparent header of the span context.
+func (s SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", s.TraceID, s.SpanID, s.Flags)
+}
+
+// String returns the traceparent header of the span context.
+func (s SpanContext) String() string {
+	return s.TraceParent()
+}
+
+// ParseTraceParent parses a traceparent header, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceParent(traceParent string) (SpanContext, error) {
+	var s SpanContext
+	parts := strings.Split(traceParent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return s, errors.New(fmt.Sprintf("Invalid traceparent %q", traceParent))
+	}
+	traceId, err1 := hex.DecodeString(parts[1])
+	spanId, err2 := hex.DecodeString(parts[2])
+	flags, err3 := strconv.ParseUint(parts[3], 16, 8)
+	if err1 != nil || err2 != nil || err3 != nil || len(traceId) != len(s.TraceID) || len(spanId) != len(s.SpanID) || len(parts[3]) != 2 {
+		return s, errors.New(fmt.Sprintf("Invalid traceparent %q", traceParent))
+	}
+	copy(s.TraceID[:], traceId)
+	copy(s.SpanID[:], spanId)
+	s.Flags = byte(flags)
+	if !s.IsValid() {
+		return s, errors.New(fmt.Sprintf("Invalid traceparent %q", traceParent))
+	}
+	return s, nil
+}
+
+const traceContextSize = 1 + 16 + 8 + 1 //version, trace id, span id, flags
+
+// encodeSpanContext writes the span context in the binary layout of the traceparent header.
+func encodeSpanContext(s SpanContext, buff *bytes.Buffer) {
+	buff.WriteByte(0)
+	buff.Write(s.TraceID[:])
+	buff.Write(s.SpanID[:])
+	buff.WriteByte(s.Flags)
+}
+
+func decodeSpanContext(buff *bytes.Buffer) (SpanContext, error) {
+	var s SpanContext
+	b := buff.Next(traceContextSize)
+	if len(b) != traceContextSize || b[0] == 0xff {
+		return s, errors.New("invalid trace context")
+	}
+	copy(s.TraceID[:], b[1:17])
+	copy(s.SpanID[:], b[17:25])
+	s.Flags = b[25]
+	s.Remote = true
+	if !s.IsValid() {
+		return s, errors.New("invalid trace context")
+	}
+	return s, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns ctx carrying the span context, the parent of the spans started with it.
+func ContextWithSpanContext(ctx context.Context, s SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, s)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	s, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return s, ok && s.IsValid()
+}
+
+// SpanKind is the kind of a span, with the values of OpenTelemetry.
+type SpanKind uint8
+
+const (
+	SpanKindProducer SpanKind = 4 //The span of a message sent
+	SpanKindConsumer SpanKind = 5 //The span of a message received
+)
+
+// String returns the name of the span kind.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindProducer:
+		return "producer"
+	case SpanKindConsumer:
+		return "consumer"
+	default:
+		return "unknown"
+	}
+}
+
+// Attribute is a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Tracer starts the spans of the messages sent and received by NetClients.
+// NewTracer returns a Tracer exporting to a SpanExporter, and a Tracer can be implemented on top of OpenTelemetry:
+// the parent of a span is the span context of ctx (SpanContextFromContext), which is Remote for the messages received.
+type Tracer interface {
+	// Start starts a span, returning ctx carrying it.
+	Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SpanContext() SpanContext
+	RecordError(err error)
+	End()
+}
+
+// WithTracing sets the Tracer starting spans on the messages sent and received by the NetClients of a NetService.
+// The span context of the messages is carried in their frames, so that a request can be followed through every node.
+func WithTracing(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithDatagramTracing sends the span contexts of the messages of datagram services in their datagrams,
+// which there is no handshake to negotiate: every node must run a version supporting tracing, as the others fail
+// to decode traced datagrams. Without it, datagram services still start spans and receive traced datagrams,
+// but send the messages without their span context.
+func WithDatagramTracing() Option {
+	return func(o *options) {
+		o.datagramTracing = true
+	}
+}
+
+// SpanData is an ended span, as exported by a Tracer created with NewTracer.
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	Parent      SpanContext //Invalid for the root span of a trace
+	Start       time.Time
+	End         time.Time
+	Attributes  []Attribute
+	Err         error
+}
+
+// SpanExporter receives the spans of a Tracer created with NewTracer, once they end.
+type SpanExporter interface {
+	Export(span SpanData)
+}
+
+// NewTracer creates a Tracer generating random ids, and exporting the spans to exporter once they end.
+func NewTracer(exporter SpanExporter) Tracer {
+	return &tracer{exporter: exporter}
+}
+
+type tracer struct {
+	exporter SpanExporter
+}
+
+func (t *tracer) Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, Span) {
+	s := &span{
+		exporter: t.exporter,
+		once:     &sync.Once{},
+		lock:     &sync.Mutex{},
+		data:     SpanData{Name: name, Kind: kind, Start: time.Now(), Attributes: attributes},
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.data.Parent = parent
+		s.data.SpanContext.TraceID = parent.TraceID
+		s.data.SpanContext.Flags = parent.Flags
+	} else {
+		_, _ = rand.Read(s.data.SpanContext.TraceID[:])
+		s.data.SpanContext.Flags = 1
+	}
+	_, _ = rand.Read(s.data.SpanContext.SpanID[:])
+	return ContextWithSpanContext(ctx, s.data.SpanContext), s
+}
+
+type span struct {
+	exporter SpanExporter
+	once     *sync.Once
+	lock     *sync.Mutex
+	data     SpanData
+}
+
+func (s *span) SpanContext() SpanContext {
+	return s.data.SpanContext
+}
+
+func (s *span) RecordError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Err = err
+}
+
+func (s *span) End() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		s.data.End = time.Now()
+		data := s.data
+		s.lock.Unlock()
+		s.exporter.Export(data)
+	})
+}
+
+// InMemoryExporter keeps the spans exported to it, e.g. to check the spans of a test.
+type InMemoryExporter struct {
+	lock  *sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter creates an InMemoryExporter without spans.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{lock: &sync.Mutex{}}
+}
+
+// Export keeps span.
+func (e *InMemoryExporter) Export(span SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the spans exported, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset discards the spans exported.
+func (e *InMemoryExporter) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = nil
+}
+
+// sendTraced calls send with the span context of message, sent on conn by the NetClient id, ending its span once sent.
+// Without a tracer, the span context of ctx is propagated as is.
+func sendTraced(ctx context.Context, tracer Tracer, conn *ServiceHostConn, id string, message Message, send func(SpanContext) error) error {
+	if tracer == nil {
+		trace, _ := SpanContextFromContext(ctx)
+		return send(trace)
+	}
+	_, s := tracer.Start(ctx, "send "+message.Name(), SpanKindProducer, spanAttributes(conn, id, message)...)
+	err := send(s.SpanContext())
+	if err != nil {
+		s.RecordError(err)
+	}
+	s.End()
+	return err
+}
+
+// recvSpan records the span of message received on conn by the NetClient id, with the span context of its frame,
+// returning the context of the application handling it.
+func recvSpan(tracer Tracer, remote SpanContext, conn *ServiceHostConn, id string, message Message) context.Context {
+	ctx := context.Background()
+	if remote.IsValid() {
+		ctx = ContextWithSpanContext(ctx, remote)
+	}
+	if tracer == nil {
+		return ctx
+	}
+	ctx, s := tracer.Start(ctx, "receive "+message.Name(), SpanKindConsumer, spanAttributes(conn, id, message)...)
+	s.End()
+	return ContextWithSpanContext(ctx, s.SpanContext())
+}
+
+func spanAttributes(conn *ServiceHostConn, id string, message Message) []Attribute {
+	return []Attribute{
+		{"messaging.system", "neti"},
+		{"network.transport", conn.Addr().Network()},
+		{"network.peer.address", conn.Addr().String()},
+		{"neti.service", id},
+		{"neti.message.code", strconv.Itoa(int(message.Code()))},
+		{"neti.message.name", message.Name()},
+	}
+}
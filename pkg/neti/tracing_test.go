@@ -0,0 +1,120 @@
+package neti
+
+import (
+	"encoding/binary"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	s, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" || s.Flags != 1 {
+		t.Fatalf("parsed %v", s)
+	}
+	for _, invalid := range []string{
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+	} {
+		if _, err := ParseTraceParent(invalid); err == nil {
+			t.Fatalf("parsed the invalid traceparent %q", invalid)
+		}
+	}
+}
+
+// spanOf returns the span of exporter of the given kind.
+func spanOf(t *testing.T, exporter *InMemoryExporter, kind SpanKind) SpanData {
+	t.Helper()
+	for _, s := range exporter.Spans() {
+		if s.Kind == kind {
+			return s
+		}
+	}
+	t.Fatalf("no %v span in %v", kind, exporter.Spans())
+	return SpanData{}
+}
+
+func TestTcpTracePropagation(t *testing.T) {
+	senderSpans, receiverSpans := NewInMemoryExporter(), NewInMemoryExporter()
+	addr := freeAddr(t, "tcp")
+	service := InitBaseTcpService(addr, log.StandardLogger(), WithTracing(NewTracer(receiverSpans)))
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseTcpService(freeAddr(t, "tcp"), log.StandardLogger(), WithTracing(NewTracer(senderSpans)))
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+	conn, err := sender.OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := sender.SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Within(t, testTimeout, "the message", func() {
+		ctx, _, err := receiver.RecvFromContext(<-receiver.Accept())
+		if err != nil {
+			t.Error(err)
+		} else if s, ok := SpanContextFromContext(ctx); !ok || s != spanOf(t, receiverSpans, SpanKindConsumer).SpanContext {
+			t.Errorf("received in the context of %v, expected the receive span", s)
+		}
+	})
+	sent, received := spanOf(t, senderSpans, SpanKindProducer), spanOf(t, receiverSpans, SpanKindConsumer)
+	if received.Parent.SpanID != sent.SpanContext.SpanID || received.SpanContext.TraceID != sent.SpanContext.TraceID || !received.Parent.Remote {
+		t.Fatalf("received in %+v, expected a child of the remote send span %+v", received, sent)
+	}
+}
+
+func TestUdpTracePropagation(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		opts       []Option
+		propagated bool
+	}{
+		{"Disabled", nil, false},
+		{"WithDatagramTracing", []Option{WithDatagramTracing()}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			senderSpans, receiverSpans := NewInMemoryExporter(), NewInMemoryExporter()
+			addr := freeAddr(t, "udp")
+			service := InitBaseUdpService(addr, 1024, WithTracing(NewTracer(receiverSpans)))
+			defer service.Close()
+			receiver := service.RegisterListener("receiver")
+			receiver.RegisterMessage(registryMsg{code: 1})
+			code := &atomic.Uint32{}
+			service.(*basicUdpService).net.UseRecv(func(next RecvFunc) RecvFunc {
+				return func(conn HostConn, b []byte) (Message, error) {
+					code.Store(uint32(binary.BigEndian.Uint16(b)))
+					return next(conn, b)
+				}
+			})
+			senderService := InitBaseUdpService(freeAddr(t, "udp"), 1024, append(test.opts, WithTracing(NewTracer(senderSpans)))...)
+			defer senderService.Close()
+			sender := senderService.RegisterListener("sender")
+			conn, err := sender.OpenTo(addr, "receiver")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sender.SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+				t.Fatal(err)
+			}
+			testutil.Within(t, testTimeout, "the message", func() { <-receiver.Accept() })
+
+			// without WithDatagramTracing, the datagram is readable by nodes unaware of tracing
+			if traced := code.Load() == uint32(tracedWrapCode); traced != test.propagated {
+				t.Fatalf("sent the datagram with code %v", code.Load())
+			}
+			sent, received := spanOf(t, senderSpans, SpanKindProducer), spanOf(t, receiverSpans, SpanKindConsumer)
+			if childOf := received.Parent.SpanID == sent.SpanContext.SpanID; childOf != test.propagated {
+				t.Fatalf("received in %+v, sent in %+v", received, sent)
+			}
+		})
+	}
+}
@@ -7,12 +7,16 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
+	"os"
 )
 
 type udpHostConn struct {
-	b    []byte
-	addr net.Addr
-	conn net.PacketConn
+	b        []byte
+	addr     net.Addr
+	conn     net.PacketConn
+	auth     *datagramAuth
+	cipher   *datagramCipher
+	compress *frameCompressor
 }
 
 func (u udpHostConn) String() string {
@@ -23,7 +27,22 @@ func (u udpHostConn) Addr() net.Addr {
 	return u.addr
 }
 
+// LocalAddr returns the address of the socket of the Net.
+func (u udpHostConn) LocalAddr() net.Addr {
+	return u.conn.LocalAddr()
+}
+
 func (u udpHostConn) Send(b []byte) error {
+	if c, compressed := u.compress.compress(b); c != NoCompression {
+		b = append(binary.BigEndian.AppendUint16(nil, compressedCode), uint8(c))
+		b = append(b, compressed...)
+	}
+	if u.cipher != nil {
+		b = u.cipher.seal(b)
+	}
+	if u.auth != nil {
+		b = u.auth.seal(b, u.addr)
+	}
 	n, err := u.conn.WriteTo(b, u.addr)
 	if n != len(b) && err == nil {
 		return errors.New(fmt.Sprint("Expected to send ", len(b), " bytes, sent ", n))
@@ -42,50 +61,130 @@ func (u udpHostConn) Close() error {
 	return nil
 }
 
-func NewUdpNet(buffsize int) Net {
+func NewUdpNet(buffsize int, opts ...Option) Net {
+	return newPacketNet("udp", buffsize, newOptions(opts))
+}
+
+// NewUnixgramNet creates a Net over unix domain datagram sockets.
+// It uses the same framing as NewUdpNet, addresses are socket paths.
+// As with UDP, Listen must be called before Open so that peers have a path to reply to.
+func NewUnixgramNet(buffsize int, opts ...Option) Net {
+	return newPacketNet("unixgram", buffsize, newOptions(opts))
+}
+
+func newPacketNet(network string, buffsize int, opts *options) Net {
 	return &udp{
+		network:          network,
 		conn:             nil,
-		msgDeserializers: make(map[uint16]MessageDeserializer),
+		msgDeserializers: newDeserializers(),
 		buffsize:         buffsize,
+		auth:             newDatagramAuth(opts),
+		cipher:           newDatagramCipher(opts),
+		limiter:          newLimiter(opts),
+		compress:         newFrameCompressor(opts.compression),
+		events:           opts.events,
+		metrics:          opts.metrics,
+		capture:          opts.capture,
+		interceptors:     newInterceptors(),
 	}
 }
 
 type udp struct {
+	network          string
+	path             string
 	conn             net.PacketConn
-	msgDeserializers map[uint16]MessageDeserializer
+	msgDeserializers *deserializers
 	buffsize         int
+	auth             *datagramAuth
+	cipher           *datagramCipher
+	limiter          *limiter
+	compress         *frameCompressor
+	events           *EventBus
+	metrics          *Metrics
+	capture          *Capture
+	*interceptors
 }
 
 func (u udp) RegisterMessage(message Message) {
-	if _, ok := u.msgDeserializers[message.Code()]; !ok {
-		u.msgDeserializers[message.Code()] = message.Deserialize
-	} else {
+	if message.Code() == compressedCode {
+		log.Error(fmt.Sprintf("Message code %v is reserved, ignoring message: %v", message.Code(), message))
+	} else if !u.msgDeserializers.register(message) {
 		log.Warn(fmt.Sprintf("Message with Id: %v already registered, ignoring message: %v", message.Code(), message))
 	}
 }
 
+// UnregisterMessage stops deserializing the messages with code, which are then received as unknown codes.
+func (u udp) UnregisterMessage(code uint16) {
+	u.msgDeserializers.unregister(code)
+}
+
 func (u *udp) Listen(addr string) (<-chan HostConn, error) {
-	conn, err := net.ListenPacket("udp", addr)
+	network, addr := splitNetworkAddr(addr, u.network)
+	if network == "unixgram" {
+		if err := removeStaleSocket(network, addr); err != nil {
+			return nil, err
+		}
+		u.path = addr
+	}
+	conn, err := net.ListenPacket(network, addr)
 	if err != nil {
 		return nil, err
 	}
 	u.conn = conn
 	ch := make(chan HostConn)
+	u.events.publish(Event{Type: ListenerStarted, Network: network, Local: conn.LocalAddr()})
 	go func() {
 		for {
 			p := make([]byte, u.buffsize)
-			_, addr, err := u.conn.ReadFrom(p)
+			n, addr, err := u.conn.ReadFrom(p)
 			if err != nil {
-				fmt.Println("Error on receive", err.Error())
+				if errors.Is(err, net.ErrClosed) {
+					log.Debug("Listener closed: ", err)
+				} else {
+					log.Warn("Error on receive: ", err)
+				}
+				u.events.publish(Event{Type: ListenerStopped, Network: network, Local: conn.LocalAddr(), Err: err})
 				close(ch)
 				return
-			} else {
-				ch <- udpHostConn{
-					conn: u.conn,
-					addr: addr,
-					b:    p,
+			}
+			p = p[:n]
+			var ok bool
+			if u.auth != nil {
+				if p, ok = u.auth.open(p, u.conn.LocalAddr()); !ok {
+					log.Debug("Dropping unauthenticated datagram from ", addr)
+					u.events.publish(Event{Type: MessageDropped, Network: network, Remote: addr, Err: ErrUnauthenticated})
+					continue
+				}
+			}
+			if u.cipher != nil {
+				if p, ok = u.cipher.open(p); !ok {
+					log.Debug("Dropping undecryptable datagram from ", addr)
+					u.events.publish(Event{Type: MessageDropped, Network: network, Remote: addr, Err: ErrUndecryptable})
+					continue
+				}
+			}
+			// the rate is charged once the datagram is authentic, so that forged source addresses do not exhaust it
+			if u.limiter != nil && !u.limiter.allowPeer(addr) {
+				log.Debug("Dropping datagram over the rate limit from ", addr)
+				u.events.publish(Event{Type: MessageDropped, Network: network, Remote: addr, Err: ErrRateLimited})
+				continue
+			}
+			// without compression, compressed datagrams fail to deserialize since their code is reserved
+			if u.compress != nil {
+				if p, err = decompressDatagram(p); err != nil {
+					log.Debug("Dropping undecompressable datagram from ", addr, ": ", err)
+					u.events.publish(Event{Type: MessageDropped, Network: network, Remote: addr, Err: err})
+					continue
 				}
 			}
+			ch <- udpHostConn{
+				conn:     u.conn,
+				addr:     addr,
+				b:        p,
+				auth:     u.auth,
+				cipher:   u.cipher,
+				compress: u.compress,
+			}
 		}
 	}()
 
@@ -93,7 +192,14 @@ func (u *udp) Listen(addr string) (<-chan HostConn, error) {
 }
 
 func (u udp) CloseListener() error {
-	return u.conn.Close()
+	err := u.conn.Close()
+	if u.path != "" {
+		// unlike stream listeners, datagram sockets do not unlink their path on close
+		if rErr := os.Remove(u.path); rErr != nil && err == nil && !os.IsNotExist(rErr) {
+			err = rErr
+		}
+	}
+	return err
 }
 
 func (u udp) Open(addr string) (HostConn, error) {
@@ -101,18 +207,29 @@ func (u udp) Open(addr string) (HostConn, error) {
 		return nil, errors.New("no socket ready for UDP, call Listen first")
 	}
 
-	_addr, err := net.ResolveUDPAddr("udp", addr)
+	_addr, err := u.resolve(addr)
 	if err != nil {
 		return nil, err
 	}
 	return udpHostConn{
-		addr: _addr,
-		conn: u.conn,
-		b:    nil,
+		addr:     _addr,
+		conn:     u.conn,
+		b:        nil,
+		auth:     u.auth,
+		cipher:   u.cipher,
+		compress: u.compress,
 	}, nil
 
 }
 
+func (u udp) resolve(addr string) (net.Addr, error) {
+	network, addr := splitNetworkAddr(addr, u.network)
+	if network == "unixgram" {
+		return net.ResolveUnixAddr(network, addr)
+	}
+	return net.ResolveUDPAddr(network, addr)
+}
+
 func (u udp) OpenAsync(addr string, ch chan<- ReceivedConnection) {
 	go func() {
 		h, err := u.Open(addr)
@@ -120,20 +237,42 @@ func (u udp) OpenAsync(addr string, ch chan<- ReceivedConnection) {
 	}()
 }
 
+// decompressDatagram returns the frame of the datagram p, decompressing it if it was sent compressed.
+func decompressDatagram(p []byte) ([]byte, error) {
+	if len(p) > 2 && binary.BigEndian.Uint16(p) == compressedCode {
+		return Compression(p[2]).decompress(p[3:])
+	}
+	return p, nil
+}
+
 func (u udp) recvAndDeserialize(conn HostConn) (Message, error) {
 	b, err := conn.Receive()
 	if err != nil {
-		panic(err)
 		return nil, err
 	}
-	code := binary.BigEndian.Uint16(b)
-	if err != nil {
-		panic(err)
-		return nil, err
+	msg, err := u.recvChain(u.deserialize)(conn, b)
+	if msg == nil && err == nil {
+		return nil, ErrDropped
 	}
-	if d, ok := u.msgDeserializers[code]; ok {
-		return d(bytes.NewBuffer(b[binary.Size(code):]))
+	return msg, err
+}
+
+func (u udp) deserialize(conn HostConn, b []byte) (Message, error) {
+	if len(b) < binary.Size(uint16(0)) {
+		u.events.publish(Event{Type: DecodeFailed, Network: u.network, Remote: conn.Addr()})
+		return nil, errors.New(fmt.Sprint("Frame of ", len(b), " bytes is too short for a message code"))
 	}
+	code := binary.BigEndian.Uint16(b)
+	if d, ok := u.msgDeserializers.lookup(code); ok {
+		msg, err := d(bytes.NewBuffer(b[binary.Size(code):]))
+		if err == nil {
+			u.metrics.received(conn, msg, len(b))
+		}
+		u.capture.record(Received, conn, msg, b)
+		return msg, err
+	}
+	u.capture.record(Received, conn, nil, b)
+	u.events.publish(Event{Type: DecodeFailed, Network: u.network, Remote: conn.Addr(), Code: code})
 	return nil, errors.New(fmt.Sprintln("Unknown Msg code", code))
 }
 
@@ -167,8 +306,7 @@ func (u udp) SendTo(conn HostConn, message Message) error {
 	if err != nil {
 		return err
 	}
-	err = conn.Send(buf.Bytes())
-	return err
+	return u.sendChain(sendFrame(u.metrics, u.capture))(conn, message, buf.Bytes())
 }
 
 func (u udp) SendToAsync(conn HostConn, message Message, ch chan<- SentMessage) {
@@ -0,0 +1,139 @@
+package neti
+
+import (
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// staleSocket leaves a socket file of network at path, as a process killed before closing its listener would.
+func staleSocket(t *testing.T, network string, path string) {
+	t.Helper()
+	if network == "unix" {
+		l, err := net.ListenUnix(network, &net.UnixAddr{Name: path, Net: network})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.SetUnlinkOnClose(false)
+		_ = l.Close()
+		return
+	}
+	// datagram sockets do not unlink their path on close
+	c, err := net.ListenUnixgram(network, &net.UnixAddr{Name: path, Net: network})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+}
+
+func TestUnixListenRemovesStaleSocket(t *testing.T) {
+	for _, n := range []struct {
+		network string
+		net     Net
+	}{{"unix", NewUnixNet(log.StandardLogger())}, {"unixgram", NewUnixgramNet(1024)}} {
+		t.Run(n.network, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sock")
+			staleSocket(t, n.network, path)
+			if _, err := n.net.Listen(path); err != nil {
+				t.Fatalf("Listen on a stale socket: %v", err)
+			}
+			if err := n.net.CloseListener(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("socket file left after CloseListener: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnixListenKeepsLiveSocket(t *testing.T) {
+	t.Run("unix", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sock")
+		live, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer live.Close()
+		if _, err := NewUnixNet(log.StandardLogger()).Listen(path); err == nil {
+			t.Fatal("Listen succeeded on a socket another listener is using")
+		}
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatalf("the live socket was removed: %v", err)
+		}
+		_ = conn.Close()
+	})
+	t.Run("unixgram", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sock")
+		live, err := net.ListenPacket("unixgram", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer live.Close()
+		if _, err := NewUnixgramNet(1024).Listen(path); err == nil {
+			t.Fatal("Listen succeeded on a socket another process is bound to")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("the live socket was removed: %v", err)
+		}
+	})
+	t.Run("NotASocket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewUnixNet(log.StandardLogger()).Listen(path); err == nil {
+			t.Fatal("Listen succeeded on a regular file")
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("the regular file was removed: %v", err)
+		}
+	})
+}
+
+func TestUnixServices(t *testing.T) {
+	for _, s := range []struct {
+		scheme string
+		init   func(addr string) NetService
+	}{
+		{"unix", func(addr string) NetService { return InitBaseTcpService(addr, log.StandardLogger()) }},
+		{"unixgram", func(addr string) NetService { return InitBaseUdpService(addr, 1024) }},
+	} {
+		t.Run(s.scheme, func(t *testing.T) {
+			dir := t.TempDir()
+			addr := s.scheme + "://" + filepath.Join(dir, "receiver.sock")
+			service := s.init(addr)
+			receiver := service.RegisterListener("receiver")
+			receiver.RegisterMessage(registryMsg{code: 1})
+			senderService := s.init(s.scheme + "://" + filepath.Join(dir, "sender.sock"))
+			defer senderService.Close()
+			sender := senderService.RegisterListener("sender")
+			sender.RegisterMessage(registryMsg{code: 1})
+
+			conn, err := sender.OpenTo(addr, "receiver")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if err := sender.SendTo(conn, registryMsg{code: 1, seq: 42}); err != nil {
+				t.Fatal(err)
+			}
+			var accepted *ServiceHostConn
+			testutil.Within(t, testTimeout, "a connection", func() { accepted = <-receiver.Accept() })
+			if m, err := receiver.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 42 {
+				t.Fatalf("received %v, %v; expected message 42", m, err)
+			}
+
+			if err := service.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "receiver.sock")); !os.IsNotExist(err) {
+				t.Fatalf("socket file left after Close: %v", err)
+			}
+		})
+	}
+}
@@ -5,14 +5,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 )
 
 // EncodeString encodes a string to a byte array
 func EncodeString(s string) ([]byte, error) {
 	b := new(bytes.Buffer)
-	err := EncodeStringToBuffer(s, b)
-	if err != nil {
-		panic(err)
+	if err := EncodeStringToBuffer(s, b); err != nil {
 		return nil, err
 	}
 	return b.Bytes(), nil
@@ -34,7 +33,6 @@ func EncodeStringToBuffer(s string, buffer *bytes.Buffer) error {
 func DecodeStringFromBuffer(buffer *bytes.Buffer) (string, error) {
 	sb, err := DecodeBytesFromBuffer(buffer)
 	if err != nil {
-		panic(err)
 		return "", err
 	}
 	return string(sb), nil
@@ -42,61 +40,42 @@ func DecodeStringFromBuffer(buffer *bytes.Buffer) (string, error) {
 
 // EncodeBytesToBuffer encodes a byte array to a buffer
 func EncodeBytesToBuffer(b []byte, buffer *bytes.Buffer) error {
-	err := binary.Write(buffer, binary.BigEndian, uint16(len(b)))
-	if err != nil {
-		panic(err)
-		return err
-	}
-	n, err := buffer.Write(b)
-	if err != nil {
-		panic(err)
-		return err
+	if len(b) > math.MaxUint16 {
+		// the length is a u16, a longer array would be truncated on the wire
+		return errors.New(fmt.Sprint("Unable to encode ", len(b), " bytes, the maximum is ", math.MaxUint16))
 	}
-	if n != len(b) {
-		err = errors.New(fmt.Sprint("Expected to write ", len(b), " wrote ", n))
-		panic(err)
+	if err := binary.Write(buffer, binary.BigEndian, uint16(len(b))); err != nil {
 		return err
 	}
-	return nil
+	_, err := buffer.Write(b)
+	return err
 }
 
 // DecodeBytesFromBuffer decodes a byte array from a buffer
+// It fails, without consuming the array, if the buffer holds less bytes than its length.
 func DecodeBytesFromBuffer(buffer *bytes.Buffer) ([]byte, error) {
 	var bLen uint16
-	err := binary.Read(buffer, binary.BigEndian, &bLen)
-	if err != nil {
-		panic(err)
-		return nil, err
+	if err := binary.Read(buffer, binary.BigEndian, &bLen); err != nil {
+		return nil, errors.New(fmt.Sprint("Unable to read the length of a byte array: ", err))
 	}
-
-	b := make([]byte, bLen)
-	n, err := buffer.Read(b)
-	if err != nil {
-		panic(err)
-		return nil, err
-	}
-	if n != int(bLen) {
-		err = errors.New(fmt.Sprint("Expected to read ", bLen, " read ", n))
-		panic(err)
-		return nil, err
+	if buffer.Len() < int(bLen) {
+		return nil, errors.New(fmt.Sprint("Expected to read ", bLen, " bytes, only ", buffer.Len(), " left"))
 	}
-
+	b := make([]byte, bLen)
+	copy(b, buffer.Next(int(bLen)))
 	return b, nil
 }
 
 // EncodeNumberToBuffer encodes a number to a buffer
 func EncodeNumberToBuffer(n interface{}, buffer *bytes.Buffer) error {
-	if err := binary.Write(buffer, binary.BigEndian, n); err != nil {
-		panic(err)
-	}
-	return nil
+	return binary.Write(buffer, binary.BigEndian, n)
 }
 
 // DecodeNumberFromBuffer decodes a number from a buffer
 // Note that nPointer must be a pointer to the type of the number
 func DecodeNumberFromBuffer(nPointer interface{}, buffer *bytes.Buffer) error {
 	if err := binary.Read(buffer, binary.BigEndian, nPointer); err != nil {
-		panic(err)
+		return errors.New(fmt.Sprint("Unable to read a number: ", err))
 	}
 	return nil
 }
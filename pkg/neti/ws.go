@@ -0,0 +1,294 @@
+package neti
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const wsSubprotocol = "neti"
+
+type wsHostConn struct {
+	conn     *websocket.Conn
+	addr     net.Addr
+	sendLock *sync.Mutex
+	closed   chan struct{}
+	once     *sync.Once
+}
+
+func (w wsHostConn) String() string {
+	return w.Addr().String()
+}
+
+func (w wsHostConn) Addr() net.Addr {
+	return w.addr
+}
+
+// Send sends b as a single binary frame.
+func (w wsHostConn) Send(b []byte) error {
+	w.sendLock.Lock()
+	defer w.sendLock.Unlock()
+	return websocket.Message.Send(w.conn, b)
+}
+
+// Receive receives the next binary frame.
+func (w wsHostConn) Receive() ([]byte, error) {
+	var b []byte
+	err := websocket.Message.Receive(w.conn, &b)
+	return b, err
+}
+
+func (w wsHostConn) Close() error {
+	w.once.Do(func() { close(w.closed) })
+	return w.conn.Close()
+}
+
+func newWsHostConn(conn *websocket.Conn, addr net.Addr) wsHostConn {
+	return wsHostConn{
+		conn:     conn,
+		addr:     addr,
+		sendLock: &sync.Mutex{},
+		closed:   make(chan struct{}),
+		once:     &sync.Once{},
+	}
+}
+
+// WsNet is a Net over WebSocket connections.
+// Each frame of the tcp Net is carried in a binary WebSocket frame.
+// It is also an http.Handler, so it can be mounted on an existing http server instead of calling Listen with an address.
+type WsNet interface {
+	Net
+	http.Handler
+}
+
+// NewWsNet creates a new WsNet.
+// Listen("ws://host:port/path") starts an http server accepting connections on path,
+// Listen("") only returns the channel of connections accepted through ServeHTTP.
+// Open dials ws:// and wss:// urls.
+// Connections from browsers are only accepted from the same origin, or those of WithAllowedOrigins.
+// It panics if opts set the cluster key, encryption, compression or peer limits, which WebSockets do not apply:
+// secure connections with wss:// instead.
+func NewWsNet(log *logrus.Logger, opts ...Option) WsNet {
+	return newWsNet(log, newOptions(opts))
+}
+
+// newWsNet creates a WsNet publishing to the events, metrics and capture of opts.
+func newWsNet(log *logrus.Logger, opts *options) *ws {
+	rejectConnectionOptions("WebSocket", opts)
+	return &ws{
+		tcp: tcp{
+			network:          "ws",
+			msgDeserializers: newDeserializers(),
+			log:              log,
+			events:           opts.events,
+			metrics:          opts.metrics,
+			capture:          opts.capture,
+			interceptors:     newInterceptors(),
+		},
+		origins: opts.allowedOrigins,
+		acpt:    make(chan HostConn),
+		closed:  make(chan struct{}),
+		pending: &sync.WaitGroup{},
+		lock:    &sync.RWMutex{},
+	}
+}
+
+type ws struct {
+	tcp              //message registration, serialization and framing are shared with the tcp Net
+	origins []string //Origins allowed besides the one of the request
+	acpt    chan HostConn
+	server  *http.Server
+	closed  chan struct{}
+	pending *sync.WaitGroup //handlers handing a connection to acpt, which is closed once they return
+	lock    *sync.RWMutex
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and hands it to the channel returned by Listen.
+func (w *ws) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, request *http.Request) error {
+			if err := w.checkOrigin(request); err != nil {
+				return err
+			}
+			config.Protocol = []string{wsSubprotocol}
+			return nil
+		},
+		Handler: w.handle,
+	}
+	server.ServeHTTP(writer, request)
+}
+
+// checkOrigin accepts the requests from the origin of the request itself or one of the allowed origins, so that other
+// sites cannot open connections from the browsers of their visitors. Requests without an Origin, which browsers always
+// send, are accepted: they come from peers that are not browsers.
+func (w *ws) checkOrigin(request *http.Request) error {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Host, request.Host) {
+		return nil
+	}
+	for _, allowed := range w.origins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), u.Scheme+"://"+u.Host) {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("origin %v is not allowed", origin))
+}
+
+// WithAllowedOrigins sets the origins (scheme://host[:port]) of the pages allowed to open WebSocket connections
+// besides the origin of the service itself.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(o *options) {
+		o.allowedOrigins = append(o.allowedOrigins, origins...)
+	}
+}
+
+func (w *ws) handle(conn *websocket.Conn) {
+	conn.PayloadType = websocket.BinaryFrame
+	var addr net.Addr = conn.RemoteAddr()
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", conn.Request().RemoteAddr); err == nil {
+		addr = tcpAddr
+	}
+	hConn := newWsHostConn(conn, addr)
+
+	w.lock.RLock()
+	select {
+	case <-w.closed:
+		w.lock.RUnlock()
+		_ = conn.Close()
+		return
+	default:
+		w.pending.Add(1)
+	}
+	w.lock.RUnlock()
+	// the lock is not held while waiting to be accepted, so CloseListener is not blocked by connections nobody accepts
+	select {
+	case w.acpt <- hConn:
+		w.pending.Done()
+	case <-w.closed:
+		w.pending.Done()
+		_ = conn.Close()
+		return
+	}
+	// the connection is closed by the websocket server once the handler returns
+	select {
+	case <-hConn.closed:
+	case <-w.closed:
+		_ = hConn.Close()
+	}
+}
+
+func (w *ws) Listen(addr string) (<-chan HostConn, error) {
+	if addr == "" {
+		return w.acpt, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, errors.New(fmt.Sprintf("unable to listen on %v: expected a ws:// url", addr))
+	}
+	listener, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, w)
+	w.server = &http.Server{Handler: mux}
+	go func() {
+		if err := w.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			w.log.Error("Error on serve ", err)
+		}
+	}()
+	return w.acpt, nil
+}
+
+func (w *ws) CloseListener() error {
+	w.lock.Lock()
+	select {
+	case <-w.closed:
+		w.lock.Unlock()
+		return nil
+	default:
+	}
+	close(w.closed)
+	w.lock.Unlock()
+	w.pending.Wait()
+	close(w.acpt)
+	if w.server != nil {
+		return w.server.Close()
+	}
+	return nil
+}
+
+func (w *ws) Open(addr string) (HostConn, error) {
+	origin := strings.Replace(addr, "ws", "http", 1)
+	config, err := websocket.NewConfig(addr, origin)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = []string{wsSubprotocol}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	conn.PayloadType = websocket.BinaryFrame
+	return newWsHostConn(conn, conn.RemoteAddr()), nil
+}
+
+func (w *ws) OpenAsync(addr string, ch chan<- ReceivedConnection) {
+	go func() {
+		conn, err := w.Open(addr)
+		ch <- ReceivedConnection{
+			Addr: addr,
+			Conn: conn,
+			Err:  err,
+		}
+	}()
+}
+
+// WsNetService is a NetService over WebSocket connections that is mounted on an http server.
+type WsNetService interface {
+	NetService
+	http.Handler
+}
+
+type wsService struct {
+	*basicTcpService
+	http.Handler
+}
+
+// InitBaseWsService creates a new service over WebSocket connections, serving them on listenAddr (ws://host:port/path).
+// NetClients open connections to other services with OpenTo("ws://host:port/path", id).
+// The options are those of NewWsNet and services, it panics if they set options WebSockets do not apply.
+func InitBaseWsService(listenAddr string, logger *logrus.Logger, opts ...Option) NetService {
+	o := newOptions(opts)
+	return initStreamService(listenAddr, newWsNet(logger, o), WS, logger, o)
+}
+
+// NewWsService creates a new service over WebSocket connections to be mounted on an existing http server.
+// self is the url other services use to reach it.
+func NewWsService(self string, logger *logrus.Logger, opts ...Option) WsNetService {
+	o := newOptions(opts)
+	net := newWsNet(logger, o)
+	service := initStreamService("", net, WS, logger, o)
+	service.self = self
+	return wsService{service, net}
+}
@@ -0,0 +1,184 @@
+package neti
+
+import (
+	"errors"
+	"github.com/pedroAkos/go-simple-networking/pkg/neti/internal/testutil"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func wsUrl(t *testing.T) string {
+	return "ws://" + freeAddr(t, "tcp") + "/neti"
+}
+
+func TestWsCloseListenerWithoutAccept(t *testing.T) {
+	server := NewWsNet(log.StandardLogger())
+	addr := wsUrl(t)
+	conns, err := server.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connection is upgraded, and its handler waits for someone to accept it
+	conn, err := NewWsNet(log.StandardLogger()).Open(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testutil.Within(t, testTimeout, "CloseListener", func() {
+		if err := server.CloseListener(); err != nil {
+			t.Error(err)
+		}
+	})
+	testutil.Within(t, testTimeout, "the accepted connections to be closed", func() {
+		for range conns {
+		}
+	})
+	if _, err := conn.Receive(); err == nil {
+		t.Fatal("the connection that was never accepted is still open")
+	}
+}
+
+func TestWsRoundTrip(t *testing.T) {
+	server := NewWsNet(log.StandardLogger())
+	server.RegisterMessage(registryMsg{code: 1})
+	// mounted on an http server instead of listening
+	conns, err := server.Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	http := httptest.NewServer(server)
+	defer http.Close()
+	defer server.CloseListener()
+	client := NewWsNet(log.StandardLogger())
+	client.RegisterMessage(registryMsg{code: 1})
+	conn, err := client.Open(strings.Replace(http.URL, "http", "ws", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted HostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-conns })
+
+	if err := client.SendTo(conn, registryMsg{code: 1, seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := server.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+	if err := server.SendTo(accepted, registryMsg{code: 1, seq: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := client.RecvFrom(conn); err != nil || m.(registryMsg).seq != 2 {
+		t.Fatalf("received %v, %v; expected message 2", m, err)
+	}
+}
+
+func TestWsServiceCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	capture, err := NewCapture(path, CaptureConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := wsUrl(t)
+	service := InitBaseWsService(addr, log.StandardLogger(), WithCapture(capture))
+	defer service.Close()
+	receiver := service.RegisterListener("receiver")
+	receiver.RegisterMessage(registryMsg{code: 1})
+	senderService := InitBaseWsService(wsUrl(t), log.StandardLogger())
+	defer senderService.Close()
+	sender := senderService.RegisterListener("sender")
+
+	conn, err := sender.OpenTo(addr, "receiver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var accepted *ServiceHostConn
+	testutil.Within(t, testTimeout, "a connection", func() { accepted = <-receiver.Accept() })
+	_ = sender.SendTo(conn, registryMsg{code: 1, seq: 1})
+	if m, err := receiver.RecvFrom(accepted); err != nil || m.(registryMsg).seq != 1 {
+		t.Fatalf("received %v, %v; expected message 1", m, err)
+	}
+	_ = capture.Close()
+
+	frames, err := LoadCapture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if f.Direction == Received && f.Code == 1 {
+			return
+		}
+	}
+	t.Fatalf("the message received over ws is not in the capture %+v", frames)
+}
+
+func TestWsCheckOrigin(t *testing.T) {
+	server := NewWsNet(log.StandardLogger(), WithAllowedOrigins("https://app.example.com"))
+	if _, err := server.Listen(""); err != nil {
+		t.Fatal(err)
+	}
+	http := httptest.NewServer(server)
+	defer http.Close()
+	defer server.CloseListener()
+	addr := strings.Replace(http.URL, "http", "ws", 1)
+	for _, test := range []struct {
+		origin string
+		ok     bool
+	}{
+		{http.URL, true},
+		{"https://app.example.com", true},
+		{"https://evil.example.com", false},
+	} {
+		config, err := websocket.NewConfig(addr, test.origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Protocol = []string{wsSubprotocol}
+		conn, err := websocket.DialConfig(config)
+		if (err == nil) != test.ok {
+			t.Fatalf("opening a connection from %v failed with %v, expected it to succeed: %v", test.origin, err, test.ok)
+		}
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+func TestWsRejectsConnectionOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"ClusterKey":  WithClusterKey(testClusterKey),
+		"Compression": WithCompression(0, Snappy),
+		"PeerLimits":  WithLimits(Limits{PeerRate: 10}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("created a WebSocket Net with an option it does not apply")
+				}
+			}()
+			NewWsNet(log.StandardLogger(), opt)
+		})
+	}
+	// the service limits are applied by services
+	NewWsNet(log.StandardLogger(), WithLimits(Limits{ServiceRate: 10}))
+}
+
+func TestWsServiceAuthorizer(t *testing.T) {
+	addr := wsUrl(t)
+	deny := AuthorizerFunc(func(req AuthorizationRequest) error { return errors.New("denied") })
+	service := InitBaseWsService(addr, log.StandardLogger(), WithAuthorizer(deny))
+	defer service.Close()
+	service.RegisterListener("receiver")
+	senderService := InitBaseWsService(wsUrl(t), log.StandardLogger())
+	defer senderService.Close()
+	var rejected *HandshakeRejectedError
+	if _, err := senderService.RegisterListener("sender").OpenTo(addr, "receiver"); !errors.As(err, &rejected) ||
+		rejected.Reason != RejectUnauthorized {
+		t.Fatalf("opening a connection failed with %v, expected the rejection of the authorizer", err)
+	}
+}